@@ -4,6 +4,7 @@ import (
 	"log"
 	"os"
 	"strconv"
+	"strings"
 
 	"github.com/joho/godotenv"
 )
@@ -27,6 +28,29 @@ type Config struct {
 	AirtelClientID   string
 	AirtelClientSecret string
 	Environment      string
+	IdempotencyTTLHours int
+	ReturnWindowDays int
+	SubscriptionIntervalMinutes int
+	SubscriptionMaxAttempts int
+	SubscriptionRetryHours int
+	SellerName       string
+	SellerKRAPIN     string
+	SellerAddress    string
+	SellerPhone      string
+	SellerEmail      string
+	SendyAPIURL      string
+	SendyAPIKey      string
+	SendyWebhookSecret string
+	CarrierPollMinutes int
+	FakeCarrierEnabled bool
+	OrderNumberPrefix string
+	OrderNumberDigits int
+	CartReservationMinutes int
+	FrontendURL      string
+	AbandonedCartReminderHours []int
+	AbandonedCartCheckMinutes int
+	AbandonedCartCouponPercent int
+	AbandonedCartCouponDays int
 }
 
 func LoadConfig() *Config {
@@ -36,6 +60,12 @@ func LoadConfig() *Config {
 	}
 
 	smtpPort, _ := strconv.Atoi(getEnv("SMTP_PORT", "587"))
+	idempotencyTTL, _ := strconv.Atoi(getEnv("IDEMPOTENCY_TTL_HOURS", "24"))
+	returnWindow, _ := strconv.Atoi(getEnv("RETURN_WINDOW_DAYS", "7"))
+	orderNumberDigits, _ := strconv.Atoi(getEnv("ORDER_NUMBER_DIGITS", "4"))
+	cartReservation, _ := strconv.Atoi(getEnv("CART_RESERVATION_MINUTES", "0"))
+	abandonedCartCouponPercent, _ := strconv.Atoi(getEnv("ABANDONED_CART_COUPON_PERCENT", "0"))
+	abandonedCartCouponDays, _ := strconv.Atoi(getEnv("ABANDONED_CART_COUPON_DAYS", "7"))
 
 	return &Config{
 		DatabaseURL:         getEnv("DATABASE_URL", "host=postgres user=postgres password=postgres dbname=sakifarm port=5432 sslmode=disable"),
@@ -56,6 +86,29 @@ func LoadConfig() *Config {
 		AirtelClientID:     getEnv("AIRTEL_CLIENT_ID", ""),
 		AirtelClientSecret: getEnv("AIRTEL_CLIENT_SECRET", ""),
 		Environment:        getEnv("ENVIRONMENT", "development"),
+		IdempotencyTTLHours: idempotencyTTL,
+		ReturnWindowDays:   returnWindow,
+		SubscriptionIntervalMinutes: getEnvPositiveInt("SUBSCRIPTION_INTERVAL_MINUTES", 15),
+		SubscriptionMaxAttempts:     getEnvPositiveInt("SUBSCRIPTION_MAX_PAYMENT_ATTEMPTS", 3),
+		SubscriptionRetryHours:      getEnvPositiveInt("SUBSCRIPTION_RETRY_HOURS", 24),
+		SellerName:         getEnv("SELLER_NAME", "SakiFarm Ecommerce"),
+		SellerKRAPIN:       getEnv("SELLER_KRA_PIN", ""),
+		SellerAddress:      getEnv("SELLER_ADDRESS", ""),
+		SellerPhone:        getEnv("SELLER_PHONE", ""),
+		SellerEmail:        getEnv("SELLER_EMAIL", ""),
+		SendyAPIURL:        getEnv("SENDY_API_URL", "https://api.sendyit.com/v1"),
+		SendyAPIKey:        getEnv("SENDY_API_KEY", ""),
+		SendyWebhookSecret: getEnv("SENDY_WEBHOOK_SECRET", ""),
+		CarrierPollMinutes: getEnvPositiveInt("CARRIER_POLL_MINUTES", 30),
+		FakeCarrierEnabled: getEnv("ENABLE_FAKE_CARRIER", "false") == "true",
+		OrderNumberPrefix:  getEnv("ORDER_NUMBER_PREFIX", "SF"),
+		OrderNumberDigits:  orderNumberDigits,
+		CartReservationMinutes: cartReservation,
+		FrontendURL:        getEnv("FRONTEND_URL", "http://localhost:3000"),
+		AbandonedCartReminderHours: getEnvInts("ABANDONED_CART_REMINDER_HOURS", ""),
+		AbandonedCartCheckMinutes:  getEnvPositiveInt("ABANDONED_CART_CHECK_MINUTES", 30),
+		AbandonedCartCouponPercent: abandonedCartCouponPercent,
+		AbandonedCartCouponDays:    abandonedCartCouponDays,
 	}
 }
 
@@ -65,3 +118,25 @@ func getEnv(key, defaultValue string) string {
 	}
 	return defaultValue
 }
+
+// getEnvPositiveInt reads a number that must be above zero, such as a scheduler interval,
+// falling back to the default when it is missing, not a number or not positive
+func getEnvPositiveInt(key string, defaultValue int) int {
+	value, err := strconv.Atoi(getEnv(key, strconv.Itoa(defaultValue)))
+	if err != nil || value <= 0 {
+		log.Printf("%s must be a positive number, using %d", key, defaultValue)
+		return defaultValue
+	}
+	return value
+}
+
+// getEnvInts reads a comma separated list of numbers, skipping any that do not parse
+func getEnvInts(key, defaultValue string) []int {
+	var values []int
+	for _, field := range strings.Split(getEnv(key, defaultValue), ",") {
+		if value, err := strconv.Atoi(strings.TrimSpace(field)); err == nil {
+			values = append(values, value)
+		}
+	}
+	return values
+}
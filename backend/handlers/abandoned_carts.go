@@ -0,0 +1,336 @@
+package handlers
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/yourname/sakifarm-ecommerce/models"
+	"github.com/yourname/sakifarm-ecommerce/services"
+	"gorm.io/gorm"
+)
+
+// cartRecoveryWindow is how long after a reminder an order counts as recovering the cart
+const cartRecoveryWindow = 7 * 24 * time.Hour
+
+// AbandonedCartHandler reminds signed-in customers about carts they have left idle. A
+// reminder goes out after each configured interval of inactivity; the last one can carry a
+// single-use discount code. Guest carts are skipped since there is nobody to contact.
+type AbandonedCartHandler struct {
+	db             *gorm.DB
+	emailService   *services.EmailService
+	smsService     *services.SMSService
+	intervals      []time.Duration
+	couponPercent  int
+	couponValidity time.Duration
+	frontendURL    string
+}
+
+func NewAbandonedCartHandler(db *gorm.DB, emailService *services.EmailService, smsService *services.SMSService, reminderHours []int, couponPercent int, couponValidity time.Duration, frontendURL string) *AbandonedCartHandler {
+	intervals := make([]time.Duration, 0, len(reminderHours))
+	for _, hours := range reminderHours {
+		if hours > 0 {
+			intervals = append(intervals, time.Duration(hours)*time.Hour)
+		}
+	}
+	sort.Slice(intervals, func(i, j int) bool { return intervals[i] < intervals[j] })
+
+	return &AbandonedCartHandler{
+		db:             db,
+		emailService:   emailService,
+		smsService:     smsService,
+		intervals:      intervals,
+		couponPercent:  couponPercent,
+		couponValidity: couponValidity,
+		frontendURL:    strings.TrimRight(frontendURL, "/"),
+	}
+}
+
+// AbandonedCartStageStats is how one reminder stage performed
+type AbandonedCartStageStats struct {
+	Stage     int   `json:"stage"`
+	Sent      int64 `json:"sent"`
+	Recovered int64 `json:"recovered"`
+}
+
+// AbandonedCartStats summarizes abandoned cart reminders and the orders they brought back
+type AbandonedCartStats struct {
+	RemindersSent    int64                     `json:"reminders_sent"`
+	CartsReminded    int64                     `json:"carts_reminded"`
+	CartsRecovered   int64                     `json:"carts_recovered"`
+	RecoveryRate     float64                   `json:"recovery_rate"` // percent of reminded carts recovered
+	RecoveredRevenue float64                   `json:"recovered_revenue"`
+	Stages           []AbandonedCartStageStats `json:"stages"`
+}
+
+// StartScheduler periodically sends reminders for idle carts. It does nothing when no
+// reminder intervals are configured.
+func (h *AbandonedCartHandler) StartScheduler(interval time.Duration) {
+	if len(h.intervals) == 0 {
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			h.ProcessAbandonedCarts()
+			<-ticker.C
+		}
+	}()
+}
+
+// ProcessAbandonedCarts credits newly paid orders to the reminders that led to them, then sends
+// the reminder each idle cart is due. Carts idle for more than a
+// day past the last interval are left alone, so turning reminders on does not message every
+// customer who ever left a cart behind.
+func (h *AbandonedCartHandler) ProcessAbandonedCarts() {
+	recordCartRecoveries(h.db)
+
+	now := time.Now()
+	first, last := h.intervals[0], h.intervals[len(h.intervals)-1]
+
+	var carts []models.Cart
+	if err := h.db.Where("user_id IS NOT NULL AND updated_at <= ? AND updated_at > ?", now.Add(-first), now.Add(-last-24*time.Hour)).
+		Where("EXISTS (SELECT 1 FROM cart_items WHERE cart_items.cart_id = carts.id)").
+		Find(&carts).Error; err != nil {
+		log.Printf("Failed to find abandoned carts: %v", err)
+		return
+	}
+
+	for _, cart := range carts {
+		if err := h.remindCart(cart, now); err != nil {
+			log.Printf("Cart %d: failed to send abandoned cart reminder: %v", cart.ID, err)
+		}
+	}
+}
+
+// remindCart sends the latest reminder stage the cart has reached, unless it or a later stage
+// already went out for this stretch of inactivity. Stages that were missed are not caught up.
+func (h *AbandonedCartHandler) remindCart(cart models.Cart, now time.Time) error {
+	idle := now.Sub(cart.UpdatedAt)
+	stage := -1
+	for i, interval := range h.intervals {
+		if idle >= interval {
+			stage = i
+		}
+	}
+	if stage < 0 {
+		return nil
+	}
+
+	var sent int64
+	if err := h.db.Model(&models.CartReminder{}).
+		Where("cart_id = ? AND sent_at > ? AND stage >= ?", cart.ID, cart.UpdatedAt, stage).
+		Count(&sent).Error; err != nil {
+		return err
+	}
+	if sent > 0 {
+		return nil
+	}
+
+	// A cart left behind after checkout is not abandoned
+	var ordered int64
+	if err := h.db.Model(&models.Order{}).
+		Where("user_id = ? AND created_at > ?", *cart.UserID, cart.UpdatedAt).
+		Count(&ordered).Error; err != nil {
+		return err
+	}
+	if ordered > 0 {
+		return nil
+	}
+
+	var user models.User
+	if err := h.db.First(&user, *cart.UserID).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil
+		}
+		return err
+	}
+
+	reminder := models.CartReminder{
+		CartID:         cart.ID,
+		UserID:         user.ID,
+		Stage:          stage,
+		CartActivityAt: cart.UpdatedAt,
+		SentAt:         now,
+	}
+
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		if stage == len(h.intervals)-1 && h.couponPercent > 0 {
+			coupon := models.Coupon{
+				Code:       "COMEBACK-" + strings.ToUpper(uuid.New().String()[:8]),
+				Type:       "percentage",
+				Value:      float64(h.couponPercent),
+				UsageLimit: 1,
+				IsActive:   true,
+				ExpiresAt:  now.Add(h.couponValidity),
+			}
+			if err := tx.Create(&coupon).Error; err != nil {
+				return err
+			}
+			reminder.CouponCode = coupon.Code
+		}
+		return tx.Create(&reminder).Error
+	})
+	if err != nil {
+		return err
+	}
+
+	// The reminder is recorded before sending so a failed delivery is not retried every run
+	link := fmt.Sprintf("%s/cart?reminder=%d", h.frontendURL, reminder.ID)
+	if reminder.CouponCode != "" {
+		link += "&coupon=" + reminder.CouponCode
+	}
+
+	var channels []string
+	if user.Email != "" {
+		if err := h.emailService.SendCartReminder(user.Email, user.FirstName, link, reminder.CouponCode, h.couponPercent); err != nil {
+			log.Printf("Cart %d: failed to email reminder: %v", cart.ID, err)
+		} else {
+			channels = append(channels, "email")
+		}
+	}
+	if user.Phone != "" {
+		message := "You left items in your SakiFarm cart. "
+		if reminder.CouponCode != "" {
+			message += fmt.Sprintf("Use code %s for %d%% off. ", reminder.CouponCode, h.couponPercent)
+		}
+		if err := h.smsService.SendSMS(user.Phone, message+link); err != nil {
+			log.Printf("Cart %d: failed to text reminder: %v", cart.ID, err)
+		} else {
+			channels = append(channels, "sms")
+		}
+	}
+
+	return h.db.Model(&reminder).Update("channels", strings.Join(channels, ",")).Error
+}
+
+// recordCartRecoveries credits paid orders to the abandoned cart reminders that brought their
+// customers back. Only orders placed within the recovery window after a reminder count, and
+// each order is credited once.
+func recordCartRecoveries(db *gorm.DB) {
+	// Payment can follow some time after the order, so orders from two windows back are checked
+	var orders []models.Order
+	if err := db.Where("payment_status = ? AND user_id IS NOT NULL AND created_at >= ?", "paid", time.Now().Add(-2*cartRecoveryWindow)).
+		Where("NOT EXISTS (SELECT 1 FROM cart_reminders WHERE cart_reminders.recovered_order_id = orders.id)").
+		Where("EXISTS (SELECT 1 FROM cart_reminders WHERE cart_reminders.user_id = orders.user_id AND cart_reminders.recovered_order_id IS NULL AND cart_reminders.sent_at <= orders.created_at)").
+		Find(&orders).Error; err != nil {
+		log.Printf("Failed to find recovered carts: %v", err)
+		return
+	}
+
+	for i := range orders {
+		recordCartRecovery(db, &orders[i])
+	}
+}
+
+// recordCartRecovery credits a paid order to the reminder that brought the customer back: the
+// one whose coupon the order used, or else the latest reminder sent before the order within
+// the recovery window
+func recordCartRecovery(db *gorm.DB, order *models.Order) {
+	unrecovered := func() *gorm.DB {
+		return db.Where("user_id = ? AND recovered_order_id IS NULL AND sent_at <= ? AND sent_at >= ?",
+			*order.UserID, order.CreatedAt, order.CreatedAt.Add(-cartRecoveryWindow))
+	}
+
+	var reminder models.CartReminder
+	err := unrecovered().Where("coupon_code = ? AND coupon_code != ''", order.CouponCode).First(&reminder).Error
+	if err == gorm.ErrRecordNotFound {
+		err = unrecovered().Order("sent_at DESC").First(&reminder).Error
+	}
+	if err != nil {
+		if err != gorm.ErrRecordNotFound {
+			log.Printf("Order %d: failed to look up cart reminders: %v", order.ID, err)
+		}
+		return
+	}
+
+	now := time.Now()
+	if err := db.Model(&reminder).Updates(map[string]interface{}{
+		"recovered_order_id": order.ID,
+		"recovered_at":       now,
+	}).Error; err != nil {
+		log.Printf("Order %d: failed to record cart recovery: %v", order.ID, err)
+	}
+}
+
+// abandonedCartStats reports on reminders sent in the given period. A cart counts once per
+// stretch of inactivity however many reminders it received.
+func abandonedCartStats(db *gorm.DB, from, to *time.Time) (*AbandonedCartStats, error) {
+	scope := func(query *gorm.DB) *gorm.DB {
+		if from != nil {
+			query = query.Where("cart_reminders.sent_at >= ?", *from)
+		}
+		if to != nil {
+			query = query.Where("cart_reminders.sent_at < ?", *to)
+		}
+		return query
+	}
+
+	stats := &AbandonedCartStats{Stages: []AbandonedCartStageStats{}}
+	if err := db.Model(&models.CartReminder{}).Scopes(scope).
+		Select("COUNT(*) AS reminders_sent, " +
+			"COUNT(DISTINCT (cart_id, cart_activity_at)) AS carts_reminded, " +
+			"COUNT(DISTINCT (cart_id, cart_activity_at)) FILTER (WHERE recovered_order_id IS NOT NULL) AS carts_recovered").
+		Scan(stats).Error; err != nil {
+		return nil, err
+	}
+
+	if err := db.Model(&models.CartReminder{}).Scopes(scope).
+		Joins("JOIN orders ON orders.id = cart_reminders.recovered_order_id").
+		Select("COALESCE(SUM(orders.total_amount), 0)").
+		Scan(&stats.RecoveredRevenue).Error; err != nil {
+		return nil, err
+	}
+
+	if err := db.Model(&models.CartReminder{}).Scopes(scope).
+		Select("stage, COUNT(*) AS sent, COUNT(recovered_order_id) AS recovered").
+		Group("stage").
+		Order("stage ASC").
+		Scan(&stats.Stages).Error; err != nil {
+		return nil, err
+	}
+
+	if stats.CartsReminded > 0 {
+		stats.RecoveryRate = float64(stats.CartsRecovered) / float64(stats.CartsReminded) * 100
+	}
+	return stats, nil
+}
+
+// GetAbandonedCartStats reports reminder and recovery figures, optionally for reminders
+// sent between the from and to dates
+func (h *AdminDashboardHandler) GetAbandonedCartStats(c *gin.Context) {
+	var from, to *time.Time
+	if value := c.Query("from"); value != "" {
+		fromDate, err := time.Parse("2006-01-02", value)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid from date, expected YYYY-MM-DD"})
+			return
+		}
+		from = &fromDate
+	}
+	if value := c.Query("to"); value != "" {
+		toDate, err := time.Parse("2006-01-02", value)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid to date, expected YYYY-MM-DD"})
+			return
+		}
+		toDate = toDate.AddDate(0, 0, 1)
+		to = &toDate
+	}
+
+	stats, err := abandonedCartStats(h.db, from, to)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch abandoned cart stats"})
+		return
+	}
+
+	c.JSON(http.StatusOK, stats)
+}
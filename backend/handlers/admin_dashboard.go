@@ -2,6 +2,7 @@ package handlers
 
 import (
 	"net/http"
+	"strconv"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -20,6 +21,7 @@ type DashboardStats struct {
 	TotalUsers       int64   `json:"totalUsers"`
 	PendingOrders    int64   `json:"pendingOrders"`
 	LowStockProducts int64   `json:"lowStockProducts"`
+	CartRecoveryRate float64 `json:"cartRecoveryRate"`
 }
 
 type OrderSummary struct {
@@ -44,6 +46,7 @@ type UserSummary struct {
 	FirstName   string    `json:"firstName"`
 	LastName    string    `json:"lastName"`
 	Email       string    `json:"email"`
+	Role        string    `json:"role"`
 	CreatedAt   time.Time `json:"createdAt"`
 	TotalOrders int64     `json:"totalOrders"`
 	TotalSpent  float64   `json:"totalSpent"`
@@ -83,6 +86,11 @@ func (h *AdminDashboardHandler) GetStats(c *gin.Context) {
 		Where("stock < ?", 10).
 		Count(&stats.LowStockProducts)
 
+	// Share of reminded abandoned carts that went on to order
+	if cartStats, err := abandonedCartStats(h.db, nil, nil); err == nil {
+		stats.CartRecoveryRate = cartStats.RecoveryRate
+	}
+
 	c.JSON(http.StatusOK, stats)
 }
 
@@ -106,6 +114,9 @@ func (h *AdminDashboardHandler) GetOrders(c *gin.Context) {
 		if order.User.ID != 0 {
 			customerName = order.User.FirstName + " " + order.User.LastName
 			customerEmail = order.User.Email
+		} else if order.GuestEmail != "" {
+			customerName = order.ShippingAddress.FirstName + " " + order.ShippingAddress.LastName + " (guest)"
+			customerEmail = order.GuestEmail
 		}
 
 		orderSummary := OrderSummary{
@@ -170,6 +181,7 @@ func (h *AdminDashboardHandler) GetUsers(c *gin.Context) {
 			FirstName:   user.FirstName,
 			LastName:    user.LastName,
 			Email:       user.Email,
+			Role:        user.Role,
 			CreatedAt:   user.CreatedAt,
 			TotalOrders: totalOrders,
 			TotalSpent:  totalSpent,
@@ -181,6 +193,46 @@ func (h *AdminDashboardHandler) GetUsers(c *gin.Context) {
 	c.JSON(http.StatusOK, userSummaries)
 }
 
+// UpdateUserRole moves a user between the customer and staff roles. Admins are
+// managed outside the API and vendor users through their vendor.
+func (h *AdminDashboardHandler) UpdateUserRole(c *gin.Context) {
+	userID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	var request struct {
+		Role string `json:"role" binding:"required,oneof=customer staff"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var user models.User
+	if err := h.db.First(&user, userID).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch user"})
+		return
+	}
+
+	if user.Role == "admin" || user.Role == "vendor" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Admin and vendor accounts cannot be changed here"})
+		return
+	}
+
+	if err := h.db.Model(&user).Update("role", request.Role).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update user role"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "User role updated. They need to log in again to pick up the new role"})
+}
+
 func (h *AdminDashboardHandler) UpdateOrderStatus(c *gin.Context) {
 	orderID := c.Param("id")
 	
@@ -194,7 +246,7 @@ func (h *AdminDashboardHandler) UpdateOrderStatus(c *gin.Context) {
 	}
 
 	// Validate status
-	validStatuses := []string{"pending", "processing", "shipped", "delivered", "cancelled"}
+	validStatuses := []string{"pending", "processing", "partially_shipped", "shipped", "delivered", "cancelled"}
 	isValid := false
 	for _, status := range validStatuses {
 		if request.Status == status {
@@ -209,9 +261,19 @@ func (h *AdminDashboardHandler) UpdateOrderStatus(c *gin.Context) {
 	}
 
 	// Update order status
-	if err := h.db.Model(&models.Order{}).
-		Where("id = ?", orderID).
-		Update("status", request.Status).Error; err != nil {
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&models.Order{}).
+			Where("id = ?", orderID).
+			Update("status", request.Status).Error; err != nil {
+			return err
+		}
+		if request.Status == "cancelled" {
+			id, _ := strconv.ParseUint(orderID, 10, 32)
+			return cancelVendorOrders(tx, uint(id))
+		}
+		return nil
+	})
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update order status"})
 		return
 	}
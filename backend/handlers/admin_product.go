@@ -26,7 +26,8 @@ type ProductRequest struct {
 	Name        string   `json:"name" binding:"required"`
 	Description string   `json:"description" binding:"required"`
 	Price       float64  `json:"price" binding:"required,min=0"`
-	Category    string   `json:"category" binding:"required"`
+	Category    string   `json:"category" binding:"required_without=CategoryID"` // a category name, if no ID is given
+	CategoryID  *uint    `json:"category_id"`
 	Stock       int      `json:"stock" binding:"min=0"`
 	Images      []string `json:"images"`
 	Tags        []string `json:"tags"`
@@ -37,21 +38,36 @@ type ProductRequest struct {
 	Status      string   `json:"status" binding:"required,oneof=active inactive draft"`
 	IsImported  bool     `json:"is_imported"`
 	ShippingFee float64  `json:"shipping_fee" binding:"min=0"`
+	BackorderMode       string     `json:"backorder_mode" binding:"omitempty,oneof=none backorder preorder"`
+	ExpectedAvailableAt *time.Time `json:"expected_available_at"`
+	BackorderLimit      int        `json:"backorder_limit" binding:"min=0"`
+	VendorID            *uint      `json:"vendor_id"` // admins only; vendors always create their own products
 }
 
 // GetProducts retrieves all products for admin
 func (h *AdminProductHandler) GetProducts(c *gin.Context) {
 	var products []models.Product
 	
+	// Vendors only manage their own products
+	vendorID, ok := currentVendorID(h.db, c)
+	if !ok {
+		return
+	}
+	
 	// Get query parameters for filtering
 	category := c.Query("category")
 	status := c.Query("status")
 	search := c.Query("search")
 	
-	query := h.db
+	query := h.db.Scopes(ownedByVendor("vendor_id", vendorID))
 	
+	// A category includes its subcategories
 	if category != "" {
-		query = query.Where("category = ?", category)
+		var err error
+		if query, err = filterByCategory(h.db, query, category, false); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch products"})
+			return
+		}
 	}
 	
 	if status != "" {
@@ -72,12 +88,21 @@ func (h *AdminProductHandler) GetProducts(c *gin.Context) {
 
 // CreateProduct creates a new product
 func (h *AdminProductHandler) CreateProduct(c *gin.Context) {
+	vendorID, ok := currentVendorID(h.db, c)
+	if !ok {
+		return
+	}
+	
 	var req ProductRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 	
+	if vendorID == nil {
+		vendorID = req.VendorID
+	}
+	
 	// Check if SKU already exists
 	var existingProduct models.Product
 	if err := h.db.Where("sku = ?", req.SKU).First(&existingProduct).Error; err == nil {
@@ -85,6 +110,11 @@ func (h *AdminProductHandler) CreateProduct(c *gin.Context) {
 		return
 	}
 	
+	category, ok := h.productCategory(c, req)
+	if !ok {
+		return
+	}
+	
 	// Convert string slice to comma-separated string for tags
 	var tagsStr string
 	if len(req.Tags) > 0 {
@@ -95,7 +125,8 @@ func (h *AdminProductHandler) CreateProduct(c *gin.Context) {
 		Name:        req.Name,
 		Description: req.Description,
 		Price:       req.Price,
-		Category:    req.Category,
+		Category:    category.Name,
+		CategoryID:  &category.ID,
 		Stock:       req.Stock,
 		Tags:        tagsStr,
 		SKU:         req.SKU,
@@ -105,6 +136,10 @@ func (h *AdminProductHandler) CreateProduct(c *gin.Context) {
 		Status:      req.Status,
 		IsImported:  req.IsImported,
 		ShippingFee: req.ShippingFee,
+		BackorderMode:       backorderMode(req.BackorderMode),
+		ExpectedAvailableAt: req.ExpectedAvailableAt,
+		BackorderLimit:      req.BackorderLimit,
+		VendorID:            vendorID,
 	}
 	
 	if err := h.db.Create(&product).Error; err != nil {
@@ -139,6 +174,11 @@ func (h *AdminProductHandler) UpdateProduct(c *gin.Context) {
 		return
 	}
 	
+	vendorID, ok := currentVendorID(h.db, c)
+	if !ok {
+		return
+	}
+	
 	var req ProductRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
@@ -146,7 +186,7 @@ func (h *AdminProductHandler) UpdateProduct(c *gin.Context) {
 	}
 	
 	var product models.Product
-	if err := h.db.First(&product, productID).Error; err != nil {
+	if err := h.db.Scopes(ownedByVendor("vendor_id", vendorID)).First(&product, productID).Error; err != nil {
 		if err == gorm.ErrRecordNotFound {
 			c.JSON(http.StatusNotFound, gin.H{"error": "Product not found"})
 			return
@@ -164,17 +204,26 @@ func (h *AdminProductHandler) UpdateProduct(c *gin.Context) {
 		}
 	}
 	
+	category, ok := h.productCategory(c, req)
+	if !ok {
+		return
+	}
+	
 	// Convert string slice to comma-separated string for tags
 	var tagsStr string
 	if len(req.Tags) > 0 {
 		tagsStr = strings.Join(req.Tags, ",")
 	}
 	
+	// Keep the old price and stock for wishlist alerts
+	before := product
+	
 	// Update product fields
 	product.Name = req.Name
 	product.Description = req.Description
 	product.Price = req.Price
-	product.Category = req.Category
+	product.Category = category.Name
+	product.CategoryID = &category.ID
 	product.Stock = req.Stock
 	product.Tags = tagsStr
 	product.SKU = req.SKU
@@ -182,16 +231,35 @@ func (h *AdminProductHandler) UpdateProduct(c *gin.Context) {
 	product.Dimensions = req.Dimensions
 	product.Brand = req.Brand
 	product.Status = req.Status
+	product.BackorderMode = backorderMode(req.BackorderMode)
+	product.ExpectedAvailableAt = req.ExpectedAvailableAt
+	product.BackorderLimit = req.BackorderLimit
+	if vendorID == nil && req.VendorID != nil {
+		product.VendorID = req.VendorID
+	}
 	
-	if err := h.db.Save(&product).Error; err != nil {
+	// backordered_qty is maintained by checkout and allocation only
+	if err := h.db.Omit("backordered_qty").Save(&product).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update product"})
 		return
 	}
 	
+	// Products with variants keep the total of their variants' stock
+	if err := syncProductStock(h.db, product.ID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update product"})
+		return
+	}
+	
+	// New stock goes to waiting backorders and pre-orders first
+	if _, err := allocateBackorders(h.db, product.ID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to allocate stock to backorders"})
+		return
+	}
+	
 	// Update product images if provided
 	if len(req.Images) > 0 {
-		// Delete existing images
-		h.db.Where("product_id = ?", productID).Delete(&models.ProductImage{})
+		// Delete existing images, leaving the variants' own
+		h.db.Where("product_id = ? AND variant_id IS NULL", productID).Delete(&models.ProductImage{})
 		
 		// Create new images
 		for i, imageURL := range req.Images {
@@ -210,6 +278,9 @@ func (h *AdminProductHandler) UpdateProduct(c *gin.Context) {
 	// Load images for response
 	h.db.Preload("Images").First(&product, product.ID)
 	
+	// Tell wishlisters about a price drop or restock
+	sendWishlistAlerts(h.db, before, product)
+	
 	c.JSON(http.StatusOK, product)
 }
 
@@ -221,8 +292,13 @@ func (h *AdminProductHandler) DeleteProduct(c *gin.Context) {
 		return
 	}
 	
+	vendorID, ok := currentVendorID(h.db, c)
+	if !ok {
+		return
+	}
+	
 	var product models.Product
-	if err := h.db.First(&product, productID).Error; err != nil {
+	if err := h.db.Scopes(ownedByVendor("vendor_id", vendorID)).First(&product, productID).Error; err != nil {
 		if err == gorm.ErrRecordNotFound {
 			c.JSON(http.StatusNotFound, gin.H{"error": "Product not found"})
 			return
@@ -302,6 +378,21 @@ func (h *AdminProductHandler) UploadProductImage(c *gin.Context) {
 	})
 }
 
+// productCategory resolves the category a product request names, writing the error response
+// when there is none
+func (h *AdminProductHandler) productCategory(c *gin.Context, req ProductRequest) (*models.Category, bool) {
+	category, err := productCategory(h.db, req.CategoryID, req.Category)
+	if err != nil {
+		if checkoutErr, ok := err.(*checkoutError); ok {
+			c.JSON(checkoutErr.status, gin.H{"error": checkoutErr.message})
+			return nil, false
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch category"})
+		return nil, false
+	}
+	return category, true
+}
+
 // GetProductStats returns product statistics for admin dashboard
 func (h *AdminProductHandler) GetProductStats(c *gin.Context) {
 	var stats struct {
@@ -315,20 +406,26 @@ func (h *AdminProductHandler) GetProductStats(c *gin.Context) {
 		} `json:"categories"`
 	}
 	
+	vendorID, ok := currentVendorID(h.db, c)
+	if !ok {
+		return
+	}
+	owned := ownedByVendor("vendor_id", vendorID)
+	
 	// Total products
-	h.db.Model(&models.Product{}).Count(&stats.TotalProducts)
+	h.db.Model(&models.Product{}).Scopes(owned).Count(&stats.TotalProducts)
 	
 	// Active products
-	h.db.Model(&models.Product{}).Where("status = ?", "active").Count(&stats.ActiveProducts)
+	h.db.Model(&models.Product{}).Scopes(owned).Where("status = ?", "active").Count(&stats.ActiveProducts)
 	
 	// Low stock products (less than 10)
-	h.db.Model(&models.Product{}).Where("stock < ? AND stock > 0", 10).Count(&stats.LowStockProducts)
+	h.db.Model(&models.Product{}).Scopes(owned).Where("stock < ? AND stock > 0", 10).Count(&stats.LowStockProducts)
 	
 	// Out of stock
-	h.db.Model(&models.Product{}).Where("stock = 0").Count(&stats.OutOfStock)
+	h.db.Model(&models.Product{}).Scopes(owned).Where("stock = 0").Count(&stats.OutOfStock)
 	
 	// Categories
-	h.db.Model(&models.Product{}).
+	h.db.Model(&models.Product{}).Scopes(owned).
 		Select("category, COUNT(*) as count").
 		Group("category").
 		Scan(&stats.Categories)
@@ -373,3 +470,11 @@ func (h *AdminProductHandler) ToggleFeatured(c *gin.Context) {
 		"featured": product.Featured,
 	})
 }
+
+// backorderMode defaults an unset backorder mode to none
+func backorderMode(mode string) string {
+	if mode == "" {
+		return "none"
+	}
+	return mode
+}
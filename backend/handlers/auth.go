@@ -1,6 +1,7 @@
 package handlers
 
 import (
+	"errors"
 	"net/http"
 
 	"github.com/gin-gonic/gin"
@@ -8,18 +9,23 @@ import (
 	"github.com/yourname/sakifarm-ecommerce/middleware"
 	"github.com/yourname/sakifarm-ecommerce/models"
 	"github.com/yourname/sakifarm-ecommerce/services"
+	"gorm.io/gorm"
 )
 
 type AuthHandler struct {
+	db          *gorm.DB
 	authService *services.AuthService
 	jwtSecret   string
+	cart        *CartHandler
 	validator   *validator.Validate
 }
 
-func NewAuthHandler(authService *services.AuthService, jwtSecret string) *AuthHandler {
+func NewAuthHandler(db *gorm.DB, authService *services.AuthService, jwtSecret string, cart *CartHandler) *AuthHandler {
 	return &AuthHandler{
+		db:          db,
 		authService: authService,
 		jwtSecret:   jwtSecret,
+		cart:        cart,
 		validator:   validator.New(),
 	}
 }
@@ -89,7 +95,7 @@ func (h *AuthHandler) Register(c *gin.Context) {
 		return
 	}
 
-	c.JSON(http.StatusCreated, gin.H{
+	response := gin.H{
 		"message": "User registered successfully",
 		"token":   token,
 		"user": gin.H{
@@ -100,7 +106,14 @@ func (h *AuthHandler) Register(c *gin.Context) {
 			"last_name":  user.LastName,
 			"role":       user.Role,
 		},
-	})
+	}
+
+	// Keep what the shopper put in their cart before signing in
+	if merge := h.cart.mergeCartOnSignIn(c, user.ID); merge != nil {
+		response["cart"] = merge
+	}
+
+	c.JSON(http.StatusCreated, response)
 }
 
 func (h *AuthHandler) Login(c *gin.Context) {
@@ -128,7 +141,7 @@ func (h *AuthHandler) Login(c *gin.Context) {
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{
+	response := gin.H{
 		"message": "Login successful",
 		"token":   token,
 		"user": gin.H{
@@ -139,7 +152,14 @@ func (h *AuthHandler) Login(c *gin.Context) {
 			"last_name":  user.LastName,
 			"role":       user.Role,
 		},
-	})
+	}
+
+	// Keep what the shopper put in their cart before signing in
+	if merge := h.cart.mergeCartOnSignIn(c, user.ID); merge != nil {
+		response["cart"] = merge
+	}
+
+	c.JSON(http.StatusOK, response)
 }
 
 func (h *AuthHandler) SendOTP(c *gin.Context) {
@@ -155,6 +175,10 @@ func (h *AuthHandler) SendOTP(c *gin.Context) {
 	}
 
 	if err := h.authService.SendOTP(req.Phone, req.Purpose); err != nil {
+		if errors.Is(err, services.ErrOTPTooSoon) {
+			c.JSON(http.StatusTooManyRequests, gin.H{"error": err.Error()})
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
@@ -213,7 +237,7 @@ func (h *AuthHandler) LoginWithOTP(c *gin.Context) {
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{
+	response := gin.H{
 		"message": "Login successful",
 		"token":   token,
 		"user": gin.H{
@@ -224,7 +248,14 @@ func (h *AuthHandler) LoginWithOTP(c *gin.Context) {
 			"last_name":  user.LastName,
 			"role":       user.Role,
 		},
-	})
+	}
+
+	// Keep what the shopper put in their cart before signing in
+	if merge := h.cart.mergeCartOnSignIn(c, user.ID); merge != nil {
+		response["cart"] = merge
+	}
+
+	c.JSON(http.StatusOK, response)
 }
 
 func (h *AuthHandler) ResetPassword(c *gin.Context) {
@@ -0,0 +1,197 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/yourname/sakifarm-ecommerce/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Order item statuses for items waiting on stock
+var waitingItemStatuses = []string{"backordered", "preordered"}
+
+type BackorderHandler struct {
+	db *gorm.DB
+}
+
+func NewBackorderHandler(db *gorm.DB) *BackorderHandler {
+	return &BackorderHandler{db: db}
+}
+
+// GetBackorders lists order items waiting for stock in allocation order (admin)
+func (h *BackorderHandler) GetBackorders(c *gin.Context) {
+	query := h.db.Preload("Product").
+		Joins("JOIN orders ON orders.id = order_items.order_id").
+		Where("order_items.status IN ? AND orders.status != ? AND orders.payment_status != ?", waitingItemStatuses, "cancelled", "failed")
+
+	if productID := c.Query("product_id"); productID != "" {
+		query = query.Where("order_items.product_id = ?", productID)
+	}
+
+	var items []models.OrderItem
+	if err := query.Order("orders.created_at ASC, order_items.id ASC").Find(&items).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch backorders"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"backorders": items})
+}
+
+// AllocateBackorders assigns available stock of a product to waiting orders (admin)
+func (h *BackorderHandler) AllocateBackorders(c *gin.Context) {
+	productID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid product ID"})
+		return
+	}
+
+	allocated, err := allocateBackorders(h.db, uint(productID))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to allocate stock"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":   fmt.Sprintf("%d waiting order items allocated", allocated),
+		"allocated": allocated,
+	})
+}
+
+// acceptsBackorder reports whether a product may be ordered beyond its stock
+func acceptsBackorder(product models.Product, quantity int) bool {
+	if product.BackorderMode != "backorder" && product.BackorderMode != "preorder" {
+		return false
+	}
+	return product.BackorderLimit == 0 || product.BackorderedQty+quantity <= product.BackorderLimit
+}
+
+// waitingStatus returns the order item status for a product ordered beyond its stock
+func waitingStatus(product models.Product) string {
+	if product.BackorderMode == "preorder" {
+		return "preordered"
+	}
+	return "backordered"
+}
+
+func isWaitingItem(item models.OrderItem) bool {
+	return item.Status == "backordered" || item.Status == "preordered"
+}
+
+// reserveOrderItems takes stock for allocated items and counts waiting items against the
+// product's backorder cap. The conditional updates make concurrent checkouts safe.
+func reserveOrderItems(tx *gorm.DB, items []models.OrderItem) error {
+	for _, item := range items {
+		taken := false
+		if isWaitingItem(item) {
+			result := tx.Model(&models.Product{}).
+				Where("id = ? AND (backorder_limit = 0 OR backordered_qty + ? <= backorder_limit)", item.ProductID, item.Quantity).
+				Update("backordered_qty", gorm.Expr("backordered_qty + ?", item.Quantity))
+			if result.Error != nil {
+				return result.Error
+			}
+			taken = result.RowsAffected > 0
+		} else {
+			var err error
+			if taken, err = takeStock(tx, item.ProductID, item.VariantID, item.Quantity); err != nil {
+				return err
+			}
+		}
+		if !taken {
+			var product models.Product
+			tx.First(&product, item.ProductID)
+			return &checkoutError{status: http.StatusConflict, message: fmt.Sprintf("Insufficient stock for product %s", product.Name)}
+		}
+	}
+	return nil
+}
+
+// releaseOrderItems gives back what reserveOrderItems took, e.g. when an order is cancelled.
+// Items already cancelled with their vendor's sub-order gave theirs back then.
+func releaseOrderItems(tx *gorm.DB, items []models.OrderItem) error {
+	for _, item := range items {
+		var err error
+		if item.Status == "cancelled" {
+			continue
+		}
+		if isWaitingItem(item) {
+			err = tx.Model(&models.Product{}).Where("id = ?", item.ProductID).
+				Update("backordered_qty", gorm.Expr("GREATEST(backordered_qty - ?, 0)", item.Quantity)).Error
+		} else {
+			err = returnStock(tx, item.ProductID, item.VariantID, item.Quantity)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// allocateBackorders hands a product's stock to waiting order items, oldest order first.
+// Allocation stops at the first item that does not fit so later orders never jump the queue.
+func allocateBackorders(db *gorm.DB, productID uint) (int, error) {
+	allocated := 0
+	var notifications []models.Notification
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var product models.Product
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&product, productID).Error; err != nil {
+			return err
+		}
+
+		var items []models.OrderItem
+		// Unverified guest orders have not joined the queue yet, and orders whose payment
+		// failed have left it
+		if err := tx.Joins("JOIN orders ON orders.id = order_items.order_id").
+			Where("order_items.product_id = ? AND order_items.status IN ? AND orders.status != ? AND orders.payment_status != ?", productID, waitingItemStatuses, "cancelled", "failed").
+			Where("COALESCE(orders.guest_phone, '') = '' OR orders.guest_verified = ?", true).
+			Order("orders.created_at ASC, order_items.id ASC").
+			Find(&items).Error; err != nil {
+			return err
+		}
+
+		stock := product.Stock
+		now := time.Now()
+		for _, item := range items {
+			if item.Quantity > stock {
+				break
+			}
+			stock -= item.Quantity
+
+			if err := tx.Model(&item).Updates(map[string]interface{}{
+				"status":       "allocated",
+				"allocated_at": now,
+			}).Error; err != nil {
+				return err
+			}
+			if err := tx.Model(&models.Product{}).Where("id = ?", productID).Updates(map[string]interface{}{
+				"stock":           gorm.Expr("stock - ?", item.Quantity),
+				"backordered_qty": gorm.Expr("GREATEST(backordered_qty - ?, 0)", item.Quantity),
+			}).Error; err != nil {
+				return err
+			}
+
+			var order models.Order
+			if err := tx.First(&order, item.OrderID).Error; err == nil && order.UserID != nil {
+				notifications = append(notifications, models.Notification{
+					UserID:  *order.UserID,
+					Title:   "Your item is now in stock",
+					Message: fmt.Sprintf("%s x%d on order %s has been allocated and will be shipped soon.", product.Name, item.Quantity, order.OrderNumber),
+					Type:    "order",
+				})
+			}
+			allocated++
+		}
+
+		if len(notifications) > 0 {
+			return tx.Create(&notifications).Error
+		}
+		return nil
+	})
+
+	return allocated, err
+}
@@ -0,0 +1,276 @@
+package handlers
+
+import (
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/yourname/sakifarm-ecommerce/models"
+	"github.com/yourname/sakifarm-ecommerce/services"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type BookConsignmentRequest struct {
+	Carrier string `json:"carrier" validate:"required"`
+}
+
+// BookConsignment books a pending shipment with a carrier and stores its tracking number (admin)
+func (h *ShipmentHandler) BookConsignment(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid shipment ID"})
+		return
+	}
+
+	var req BookConsignmentRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := h.validator.Struct(req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	carrier, ok := h.carriers[req.Carrier]
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown or unconfigured carrier"})
+		return
+	}
+
+	var shipment models.Shipment
+	if err := h.db.Preload("Items.OrderItem.Product").First(&shipment, id).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Shipment not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch shipment"})
+		return
+	}
+
+	if shipment.Status != "pending" || shipment.TrackingNumber != "" {
+		c.JSON(http.StatusConflict, gin.H{"error": "Shipment has already been handed to a carrier"})
+		return
+	}
+
+	var order models.Order
+	if err := h.db.First(&order, shipment.OrderID).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch order"})
+		return
+	}
+
+	consignment, err := carrier.CreateConsignment(&order, &shipment)
+	if err != nil {
+		log.Printf("Failed to book shipment %d with %s: %v", shipment.ID, req.Carrier, err)
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Carrier rejected the consignment"})
+		return
+	}
+
+	if err := h.db.Model(&shipment).Updates(map[string]interface{}{
+		"carrier":         req.Carrier,
+		"tracking_number": consignment.TrackingNumber,
+		"label_url":       consignment.LabelURL,
+	}).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save consignment"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":  "Consignment booked successfully",
+		"shipment": shipment,
+	})
+}
+
+// RefreshTracking pulls the latest tracking events for a shipment from its carrier (admin)
+func (h *ShipmentHandler) RefreshTracking(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid shipment ID"})
+		return
+	}
+
+	var shipment models.Shipment
+	if err := h.db.First(&shipment, id).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Shipment not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch shipment"})
+		return
+	}
+
+	carrier, ok := h.carriers[shipment.Carrier]
+	if !ok || shipment.TrackingNumber == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Shipment is not tracked by a configured carrier"})
+		return
+	}
+
+	events, err := carrier.FetchTrackingEvents(shipment.TrackingNumber)
+	if err != nil {
+		log.Printf("Failed to fetch tracking for shipment %d: %v", shipment.ID, err)
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Failed to fetch tracking events from carrier"})
+		return
+	}
+
+	if err := h.applyCarrierEvents(shipment.Carrier, events); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to store tracking events"})
+		return
+	}
+
+	h.db.Preload("TrackingEvents", func(db *gorm.DB) *gorm.DB {
+		return db.Order("occurred_at ASC")
+	}).First(&shipment, shipment.ID)
+
+	c.JSON(http.StatusOK, gin.H{"shipment": shipment})
+}
+
+// CarrierWebhook receives tracking updates pushed by a carrier
+func (h *ShipmentHandler) CarrierWebhook(c *gin.Context) {
+	name := c.Param("carrier")
+	carrier, ok := h.carriers[name]
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Unknown carrier"})
+		return
+	}
+
+	body, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to read request body"})
+		return
+	}
+
+	events, err := carrier.ParseWebhook(body, c.Request.Header)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := h.applyCarrierEvents(name, events); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to store tracking events"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Tracking update received"})
+}
+
+// StartTrackingPoller periodically refreshes in-flight shipments for carriers that
+// don't push every update
+func (h *ShipmentHandler) StartTrackingPoller(interval time.Duration) {
+	if len(h.carriers) == 0 {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	go func() {
+		for range ticker.C {
+			h.pollTracking()
+		}
+	}()
+}
+
+func (h *ShipmentHandler) pollTracking() {
+	names := make([]string, 0, len(h.carriers))
+	for name := range h.carriers {
+		names = append(names, name)
+	}
+
+	var shipments []models.Shipment
+	if err := h.db.Where("carrier IN ? AND tracking_number != '' AND status != ?", names, "delivered").
+		Find(&shipments).Error; err != nil {
+		log.Printf("Failed to load shipments for tracking: %v", err)
+		return
+	}
+
+	for _, shipment := range shipments {
+		events, err := h.carriers[shipment.Carrier].FetchTrackingEvents(shipment.TrackingNumber)
+		if err != nil {
+			log.Printf("Failed to fetch tracking for shipment %d: %v", shipment.ID, err)
+			continue
+		}
+		if err := h.applyCarrierEvents(shipment.Carrier, events); err != nil {
+			log.Printf("Failed to store tracking for shipment %d: %v", shipment.ID, err)
+		}
+	}
+}
+
+// applyCarrierEvents stores new events against their shipments and moves each shipment to
+// shipped once the carrier has it and to delivered once it reports delivery
+func (h *ShipmentHandler) applyCarrierEvents(carrier string, events []services.CarrierEvent) error {
+	byTrackingNumber := make(map[string][]services.CarrierEvent)
+	for _, event := range events {
+		byTrackingNumber[event.TrackingNumber] = append(byTrackingNumber[event.TrackingNumber], event)
+	}
+
+	for trackingNumber, shipmentEvents := range byTrackingNumber {
+		var shipment models.Shipment
+		if err := h.db.Where("carrier = ? AND tracking_number = ?", carrier, trackingNumber).First(&shipment).Error; err != nil {
+			if err == gorm.ErrRecordNotFound {
+				// Not one of ours, or booked outside the system
+				continue
+			}
+			return err
+		}
+
+		wasDelivered := shipment.Status == "delivered"
+		var order models.Order
+		err := h.db.Transaction(func(tx *gorm.DB) error {
+			for _, event := range shipmentEvents {
+				record := models.TrackingEvent{
+					OrderID:        shipment.OrderID,
+					ShipmentID:     shipment.ID,
+					Carrier:        carrier,
+					TrackingNumber: trackingNumber,
+					Status:         event.Status,
+					Description:    event.Description,
+					Location:       event.Location,
+					OccurredAt:     event.OccurredAt,
+				}
+				// Polling returns the full history, so already stored events are skipped
+				if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&record).Error; err != nil {
+					return err
+				}
+
+				switch event.Status {
+				case services.TrackingPickedUp, services.TrackingInTransit, services.TrackingOutForDelivery:
+					if shipment.Status == "pending" {
+						shipment.Status = "shipped"
+					}
+					if shipment.ShippedAt == nil {
+						occurredAt := event.OccurredAt
+						shipment.ShippedAt = &occurredAt
+					}
+				case services.TrackingDelivered:
+					occurredAt := event.OccurredAt
+					shipment.Status = "delivered"
+					shipment.DeliveredAt = &occurredAt
+					if shipment.ShippedAt == nil {
+						shipment.ShippedAt = &occurredAt
+					}
+				}
+			}
+
+			if err := tx.Omit("Items", "TrackingEvents").Save(&shipment).Error; err != nil {
+				return err
+			}
+			if err := refreshOrderFulfilmentStatus(tx, shipment.OrderID); err != nil {
+				return err
+			}
+			return tx.Preload("User").First(&order, shipment.OrderID).Error
+		})
+		if err != nil {
+			return err
+		}
+
+		// Notify the customer once the last shipment arrives
+		if !wasDelivered && shipment.Status == "delivered" && order.Status == "delivered" && h.emailService != nil {
+			go h.emailService.SendDeliveryNotification(order.User.Email, order.OrderNumber, shipment.TrackingNumber)
+		}
+	}
+
+	return nil
+}
@@ -1,61 +1,143 @@
 package handlers
 
 import (
+	"errors"
+	"log"
 	"net/http"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"github.com/yourname/sakifarm-ecommerce/middleware"
 	"github.com/yourname/sakifarm-ecommerce/models"
+	"github.com/yourname/sakifarm-ecommerce/pricing"
 )
 
+// CartHandler serves the cart of a signed-in user, or of an anonymous shopper identified by
+// a signed cart token in the X-Cart-Token header. With a reservation TTL set, cart lines hold
+// stock for that long after the shopper's last cart activity. Shared cart links point at the
+// storefront URL.
 type CartHandler struct {
-	db *gorm.DB
+	db             *gorm.DB
+	jwtSecret      string
+	reservationTTL time.Duration
+	frontendURL    string
 }
 
-func NewCartHandler(db *gorm.DB) *CartHandler {
-	return &CartHandler{db: db}
+func NewCartHandler(db *gorm.DB, jwtSecret string, reservationTTL time.Duration, frontendURL string) *CartHandler {
+	return &CartHandler{db: db, jwtSecret: jwtSecret, reservationTTL: reservationTTL, frontendURL: strings.TrimRight(frontendURL, "/")}
 }
 
 type AddToCartRequest struct {
-	ProductID uint `json:"product_id" binding:"required"`
-	Quantity  int  `json:"quantity" binding:"required,min=1"`
+	ProductID uint  `json:"product_id" binding:"required"`
+	VariantID *uint `json:"variant_id"` // required for products with variants
+	Quantity  int   `json:"quantity" binding:"required,min=1"`
+}
+
+type CartQuoteRequest struct {
+	FulfilmentType string `json:"fulfilment_type" binding:"omitempty,oneof=delivery pickup"`
+	CouponCode     string `json:"coupon_code"`
+}
+
+// CartResponse is a cart with the price the customer would pay for it at checkout and
+// warnings about lines that changed since they were added
+type CartResponse struct {
+	models.Cart
+	Pricing  *pricing.Breakdown `json:"pricing"`
+	Warnings []CartItemWarning  `json:"warnings"`
+}
+
+// CartAdjustment reports a guest cart line that could not be merged as is
+type CartAdjustment struct {
+	ProductID         uint   `json:"product_id"`
+	Name              string `json:"name"`
+	RequestedQuantity int    `json:"requested_quantity"`
+	Quantity          int    `json:"quantity"`
+	Reason            string `json:"reason"` // capped_at_stock, out_of_stock, unavailable
+}
+
+// CartMergeResult summarizes merging a guest cart into a user's cart
+type CartMergeResult struct {
+	Merged      int              `json:"merged"`
+	Adjustments []CartAdjustment `json:"adjustments"`
 }
 
 // GetCart retrieves user's cart
 func (h *CartHandler) GetCart(c *gin.Context) {
-	userID, exists := c.Get("user_id")
-	if !exists {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+	cart, err := h.findCart(c, false)
+	if err != nil {
+		h.cartError(c, err)
 		return
 	}
 
-	var cart models.Cart
-	if err := h.db.Preload("Items.Product.Images").Where("user_id = ?", userID).First(&cart).Error; err != nil {
-		if err == gorm.ErrRecordNotFound {
-			// Create new cart if doesn't exist
-			cart = models.Cart{UserID: userID.(uint)}
-			if err := h.db.Create(&cart).Error; err != nil {
-				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create cart"})
-				return
-			}
-		} else {
+	// Every line is checked against the catalogue; unavailable lines are not priced.
+	// Anonymous shoppers get a cart once they add something.
+	var response *CartResponse
+	if cart == nil {
+		response, err = h.priceCart(&models.Cart{Items: []models.CartItem{}})
+	} else {
+		response, err = h.cartResponse(cart.ID)
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch cart"})
+		return
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// QuoteCart prices the cart for a fulfilment type and coupon, exactly as checkout would
+func (h *CartHandler) QuoteCart(c *gin.Context) {
+	var req CartQuoteRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	cart, err := h.findCart(c, false)
+	if err != nil {
+		h.cartError(c, err)
+		return
+	}
+
+	// Lines that can no longer be bought are left out, as they are from the cart itself
+	var items []models.CartItem
+	if cart != nil {
+		if err := preloadCartProducts(h.db).First(cart, cart.ID).Error; err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch cart"})
 			return
 		}
+		items = availableCartItems(cart.Items)
 	}
 
-	c.JSON(http.StatusOK, cart)
-}
+	coupon, err := findCoupon(h.db, req.CouponCode)
+	if err != nil {
+		if checkoutErr, ok := err.(*checkoutError); ok {
+			c.JSON(checkoutErr.status, gin.H{"error": checkoutErr.message})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch coupon"})
+		return
+	}
 
-// AddToCart adds a product to user's cart
-func (h *CartHandler) AddToCart(c *gin.Context) {
-	userID, exists := c.Get("user_id")
-	if !exists {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+	breakdown, err := pricing.Calculate(cartPricingItems(items), pricing.Options{
+		FulfilmentType: req.FulfilmentType,
+		Coupon:         coupon,
+	})
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
+	c.JSON(http.StatusOK, gin.H{"pricing": breakdown})
+}
+
+// AddToCart adds a product to user's cart
+func (h *CartHandler) AddToCart(c *gin.Context) {
 	var req AddToCartRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
@@ -73,52 +155,40 @@ func (h *CartHandler) AddToCart(c *gin.Context) {
 		return
 	}
 
-	// Check stock availability
-	if product.Stock < req.Quantity {
+	variant, err := findOrderVariant(h.db, product, req.VariantID)
+	if err != nil {
+		if checkoutErr, ok := err.(*checkoutError); ok {
+			c.JSON(checkoutErr.status, gin.H{"error": checkoutErr.message})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	// Check stock availability, allowing backorders and pre-orders
+	stock := product.Stock
+	if variant != nil {
+		stock = variant.Stock
+	}
+	if stock < req.Quantity && !acceptsItemBackorder(product, variant, req.Quantity) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Insufficient stock"})
 		return
 	}
 
-	// Get or create user's cart
-	var cart models.Cart
-	if err := h.db.Where("user_id = ?", userID).First(&cart).Error; err != nil {
-		if err == gorm.ErrRecordNotFound {
-			cart = models.Cart{UserID: userID.(uint)}
-			if err := h.db.Create(&cart).Error; err != nil {
-				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create cart"})
-				return
-			}
-		} else {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch cart"})
-			return
-		}
+	// Get or create the cart
+	cart, err := h.findCart(c, true)
+	if err != nil {
+		h.cartError(c, err)
+		return
 	}
 
-	// Check if item already exists in cart
-	var existingItem models.CartItem
-	if err := h.db.Where("cart_id = ? AND product_id = ?", cart.ID, req.ProductID).First(&existingItem).Error; err == nil {
-		// Update quantity
-		newQuantity := existingItem.Quantity + req.Quantity
-		if product.Stock < newQuantity {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Insufficient stock"})
-			return
-		}
-		existingItem.Quantity = newQuantity
-		if err := h.db.Save(&existingItem).Error; err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update cart item"})
-			return
-		}
-	} else {
-		// Add new item
-		cartItem := models.CartItem{
-			CartID:    cart.ID,
-			ProductID: req.ProductID,
-			Quantity:  req.Quantity,
-		}
-		if err := h.db.Create(&cartItem).Error; err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to add item to cart"})
+	if _, err := h.addCartItem(h.db, cart, product, variant, req.Quantity); err != nil {
+		if checkoutErr, ok := err.(*checkoutError); ok {
+			c.JSON(checkoutErr.status, gin.H{"error": checkoutErr.message})
 			return
 		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to add item to cart"})
+		return
 	}
 
 	c.JSON(http.StatusOK, gin.H{"message": "Item added to cart successfully"})
@@ -126,12 +196,6 @@ func (h *CartHandler) AddToCart(c *gin.Context) {
 
 // UpdateCartItem updates quantity of a cart item
 func (h *CartHandler) UpdateCartItem(c *gin.Context) {
-	userID, exists := c.Get("user_id")
-	if !exists {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
-		return
-	}
-
 	itemID, err := strconv.ParseUint(c.Param("id"), 10, 32)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid item ID"})
@@ -147,21 +211,14 @@ func (h *CartHandler) UpdateCartItem(c *gin.Context) {
 	}
 
 	// Get cart item and verify ownership
-	var cartItem models.CartItem
-	if err := h.db.Joins("JOIN carts ON cart_items.cart_id = carts.id").
-		Where("cart_items.id = ? AND carts.user_id = ?", itemID, userID).
-		First(&cartItem).Error; err != nil {
-		if err == gorm.ErrRecordNotFound {
-			c.JSON(http.StatusNotFound, gin.H{"error": "Cart item not found"})
-			return
-		}
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+	cartItem, ok := h.findCartItem(c, itemID)
+	if !ok {
 		return
 	}
 
 	if req.Quantity == 0 {
 		// Remove item from cart
-		if err := h.db.Delete(&cartItem).Error; err != nil {
+		if err := h.deleteCartItem(cartItem); err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to remove item"})
 			return
 		}
@@ -176,29 +233,52 @@ func (h *CartHandler) UpdateCartItem(c *gin.Context) {
 		return
 	}
 
-	if product.Stock < req.Quantity {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Insufficient stock"})
-		return
+	var variant *models.ProductVariant
+	if cartItem.VariantID != nil {
+		variant = &models.ProductVariant{}
+		if err := h.db.First(variant, *cartItem.VariantID).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Product not found"})
+			return
+		}
 	}
 
-	// Update quantity
-	cartItem.Quantity = req.Quantity
-	if err := h.db.Save(&cartItem).Error; err != nil {
+	var stockErr error
+	err = h.db.Transaction(func(tx *gorm.DB) error {
+		if err := lockProductStock(tx, &product, variant); err != nil {
+			return err
+		}
+		available, err := availableStock(tx, product, variant, cartItem.CartID)
+		if err != nil {
+			return err
+		}
+
+		if available < req.Quantity && !acceptsItemBackorder(product, variant, req.Quantity) {
+			stockErr = errors.New("Insufficient stock")
+			return stockErr
+		}
+
+		// Update quantity
+		cartItem.Quantity = req.Quantity
+		if err := tx.Save(cartItem).Error; err != nil {
+			return err
+		}
+		return h.reserveCartItem(tx, *cartItem, available)
+	})
+	if err != nil {
+		if stockErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": stockErr.Error()})
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update cart item"})
 		return
 	}
+	touchCart(h.db, cartItem.CartID)
 
 	c.JSON(http.StatusOK, gin.H{"message": "Cart item updated successfully"})
 }
 
 // RemoveFromCart removes an item from cart
 func (h *CartHandler) RemoveFromCart(c *gin.Context) {
-	userID, exists := c.Get("user_id")
-	if !exists {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
-		return
-	}
-
 	itemID, err := strconv.ParseUint(c.Param("id"), 10, 32)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid item ID"})
@@ -206,19 +286,12 @@ func (h *CartHandler) RemoveFromCart(c *gin.Context) {
 	}
 
 	// Get cart item and verify ownership
-	var cartItem models.CartItem
-	if err := h.db.Joins("JOIN carts ON cart_items.cart_id = carts.id").
-		Where("cart_items.id = ? AND carts.user_id = ?", itemID, userID).
-		First(&cartItem).Error; err != nil {
-		if err == gorm.ErrRecordNotFound {
-			c.JSON(http.StatusNotFound, gin.H{"error": "Cart item not found"})
-			return
-		}
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+	cartItem, ok := h.findCartItem(c, itemID)
+	if !ok {
 		return
 	}
 
-	if err := h.db.Delete(&cartItem).Error; err != nil {
+	if err := h.deleteCartItem(cartItem); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to remove item"})
 		return
 	}
@@ -228,28 +301,350 @@ func (h *CartHandler) RemoveFromCart(c *gin.Context) {
 
 // ClearCart removes all items from user's cart
 func (h *CartHandler) ClearCart(c *gin.Context) {
-	userID, exists := c.Get("user_id")
-	if !exists {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+	cart, err := h.findCart(c, false)
+	if err != nil {
+		h.cartError(c, err)
+		return
+	}
+	if cart == nil {
+		c.JSON(http.StatusOK, gin.H{"message": "Cart is already empty"})
+		return
+	}
+
+	// Remove all items from cart, along with the stock they were holding
+	err = h.db.Transaction(func(tx *gorm.DB) error {
+		if err := releaseCartReservations(tx, cart.ID); err != nil {
+			return err
+		}
+		return tx.Where("cart_id = ?", cart.ID).Delete(&models.CartItem{}).Error
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to clear cart"})
 		return
 	}
 
-	// Get user's cart
+	c.JSON(http.StatusOK, gin.H{"message": "Cart cleared successfully"})
+}
+
+// addCartItem adds a quantity of a product, or of one of its variants, to a cart, on top of
+// any already there, and reserves the stock. Stock problems are returned as a *checkoutError.
+func (h *CartHandler) addCartItem(db *gorm.DB, cart *models.Cart, product models.Product, variant *models.ProductVariant, quantity int) (*models.CartItem, error) {
+	var cartItem models.CartItem
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := lockProductStock(tx, &product, variant); err != nil {
+			return err
+		}
+
+		// Stock held in other shoppers' carts is not for sale
+		available, err := availableStock(tx, product, variant, cart.ID)
+		if err != nil {
+			return err
+		}
+
+		var variantID *uint
+		if variant != nil {
+			variantID = &variant.ID
+		}
+
+		// Add to the existing line, if any
+		if err := cartLine(tx, cart.ID, product.ID, variantID).First(&cartItem).Error; err != nil {
+			if err != gorm.ErrRecordNotFound {
+				return err
+			}
+			cartItem = models.CartItem{CartID: cart.ID, ProductID: product.ID, VariantID: variantID}
+		}
+
+		newQuantity := cartItem.Quantity + quantity
+		if available < newQuantity && !acceptsItemBackorder(product, variant, newQuantity) {
+			return &checkoutError{http.StatusBadRequest, "Insufficient stock"}
+		}
+
+		// Adding to a line takes the price the shopper is now shown
+		cartItem.Quantity = newQuantity
+		cartItem.PriceAtAdd = itemPrice(product, variant)
+		if err := tx.Save(&cartItem).Error; err != nil {
+			return err
+		}
+
+		if err := h.reserveCartItem(tx, cartItem, available); err != nil {
+			return err
+		}
+		return tx.Model(&models.Cart{}).Where("id = ?", cart.ID).Update("updated_at", time.Now()).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &cartItem, nil
+}
+
+// deleteCartItem removes a cart line and the stock it was holding
+func (h *CartHandler) deleteCartItem(cartItem *models.CartItem) error {
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("cart_item_id = ?", cartItem.ID).Delete(&models.InventoryReservation{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(cartItem).Error
+	})
+	if err != nil {
+		return err
+	}
+
+	touchCart(h.db, cartItem.CartID)
+	return nil
+}
+
+// touchCart records cart activity, restarting the abandoned cart reminders
+func touchCart(db *gorm.DB, cartID uint) {
+	if err := db.Model(&models.Cart{}).Where("id = ?", cartID).Update("updated_at", time.Now()).Error; err != nil {
+		log.Printf("Failed to update activity for cart %d: %v", cartID, err)
+	}
+}
+
+// cartPricingItems prices cart lines at the products' and variants' current prices
+func cartPricingItems(items []models.CartItem) []pricing.Item {
+	lines := make([]pricing.Item, 0, len(items))
+	for _, item := range items {
+		lines = append(lines, pricing.Item{
+			ProductID: item.ProductID,
+			Name:      itemName(item.Product, item.Variant),
+			Quantity:  item.Quantity,
+			UnitPrice: itemPrice(item.Product, item.Variant),
+		})
+	}
+	return lines
+}
+
+// findCart loads the signed-in user's cart, or the guest cart named by the cart token.
+// With create set a missing cart is created, and new guest carts get a token. Otherwise
+// a missing cart is reported as nil. Guests are sent a refreshed token on every request,
+// and the cart's stock reservations are extended.
+func (h *CartHandler) findCart(c *gin.Context, create bool) (*models.Cart, error) {
 	var cart models.Cart
-	if err := h.db.Where("user_id = ?", userID).First(&cart).Error; err != nil {
+
+	if userID, exists := c.Get("user_id"); exists {
+		err := h.db.Where("user_id = ?", userID).First(&cart).Error
+		if err == gorm.ErrRecordNotFound && create {
+			uid := userID.(uint)
+			cart = models.Cart{UserID: &uid}
+			err = h.db.Create(&cart).Error
+		}
 		if err == gorm.ErrRecordNotFound {
-			c.JSON(http.StatusOK, gin.H{"message": "Cart is already empty"})
-			return
+			return nil, nil
 		}
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch cart"})
-		return
+		if err != nil {
+			return nil, err
+		}
+		h.extendReservations(cart.ID)
+		return &cart, nil
 	}
 
-	// Remove all items from cart
-	if err := h.db.Where("cart_id = ?", cart.ID).Delete(&models.CartItem{}).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to clear cart"})
+	var cartKey string
+	if token := c.GetHeader(middleware.CartTokenHeader); token != "" {
+		key, err := middleware.ParseCartToken(token, h.jwtSecret)
+		if err != nil {
+			return nil, err
+		}
+		cartKey = key
+	}
+
+	err := gorm.ErrRecordNotFound
+	if cartKey != "" {
+		err = h.db.Where("guest_key = ? AND user_id IS NULL", cartKey).First(&cart).Error
+	}
+	if err == gorm.ErrRecordNotFound && create {
+		cart = models.Cart{GuestKey: uuid.New().String()}
+		err = h.db.Create(&cart).Error
+	}
+	if err == gorm.ErrRecordNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	h.extendReservations(cart.ID)
+
+	token, err := middleware.GenerateCartToken(cart.GuestKey, h.jwtSecret)
+	if err != nil {
+		return nil, err
+	}
+	c.Header(middleware.CartTokenHeader, token)
+
+	return &cart, nil
+}
+
+// findCartItem loads an item of the current cart, writing the error response when it isn't one
+func (h *CartHandler) findCartItem(c *gin.Context, itemID uint64) (*models.CartItem, bool) {
+	cart, err := h.findCart(c, false)
+	if err != nil {
+		h.cartError(c, err)
+		return nil, false
+	}
+
+	var cartItem models.CartItem
+	if cart == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Cart item not found"})
+		return nil, false
+	}
+	if err := h.db.Where("id = ? AND cart_id = ?", itemID, cart.ID).First(&cartItem).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Cart item not found"})
+			return nil, false
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return nil, false
+	}
+	return &cartItem, true
+}
+
+func (h *CartHandler) cartError(c *gin.Context, err error) {
+	if err == gorm.ErrRecordNotFound {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Cart not found"})
+		return
+	}
+	if err == middleware.ErrInvalidCartToken {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid cart token"})
 		return
 	}
+	c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch cart"})
+}
 
-	c.JSON(http.StatusOK, gin.H{"message": "Cart cleared successfully"})
+// mergeGuestCart moves the items of the guest cart named by a cart token into a user's cart
+// after they log in or register. Quantities of products in both carts are added up and capped
+// at what can be sold, less what other carts hold, and the merged lines hold their stock in
+// turn; every line that had to change is reported. The guest cart is removed.
+func (h *CartHandler) mergeGuestCart(userID uint, cartToken string) (*CartMergeResult, error) {
+	cartKey, err := middleware.ParseCartToken(cartToken, h.jwtSecret)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &CartMergeResult{Adjustments: []CartAdjustment{}}
+	err = h.db.Transaction(func(tx *gorm.DB) error {
+		var guestCart models.Cart
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Preload("Items.Product").Preload("Items.Variant").
+			Where("guest_key = ? AND user_id IS NULL", cartKey).First(&guestCart).Error; err != nil {
+			if err == gorm.ErrRecordNotFound {
+				// Already merged, or expired
+				return nil
+			}
+			return err
+		}
+
+		var cart models.Cart
+		if err := tx.Where("user_id = ?", userID).FirstOrCreate(&cart, models.Cart{UserID: &userID}).Error; err != nil {
+			return err
+		}
+
+		// What the guest cart held is counted again for the merged lines below
+		if err := releaseCartReservations(tx, guestCart.ID); err != nil {
+			return err
+		}
+
+		for _, guestItem := range guestCart.Items {
+			product := guestItem.Product
+
+			variant := guestItem.Variant
+
+			var cartItem models.CartItem
+			inCart := cartLine(tx, cart.ID, product.ID, guestItem.VariantID).First(&cartItem).Error == nil
+			requested := cartItem.Quantity + guestItem.Quantity
+
+			// Deleted variants are not loaded
+			variantGone := guestItem.VariantID != nil && (variant == nil || !variant.IsActive)
+			if product.ID == 0 || product.Status != "active" || variantGone {
+				result.Adjustments = append(result.Adjustments, CartAdjustment{
+					ProductID:         guestItem.ProductID,
+					Name:              itemName(product, variant),
+					RequestedQuantity: guestItem.Quantity,
+					Quantity:          0,
+					Reason:            "unavailable",
+				})
+				continue
+			}
+
+			if err := lockProductStock(tx, &product, variant); err != nil {
+				return err
+			}
+			stock, err := availableStock(tx, product, variant, cart.ID)
+			if err != nil {
+				return err
+			}
+
+			quantity := requested
+			reason := ""
+			if stock < requested && !acceptsItemBackorder(product, variant, requested) {
+				quantity, reason = stock, "capped_at_stock"
+				if quantity <= 0 {
+					quantity, reason = 0, "out_of_stock"
+				}
+			}
+
+			if reason != "" {
+				result.Adjustments = append(result.Adjustments, CartAdjustment{
+					ProductID:         guestItem.ProductID,
+					Name:              itemName(product, variant),
+					RequestedQuantity: requested,
+					Quantity:          quantity,
+					Reason:            reason,
+				})
+			}
+
+			switch {
+			case inCart && quantity == 0:
+				if err := tx.Where("cart_item_id = ?", cartItem.ID).Delete(&models.InventoryReservation{}).Error; err != nil {
+					return err
+				}
+				if err := tx.Delete(&cartItem).Error; err != nil {
+					return err
+				}
+			case inCart:
+				cartItem.Quantity = quantity
+				if err := tx.Save(&cartItem).Error; err != nil {
+					return err
+				}
+			case quantity > 0:
+				cartItem = models.CartItem{CartID: cart.ID, ProductID: product.ID, VariantID: guestItem.VariantID, Quantity: quantity, PriceAtAdd: guestItem.PriceAtAdd}
+				if err := tx.Create(&cartItem).Error; err != nil {
+					return err
+				}
+			}
+			if quantity > 0 {
+				if err := h.reserveCartItem(tx, cartItem, stock); err != nil {
+					return err
+				}
+				result.Merged++
+			}
+		}
+
+		if err := tx.Model(&cart).Update("updated_at", time.Now()).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("cart_id = ?", guestCart.ID).Delete(&models.CartItem{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&guestCart).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// mergeCartOnSignIn merges the guest cart sent along with a login or registration, if any.
+// A failed merge never blocks signing in; the guest cart is simply left as it was.
+func (h *CartHandler) mergeCartOnSignIn(c *gin.Context, userID uint) *CartMergeResult {
+	cartToken := c.GetHeader(middleware.CartTokenHeader)
+	if cartToken == "" {
+		return nil
+	}
+
+	result, err := h.mergeGuestCart(userID, cartToken)
+	if err != nil {
+		log.Printf("Failed to merge guest cart for user %d: %v", userID, err)
+		return nil
+	}
+	return result
 }
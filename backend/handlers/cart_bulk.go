@@ -0,0 +1,312 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"errors"
+	"math/big"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/yourname/sakifarm-ecommerce/models"
+	"gorm.io/gorm"
+)
+
+// cartShareTTL is how long a shared cart link keeps working
+const cartShareTTL = 30 * 24 * time.Hour
+
+// shareCodeAlphabet leaves out characters that are easily confused when read aloud or typed
+const shareCodeAlphabet = "23456789ABCDEFGHJKLMNPQRSTUVWXYZabcdefghijkmnpqrstuvwxyz"
+
+// errCartLinesFailed rolls back a bulk add when any of its lines could not be added
+var errCartLinesFailed = errors.New("some cart lines could not be added")
+
+// CartLine names a product by ID or SKU, with the quantity to add. A variant is named by
+// its ID alongside the product ID, or by its own SKU.
+type CartLine struct {
+	ProductID uint   `json:"product_id"`
+	VariantID *uint  `json:"variant_id"`
+	SKU       string `json:"sku"`
+	Quantity  int    `json:"quantity" binding:"required,min=1"`
+}
+
+type BulkAddToCartRequest struct {
+	Items []CartLine `json:"items" binding:"required,min=1,max=200,dive"`
+}
+
+// CartLineResult reports what happened to one line of a bulk add
+type CartLineResult struct {
+	Line       int    `json:"line"` // position in the request, from 1
+	ProductID  uint   `json:"product_id,omitempty"`
+	VariantID  *uint  `json:"variant_id,omitempty"`
+	SKU        string `json:"sku,omitempty"`
+	Quantity   int    `json:"quantity"`
+	Status     string `json:"status"` // ok, error
+	Error      string `json:"error,omitempty"`
+	CartItemID uint   `json:"cart_item_id,omitempty"`
+}
+
+// BulkAddToCart adds many products to the cart in one go. Products are named by ID or SKU.
+// Either every line is added or, if any line fails, none are; the per-line results say which
+// lines need fixing.
+func (h *CartHandler) BulkAddToCart(c *gin.Context) {
+	var req BulkAddToCartRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	cart, err := h.findCart(c, true)
+	if err != nil {
+		h.cartError(c, err)
+		return
+	}
+
+	h.addCartLines(c, cart, req.Items, "Items added to cart successfully")
+}
+
+// addCartLines adds the lines to the cart in a single transaction and writes the response.
+// It reports whether the lines were added.
+func (h *CartHandler) addCartLines(c *gin.Context, cart *models.Cart, lines []CartLine, message string) bool {
+	results := make([]CartLineResult, len(lines))
+
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		failed := false
+		for i, line := range lines {
+			result := CartLineResult{Line: i + 1, ProductID: line.ProductID, VariantID: line.VariantID, SKU: line.SKU, Quantity: line.Quantity, Status: "ok"}
+
+			product, variant, problem, err := findCartLineProduct(tx, line)
+			if err != nil {
+				return err
+			}
+			if problem == "" {
+				result.ProductID, result.SKU = product.ID, product.SKU
+				if variant != nil {
+					result.VariantID, result.SKU = &variant.ID, variant.SKU
+				}
+
+				cartItem, err := h.addCartItem(tx, cart, *product, variant, line.Quantity)
+				if checkoutErr, ok := err.(*checkoutError); ok {
+					problem = checkoutErr.message
+				} else if err != nil {
+					return err
+				} else {
+					result.CartItemID = cartItem.ID
+				}
+			}
+
+			if problem != "" {
+				result.Status, result.Error = "error", problem
+				failed = true
+			}
+			results[i] = result
+		}
+
+		if failed {
+			return errCartLinesFailed
+		}
+		return nil
+	})
+	if err == errCartLinesFailed {
+		// Nothing was added, so no line keeps a cart item
+		for i := range results {
+			results[i].CartItemID = 0
+		}
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "No items were added because some lines could not be added",
+			"results": results,
+		})
+		return false
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to add items to cart"})
+		return false
+	}
+
+	response, err := h.cartResponse(cart.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch cart"})
+		return true
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": message,
+		"results": results,
+		"cart":    response,
+	})
+	return true
+}
+
+// findCartLineProduct looks up the product and variant a line names. A SKU may be a product's
+// or a variant's. A line that cannot be added is described by the returned problem rather
+// than an error.
+func findCartLineProduct(db *gorm.DB, line CartLine) (*models.Product, *models.ProductVariant, string, error) {
+	var product models.Product
+	variantID := line.VariantID
+	var err error
+	switch {
+	case line.ProductID != 0 && line.SKU != "":
+		return nil, nil, "Give either a product ID or a SKU, not both", nil
+	case line.ProductID != 0:
+		err = db.First(&product, line.ProductID).Error
+	case line.SKU != "" && line.VariantID != nil:
+		return nil, nil, "A variant is named by its own SKU", nil
+	case line.SKU != "":
+		sku := strings.TrimSpace(line.SKU)
+		err = db.Where("sku = ?", sku).First(&product).Error
+		if err == gorm.ErrRecordNotFound {
+			var variant models.ProductVariant
+			if err = db.Where("sku = ?", sku).First(&variant).Error; err == nil {
+				variantID = &variant.ID
+				err = db.First(&product, variant.ProductID).Error
+			}
+		}
+	default:
+		return nil, nil, "A product ID or SKU is required", nil
+	}
+
+	if err == gorm.ErrRecordNotFound {
+		return nil, nil, "Product not found", nil
+	}
+	if err != nil {
+		return nil, nil, "", err
+	}
+	if product.Status != "active" {
+		return nil, nil, "Product is not available", nil
+	}
+
+	variant, err := findOrderVariant(db, product, variantID)
+	if checkoutErr, ok := err.(*checkoutError); ok {
+		return nil, nil, checkoutErr.message, nil
+	}
+	if err != nil {
+		return nil, nil, "", err
+	}
+	return &product, variant, "", nil
+}
+
+// ShareCart saves a snapshot of the cart and returns a short link to it
+func (h *CartHandler) ShareCart(c *gin.Context) {
+	cart, err := h.findCart(c, false)
+	if err != nil {
+		h.cartError(c, err)
+		return
+	}
+
+	var items []models.CartItem
+	if cart != nil {
+		if err := h.db.Where("cart_id = ?", cart.ID).Find(&items).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch cart"})
+			return
+		}
+	}
+	if len(items) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Cart is empty"})
+		return
+	}
+
+	share := models.CartShare{
+		UserID:    cart.UserID,
+		ExpiresAt: time.Now().Add(cartShareTTL),
+	}
+	for _, item := range items {
+		share.Items = append(share.Items, models.CartShareItem{ProductID: item.ProductID, VariantID: item.VariantID, Quantity: item.Quantity})
+	}
+
+	err = h.db.Transaction(func(tx *gorm.DB) error {
+		code, err := generateShareCode(tx)
+		if err != nil {
+			return err
+		}
+		share.Code = code
+		return tx.Create(&share).Error
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to share cart"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"code":       share.Code,
+		"url":        h.frontendURL + "/cart/shared/" + share.Code,
+		"expires_at": share.ExpiresAt,
+	})
+}
+
+// GetSharedCart shows a shared cart before it is imported
+func (h *CartHandler) GetSharedCart(c *gin.Context) {
+	share, ok := h.findCartShare(c)
+	if !ok {
+		return
+	}
+
+	c.JSON(http.StatusOK, share)
+}
+
+// ImportSharedCart adds a shared cart's items to the caller's own cart, on top of what is
+// already there. Like a bulk add, nothing is added unless every line can be.
+func (h *CartHandler) ImportSharedCart(c *gin.Context) {
+	share, ok := h.findCartShare(c)
+	if !ok {
+		return
+	}
+
+	cart, err := h.findCart(c, true)
+	if err != nil {
+		h.cartError(c, err)
+		return
+	}
+
+	lines := make([]CartLine, 0, len(share.Items))
+	for _, item := range share.Items {
+		lines = append(lines, CartLine{ProductID: item.ProductID, VariantID: item.VariantID, Quantity: item.Quantity})
+	}
+
+	if h.addCartLines(c, cart, lines, "Shared cart imported successfully") {
+		h.db.Model(share).UpdateColumn("import_count", gorm.Expr("import_count + 1"))
+	}
+}
+
+// findCartShare loads the unexpired shared cart named in the URL, writing the error response
+// if there is none
+func (h *CartHandler) findCartShare(c *gin.Context) (*models.CartShare, bool) {
+	var share models.CartShare
+	if err := h.db.Preload("Items.Product.Images").
+		Where("code = ? AND expires_at > ?", c.Param("code"), time.Now()).
+		First(&share).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Shared cart not found or expired"})
+			return nil, false
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch shared cart"})
+		return nil, false
+	}
+
+	return &share, true
+}
+
+// generateShareCode picks an unused eight character code for a shared cart
+func generateShareCode(tx *gorm.DB) (string, error) {
+	alphabet := big.NewInt(int64(len(shareCodeAlphabet)))
+
+	for attempt := 0; attempt < 5; attempt++ {
+		code := make([]byte, 8)
+		for i := range code {
+			n, err := rand.Int(rand.Reader, alphabet)
+			if err != nil {
+				return "", err
+			}
+			code[i] = shareCodeAlphabet[n.Int64()]
+		}
+
+		var taken int64
+		if err := tx.Model(&models.CartShare{}).Where("code = ?", string(code)).Count(&taken).Error; err != nil {
+			return "", err
+		}
+		if taken == 0 {
+			return string(code), nil
+		}
+	}
+	return "", errors.New("failed to generate a unique share code")
+}
@@ -0,0 +1,215 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/yourname/sakifarm-ecommerce/models"
+	"github.com/yourname/sakifarm-ecommerce/pricing"
+	"gorm.io/gorm"
+)
+
+// CartItemWarning reports a cart line that no longer matches the catalogue
+type CartItemWarning struct {
+	CartItemID        uint    `json:"cart_item_id"`
+	ProductID         uint    `json:"product_id"`
+	Type              string  `json:"type"` // price_changed, insufficient_stock, unavailable, choose_option
+	Message           string  `json:"message"`
+	OldPrice          float64 `json:"old_price,omitempty"`
+	NewPrice          float64 `json:"new_price,omitempty"`
+	Quantity          int     `json:"quantity,omitempty"`
+	AvailableQuantity int     `json:"available_quantity"`
+}
+
+// revalidateCart checks every line of a loaded cart against its product: gone or inactive
+// products, quantities beyond what can be sold, and prices that changed since the line was
+// added. Lines added before price snapshots existed are not checked for price changes.
+func (h *CartHandler) revalidateCart(cart *models.Cart) ([]CartItemWarning, error) {
+	warnings := []CartItemWarning{}
+
+	for _, item := range cart.Items {
+		product := item.Product
+		if cartItemNeedsOption(item) && cartProductAvailable(product) {
+			warnings = append(warnings, CartItemWarning{
+				CartItemID: item.ID,
+				ProductID:  item.ProductID,
+				Type:       "choose_option",
+				Message:    fmt.Sprintf("Choose an option for %s", product.Name),
+				Quantity:   item.Quantity,
+			})
+			continue
+		}
+		if !cartItemAvailable(item) {
+			warnings = append(warnings, CartItemWarning{
+				CartItemID: item.ID,
+				ProductID:  item.ProductID,
+				Type:       "unavailable",
+				Message:    "This product is no longer available",
+				Quantity:   item.Quantity,
+			})
+			continue
+		}
+
+		available, err := availableStock(h.db, product, item.Variant, cart.ID)
+		if err != nil {
+			return nil, err
+		}
+		if available < item.Quantity && !acceptsItemBackorder(product, item.Variant, item.Quantity) {
+			warnings = append(warnings, CartItemWarning{
+				CartItemID:        item.ID,
+				ProductID:         item.ProductID,
+				Type:              "insufficient_stock",
+				Message:           fmt.Sprintf("Only %d of %s available", available, itemName(product, item.Variant)),
+				Quantity:          item.Quantity,
+				AvailableQuantity: available,
+			})
+		}
+
+		price := itemPrice(product, item.Variant)
+		if item.PriceAtAdd > 0 && item.PriceAtAdd != price {
+			warnings = append(warnings, CartItemWarning{
+				CartItemID:        item.ID,
+				ProductID:         item.ProductID,
+				Type:              "price_changed",
+				Message:           fmt.Sprintf("The price of %s changed from KES %.2f to KES %.2f", itemName(product, item.Variant), item.PriceAtAdd, price),
+				OldPrice:          item.PriceAtAdd,
+				NewPrice:          price,
+				AvailableQuantity: available,
+			})
+		}
+	}
+
+	return warnings, nil
+}
+
+// cartProductAvailable reports whether a cart line's product can still be bought
+func cartProductAvailable(product models.Product) bool {
+	return product.ID != 0 && !product.DeletedAt.Valid && product.Status == "active"
+}
+
+// cartItemNeedsOption reports whether a cart line was added without a variant to a product
+// that has since been given variants, so it can only be bought once an option is chosen
+func cartItemNeedsOption(item models.CartItem) bool {
+	return item.VariantID == nil && len(item.Product.Variants) > 0
+}
+
+// cartItemAvailable reports whether a cart line's product, and variant if it has one, can
+// still be bought
+func cartItemAvailable(item models.CartItem) bool {
+	if cartItemNeedsOption(item) {
+		return false
+	}
+	if item.VariantID != nil && (item.Variant == nil || item.Variant.DeletedAt.Valid || !item.Variant.IsActive) {
+		return false
+	}
+	return cartProductAvailable(item.Product)
+}
+
+// preloadCartProducts loads cart lines with their products and variants, deleted ones
+// included so they show up as unavailable lines rather than blank ones. The products'
+// current variants are loaded too, to spot lines that still need an option chosen.
+func preloadCartProducts(db *gorm.DB) *gorm.DB {
+	return db.Preload("Items.Product", func(db *gorm.DB) *gorm.DB {
+		return db.Unscoped()
+	}).Preload("Items.Product.Images").Preload("Items.Product.Variants").Preload("Items.Variant", func(db *gorm.DB) *gorm.DB {
+		return db.Unscoped()
+	})
+}
+
+// availableCartItems leaves out lines whose product can no longer be bought, so the cart is
+// priced as checkout would charge it
+func availableCartItems(items []models.CartItem) []models.CartItem {
+	available := make([]models.CartItem, 0, len(items))
+	for _, item := range items {
+		if cartItemAvailable(item) {
+			available = append(available, item)
+		}
+	}
+	return available
+}
+
+// FixCart resolves the cart's warnings: unavailable lines and lines that need an option
+// chosen are removed, quantities are lowered to what can be sold, and changed prices are
+// accepted. The fixes made are returned with the updated cart.
+func (h *CartHandler) FixCart(c *gin.Context) {
+	cart, err := h.findCart(c, false)
+	if err != nil {
+		h.cartError(c, err)
+		return
+	}
+	if cart == nil {
+		response, _ := h.priceCart(&models.Cart{Items: []models.CartItem{}})
+		c.JSON(http.StatusOK, gin.H{"cart": response, "fixed": []CartItemWarning{}})
+		return
+	}
+
+	if err := preloadCartProducts(h.db).First(cart, cart.ID).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch cart"})
+		return
+	}
+
+	warnings, err := h.revalidateCart(cart)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check cart"})
+		return
+	}
+
+	items := make(map[uint]*models.CartItem, len(cart.Items))
+	for i := range cart.Items {
+		items[cart.Items[i].ID] = &cart.Items[i]
+	}
+
+	for _, warning := range warnings {
+		item := items[warning.CartItemID]
+		var err error
+		switch {
+		case warning.Type == "unavailable" || warning.Type == "choose_option" || (warning.Type == "insufficient_stock" && warning.AvailableQuantity == 0):
+			err = h.deleteCartItem(item)
+		case warning.Type == "insufficient_stock":
+			item.Quantity = warning.AvailableQuantity
+			if err = h.db.Model(item).Update("quantity", item.Quantity).Error; err == nil {
+				err = h.reserveCartItem(h.db, *item, warning.AvailableQuantity)
+			}
+		case warning.Type == "price_changed":
+			err = h.db.Model(item).Update("price_at_add", warning.NewPrice).Error
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fix cart"})
+			return
+		}
+	}
+
+	response, err := h.cartResponse(cart.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch cart"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"cart": response, "fixed": warnings})
+}
+
+// cartResponse loads, revalidates and prices a cart
+func (h *CartHandler) cartResponse(cartID uint) (*CartResponse, error) {
+	var cart models.Cart
+	if err := preloadCartProducts(h.db).First(&cart, cartID).Error; err != nil {
+		return nil, err
+	}
+	return h.priceCart(&cart)
+}
+
+// priceCart revalidates and prices a loaded cart. It is priced for delivery without a
+// coupon; the quote endpoint covers other options.
+func (h *CartHandler) priceCart(cart *models.Cart) (*CartResponse, error) {
+	warnings, err := h.revalidateCart(cart)
+	if err != nil {
+		return nil, err
+	}
+
+	breakdown, err := pricing.Calculate(cartPricingItems(availableCartItems(cart.Items)), pricing.Options{})
+	if err != nil {
+		return nil, err
+	}
+
+	return &CartResponse{Cart: *cart, Pricing: breakdown, Warnings: warnings}, nil
+}
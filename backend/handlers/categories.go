@@ -0,0 +1,485 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+	"github.com/yourname/sakifarm-ecommerce/models"
+	"gorm.io/gorm"
+)
+
+// CategoryHandler manages the category tree. Products are linked to a category by ID; their
+// category name is kept in step for display.
+type CategoryHandler struct {
+	db        *gorm.DB
+	validator *validator.Validate
+}
+
+func NewCategoryHandler(db *gorm.DB) *CategoryHandler {
+	return &CategoryHandler{
+		db:        db,
+		validator: validator.New(),
+	}
+}
+
+type CategoryRequest struct {
+	Name        string `json:"name" validate:"required"`
+	Description string `json:"description"`
+	Image       string `json:"image"`
+	ParentID    *uint  `json:"parent_id"` // none for a top-level category; only used on create
+	Position    int    `json:"position"`  // only used on create
+	IsActive    *bool  `json:"is_active,omitempty"`
+}
+
+type MoveCategoryRequest struct {
+	ParentID *uint `json:"parent_id"`
+	Position int   `json:"position" validate:"min=0"`
+}
+
+// GetAllCategories returns the whole category tree, inactive categories included, with the
+// number of products of any status in each (admin)
+func (h *CategoryHandler) GetAllCategories(c *gin.Context) {
+	categories, err := loadCategories(h.db, false)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch categories"})
+		return
+	}
+
+	counts, err := categoryProductCounts(h.db, false)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch categories"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"categories": categoryTree(categories, counts)})
+}
+
+// CreateCategory adds a category, at the top level or under a parent (admin)
+func (h *CategoryHandler) CreateCategory(c *gin.Context) {
+	var req CategoryRequest
+	if !h.bindCategoryRequest(c, &req) {
+		return
+	}
+
+	category := models.Category{IsActive: true}
+	if !h.applyCategoryRequest(c, &category, req) {
+		return
+	}
+
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Omit("Parent", "Children").Create(&category).Error; err != nil {
+			return err
+		}
+		// A false flag would otherwise fall back to the column default
+		if !category.IsActive {
+			return tx.Model(&category).Update("is_active", false).Error
+		}
+		return nil
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create category"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"message":  "Category created successfully",
+		"category": category,
+	})
+}
+
+// UpdateCategory changes a category's details or activation; its parent and position are
+// left to MoveCategory. Renaming a category renames it on its products too (admin).
+func (h *CategoryHandler) UpdateCategory(c *gin.Context) {
+	category, ok := h.findCategory(c)
+	if !ok {
+		return
+	}
+
+	var req CategoryRequest
+	if !h.bindCategoryRequest(c, &req) {
+		return
+	}
+
+	if !h.applyCategoryRequest(c, category, req) {
+		return
+	}
+
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Omit("Parent", "Children").Save(category).Error; err != nil {
+			return err
+		}
+		return tx.Model(&models.Product{}).Unscoped().Where("category_id = ?", category.ID).
+			Update("category", category.Name).Error
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update category"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":  "Category updated successfully",
+		"category": category,
+	})
+}
+
+// MoveCategory puts a category under a new parent, or at the top level, at the given position
+// among its siblings. Siblings at or after that position move down one place, and those after
+// its old position move up one (admin).
+func (h *CategoryHandler) MoveCategory(c *gin.Context) {
+	category, ok := h.findCategory(c)
+	if !ok {
+		return
+	}
+
+	var req MoveCategoryRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := h.validator.Struct(req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if !h.checkCategoryParent(c, category.ID, req.ParentID) {
+		return
+	}
+
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		// Close the gap left among the old siblings, then open one among the new
+		if err := categorySiblings(tx, category.ParentID, category.ID).Where("position > ?", category.Position).
+			Update("position", gorm.Expr("position - 1")).Error; err != nil {
+			return err
+		}
+		if err := categorySiblings(tx, req.ParentID, category.ID).Where("position >= ?", req.Position).
+			Update("position", gorm.Expr("position + 1")).Error; err != nil {
+			return err
+		}
+
+		category.ParentID = req.ParentID
+		category.Position = req.Position
+		return tx.Model(category).Select("ParentID", "Position").Updates(category).Error
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to move category"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":  "Category moved successfully",
+		"category": category,
+	})
+}
+
+// DeleteCategory removes a category that has no subcategories and no products (admin)
+func (h *CategoryHandler) DeleteCategory(c *gin.Context) {
+	category, ok := h.findCategory(c)
+	if !ok {
+		return
+	}
+
+	var children int64
+	h.db.Model(&models.Category{}).Where("parent_id = ?", category.ID).Count(&children)
+	if children > 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "Move or delete the subcategories first"})
+		return
+	}
+
+	// Deleted products count too, so restoring one never leaves it without its category
+	var products int64
+	h.db.Model(&models.Product{}).Unscoped().Where("category_id = ?", category.ID).Count(&products)
+	if products > 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "Category has products; move them or deactivate the category instead"})
+		return
+	}
+
+	if err := h.db.Delete(category).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete category"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Category deleted successfully"})
+}
+
+// categorySiblings scopes a query to the categories under the given parent, or at the top
+// level, other than the one being moved
+func categorySiblings(tx *gorm.DB, parentID *uint, excludeID uint) *gorm.DB {
+	query := tx.Model(&models.Category{}).Where("id != ?", excludeID)
+	if parentID == nil {
+		return query.Where("parent_id IS NULL")
+	}
+	return query.Where("parent_id = ?", *parentID)
+}
+
+// bindCategoryRequest binds and validates a category request, writing the error response when
+// it is not valid
+func (h *CategoryHandler) bindCategoryRequest(c *gin.Context, req *CategoryRequest) bool {
+	if err := c.ShouldBindJSON(req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return false
+	}
+
+	if err := h.validator.Struct(req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return false
+	}
+	return true
+}
+
+// applyCategoryRequest copies a request onto a category after checking its name and parent,
+// writing the error response when they are not acceptable. The parent and position are only
+// taken for a new category; existing ones change place through MoveCategory, which makes room
+// among the siblings.
+func (h *CategoryHandler) applyCategoryRequest(c *gin.Context, category *models.Category, req CategoryRequest) bool {
+	name := strings.TrimSpace(req.Name)
+
+	var existing int64
+	h.db.Model(&models.Category{}).Where("LOWER(name) = LOWER(?) AND id != ?", name, category.ID).Count(&existing)
+	if existing > 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "Category with this name already exists"})
+		return false
+	}
+
+	if category.ID == 0 {
+		if !h.checkCategoryParent(c, category.ID, req.ParentID) {
+			return false
+		}
+		category.ParentID = req.ParentID
+		category.Position = req.Position
+	}
+
+	category.Name = name
+	category.Description = req.Description
+	category.Image = req.Image
+	if req.IsActive != nil {
+		category.IsActive = *req.IsActive
+	}
+	return true
+}
+
+// checkCategoryParent makes sure a category may be placed under a parent: the parent must exist
+// and must not be the category itself or one of its subcategories. It writes the error response
+// when the parent is not acceptable.
+func (h *CategoryHandler) checkCategoryParent(c *gin.Context, categoryID uint, parentID *uint) bool {
+	if parentID == nil {
+		return true
+	}
+
+	categories, err := loadCategories(h.db, false)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch categories"})
+		return false
+	}
+
+	found := false
+	for _, category := range categories {
+		if category.ID == *parentID {
+			found = true
+			break
+		}
+	}
+	if !found {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Parent category not found"})
+		return false
+	}
+
+	// A new category has no subcategories yet
+	if categoryID != 0 {
+		for _, id := range categoryDescendants(categories, categoryID) {
+			if id == *parentID {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "A category cannot be placed under itself or its subcategories"})
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// findCategory loads the category named in the URL, writing the error response if there is none
+func (h *CategoryHandler) findCategory(c *gin.Context) (*models.Category, bool) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid category ID"})
+		return nil, false
+	}
+
+	var category models.Category
+	if err := h.db.First(&category, id).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Category not found"})
+			return nil, false
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch category"})
+		return nil, false
+	}
+
+	return &category, true
+}
+
+// loadCategories loads every category, or only active ones, in display order
+func loadCategories(db *gorm.DB, activeOnly bool) ([]models.Category, error) {
+	query := db.Order("position ASC, name ASC")
+	if activeOnly {
+		query = query.Where("is_active = ?", true)
+	}
+
+	var categories []models.Category
+	err := query.Find(&categories).Error
+	return categories, err
+}
+
+// categoryProductCounts counts products directly in each category, only active ones if asked
+func categoryProductCounts(db *gorm.DB, activeOnly bool) (map[uint]int64, error) {
+	query := db.Model(&models.Product{}).Where("category_id IS NOT NULL")
+	if activeOnly {
+		query = query.Where("status = ?", "active")
+	}
+
+	var rows []struct {
+		CategoryID uint
+		Count      int64
+	}
+	if err := query.Select("category_id, COUNT(*) AS count").Group("category_id").Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+
+	counts := make(map[uint]int64, len(rows))
+	for _, row := range rows {
+		counts[row.CategoryID] = row.Count
+	}
+	return counts, nil
+}
+
+// categoryTree nests categories under their parents. Each category's product count covers its
+// subcategories too. Categories whose parent is not among those given are left out, so leaving
+// out an inactive category hides everything under it.
+func categoryTree(categories []models.Category, counts map[uint]int64) []models.Category {
+	children := make(map[uint][]models.Category)
+	for _, category := range categories {
+		var parentID uint
+		if category.ParentID != nil {
+			parentID = *category.ParentID
+		}
+		children[parentID] = append(children[parentID], category)
+	}
+
+	var build func(parentID uint) []models.Category
+	build = func(parentID uint) []models.Category {
+		nodes := []models.Category{}
+		for _, category := range children[parentID] {
+			category.Children = build(category.ID)
+			category.ProductCount = counts[category.ID]
+			for _, child := range category.Children {
+				category.ProductCount += child.ProductCount
+			}
+			nodes = append(nodes, category)
+		}
+		return nodes
+	}
+	return build(0)
+}
+
+// categoryDescendants lists a category and every category under it. It is empty when the
+// category is not among those given.
+func categoryDescendants(categories []models.Category, categoryID uint) []uint {
+	children := make(map[uint][]uint)
+	found := false
+	for _, category := range categories {
+		if category.ID == categoryID {
+			found = true
+		}
+		if category.ParentID != nil {
+			children[*category.ParentID] = append(children[*category.ParentID], category.ID)
+		}
+	}
+	if !found {
+		return []uint{}
+	}
+
+	ids := []uint{categoryID}
+	for i := 0; i < len(ids); i++ {
+		ids = append(ids, children[ids[i]]...)
+	}
+	return ids
+}
+
+// filterByCategory narrows a product query to a category, named by ID or by name, and its
+// subcategories. With activeOnly set, inactive categories and everything under them match
+// nothing.
+func filterByCategory(db, query *gorm.DB, category string, activeOnly bool) (*gorm.DB, error) {
+	categories, err := loadCategories(db, activeOnly)
+	if err != nil {
+		return nil, err
+	}
+
+	var categoryID uint
+	if id, err := strconv.ParseUint(category, 10, 32); err == nil {
+		categoryID = uint(id)
+	} else {
+		for _, candidate := range categories {
+			if strings.EqualFold(candidate.Name, strings.TrimSpace(category)) {
+				categoryID = candidate.ID
+				break
+			}
+		}
+	}
+
+	return query.Where("category_id IN ?", categoryDescendants(categories, categoryID)), nil
+}
+
+// productCategory resolves the category a product request names, by ID or else by name.
+// Problems are returned as a *checkoutError.
+func productCategory(db *gorm.DB, categoryID *uint, name string) (*models.Category, error) {
+	var category models.Category
+	var err error
+	if categoryID != nil {
+		err = db.First(&category, *categoryID).Error
+	} else {
+		err = db.Where("LOWER(name) = LOWER(?)", strings.TrimSpace(name)).First(&category).Error
+	}
+	if err == gorm.ErrRecordNotFound {
+		return nil, &checkoutError{http.StatusBadRequest, "Category not found"}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &category, nil
+}
+
+// LinkProductCategories links products that only name their category to the category of that
+// name, creating a top-level category for names that have none. Products from before categories
+// were linked are migrated this way; running it again changes nothing.
+func LinkProductCategories(db *gorm.DB) error {
+	var names []string
+	if err := db.Model(&models.Product{}).Unscoped().
+		Where("category_id IS NULL AND TRIM(category) <> ''").
+		Distinct("category").Pluck("category", &names).Error; err != nil {
+		return err
+	}
+
+	for _, name := range names {
+		err := db.Transaction(func(tx *gorm.DB) error {
+			var category models.Category
+			err := tx.Where("LOWER(name) = LOWER(?)", strings.TrimSpace(name)).First(&category).Error
+			if err == gorm.ErrRecordNotFound {
+				category = models.Category{Name: strings.TrimSpace(name), IsActive: true}
+				err = tx.Omit("Parent", "Children").Create(&category).Error
+			}
+			if err != nil {
+				return err
+			}
+
+			return tx.Model(&models.Product{}).Unscoped().
+				Where("category_id IS NULL AND category = ?", name).
+				Updates(map[string]interface{}{"category_id": category.ID, "category": category.Name}).Error
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
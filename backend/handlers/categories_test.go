@@ -0,0 +1,84 @@
+package handlers
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/yourname/sakifarm-ecommerce/models"
+)
+
+func parentOf(id uint) *uint {
+	return &id
+}
+
+// testCategories is Vegetables (1) with Leafy (2) and Roots (3) under it, Kale (4) under
+// Leafy, and Dairy (5) on its own.
+func testCategories() []models.Category {
+	return []models.Category{
+		{ID: 1, Name: "Vegetables"},
+		{ID: 2, Name: "Leafy", ParentID: parentOf(1)},
+		{ID: 3, Name: "Roots", ParentID: parentOf(1)},
+		{ID: 4, Name: "Kale", ParentID: parentOf(2)},
+		{ID: 5, Name: "Dairy"},
+	}
+}
+
+func TestCategoryTree(t *testing.T) {
+	counts := map[uint]int64{1: 1, 2: 2, 3: 3, 4: 4, 5: 5}
+	tree := categoryTree(testCategories(), counts)
+
+	if len(tree) != 2 || tree[0].ID != 1 || tree[1].ID != 5 {
+		t.Fatalf("got roots %+v, want Vegetables then Dairy", tree)
+	}
+	vegetables := tree[0]
+	if len(vegetables.Children) != 2 || vegetables.Children[0].ID != 2 || vegetables.Children[1].ID != 3 {
+		t.Fatalf("got Vegetables children %+v, want Leafy then Roots", vegetables.Children)
+	}
+	leafy := vegetables.Children[0]
+	if len(leafy.Children) != 1 || leafy.Children[0].ID != 4 {
+		t.Fatalf("got Leafy children %+v, want Kale", leafy.Children)
+	}
+	if len(leafy.Children[0].Children) != 0 || leafy.Children[0].Children == nil {
+		t.Errorf("got Kale children %v, want an empty list", leafy.Children[0].Children)
+	}
+
+	wantCounts := map[string]int64{"Vegetables": 10, "Leafy": 6, "Roots": 3, "Kale": 4, "Dairy": 5}
+	gotCounts := map[string]int64{
+		"Vegetables": vegetables.ProductCount,
+		"Leafy":      leafy.ProductCount,
+		"Roots":      vegetables.Children[1].ProductCount,
+		"Kale":       leafy.Children[0].ProductCount,
+		"Dairy":      tree[1].ProductCount,
+	}
+	if !reflect.DeepEqual(gotCounts, wantCounts) {
+		t.Errorf("got product counts %v, want %v", gotCounts, wantCounts)
+	}
+}
+
+func TestCategoryTreeEmpty(t *testing.T) {
+	if tree := categoryTree(nil, nil); tree == nil || len(tree) != 0 {
+		t.Errorf("got %v, want an empty list", tree)
+	}
+}
+
+func TestCategoryDescendants(t *testing.T) {
+	tests := []struct {
+		name       string
+		categoryID uint
+		want       []uint
+	}{
+		{"whole subtree", 1, []uint{1, 2, 3, 4}},
+		{"middle of the tree", 2, []uint{2, 4}},
+		{"leaf", 4, []uint{4}},
+		{"root without children", 5, []uint{5}},
+		{"unknown category", 9, []uint{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := categoryDescendants(testCategories(), tt.categoryID); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
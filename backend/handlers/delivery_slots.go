@@ -0,0 +1,306 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+	"github.com/yourname/sakifarm-ecommerce/models"
+	"gorm.io/gorm"
+)
+
+var errDeliverySlotUnavailable = errors.New("Selected delivery slot is full or no longer available")
+var errDeliverySlotOutOfZone = errors.New("Selected delivery slot does not cover the shipping address")
+
+type DeliverySlotHandler struct {
+	db        *gorm.DB
+	validator *validator.Validate
+}
+
+func NewDeliverySlotHandler(db *gorm.DB) *DeliverySlotHandler {
+	return &DeliverySlotHandler{
+		db:        db,
+		validator: validator.New(),
+	}
+}
+
+type DeliverySlotRequest struct {
+	Zone      string `json:"zone" validate:"required"`
+	Date      string `json:"date" validate:"required,datetime=2006-01-02"`
+	StartTime string `json:"start_time" validate:"required,datetime=15:04"`
+	EndTime   string `json:"end_time" validate:"required,datetime=15:04"`
+	Capacity  int    `json:"capacity" validate:"required,min=1"`
+}
+
+type UpdateDeliverySlotRequest struct {
+	Capacity *int  `json:"capacity,omitempty" validate:"omitempty,min=0"`
+	IsActive *bool `json:"is_active,omitempty"`
+}
+
+// GetAvailableSlots lists upcoming active slots that still have capacity
+func (h *DeliverySlotHandler) GetAvailableSlots(c *gin.Context) {
+	zone := c.Query("zone")
+	from := c.DefaultQuery("from", time.Now().Format("2006-01-02"))
+	to := c.DefaultQuery("to", time.Now().AddDate(0, 0, 14).Format("2006-01-02"))
+
+	// Never offer slots in the past
+	if from < time.Now().Format("2006-01-02") {
+		from = time.Now().Format("2006-01-02")
+	}
+
+	query := h.db.Model(&models.DeliverySlot{}).
+		Where("is_active = ? AND booked < capacity AND date BETWEEN ? AND ?", true, from, to)
+
+	if zone != "" {
+		query = query.Where("zone = ?", zone)
+	}
+
+	var slots []models.DeliverySlot
+	if err := query.Order("date ASC, start_time ASC").Find(&slots).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch delivery slots"})
+		return
+	}
+
+	available := make([]gin.H, 0, len(slots))
+	for _, slot := range slots {
+		available = append(available, gin.H{
+			"id":         slot.ID,
+			"zone":       slot.Zone,
+			"date":       slot.Date.Format("2006-01-02"),
+			"start_time": slot.StartTime,
+			"end_time":   slot.EndTime,
+			"remaining":  slot.Capacity - slot.Booked,
+		})
+	}
+
+	c.JSON(http.StatusOK, gin.H{"slots": available})
+}
+
+// GetSlots lists all slots with booking counts (admin)
+func (h *DeliverySlotHandler) GetSlots(c *gin.Context) {
+	query := h.db.Model(&models.DeliverySlot{})
+
+	if zone := c.Query("zone"); zone != "" {
+		query = query.Where("zone = ?", zone)
+	}
+	if from := c.Query("from"); from != "" {
+		query = query.Where("date >= ?", from)
+	}
+	if to := c.Query("to"); to != "" {
+		query = query.Where("date <= ?", to)
+	}
+
+	var slots []models.DeliverySlot
+	if err := query.Order("date ASC, zone ASC, start_time ASC").Find(&slots).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch delivery slots"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"slots": slots})
+}
+
+// CreateSlot defines a new delivery window (admin)
+func (h *DeliverySlotHandler) CreateSlot(c *gin.Context) {
+	var req DeliverySlotRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := h.validator.Struct(req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	date, _ := time.Parse("2006-01-02", req.Date)
+	start, _ := time.Parse("15:04", req.StartTime)
+	end, _ := time.Parse("15:04", req.EndTime)
+	if !end.After(start) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "End time must be after start time"})
+		return
+	}
+
+	slot := models.DeliverySlot{
+		Zone:      req.Zone,
+		Date:      date,
+		StartTime: start.Format("15:04"),
+		EndTime:   end.Format("15:04"),
+		Capacity:  req.Capacity,
+		IsActive:  true,
+	}
+
+	var existing models.DeliverySlot
+	if err := h.db.Where("zone = ? AND date = ? AND start_time = ?", slot.Zone, req.Date, slot.StartTime).First(&existing).Error; err == nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "A slot already exists for this zone, date and start time"})
+		return
+	}
+
+	if err := h.db.Create(&slot).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create delivery slot"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"message": "Delivery slot created successfully",
+		"slot":    slot,
+	})
+}
+
+// UpdateSlot changes a slot's capacity or activation (admin)
+func (h *DeliverySlotHandler) UpdateSlot(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid slot ID"})
+		return
+	}
+
+	var req UpdateDeliverySlotRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := h.validator.Struct(req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var slot models.DeliverySlot
+	if err := h.db.First(&slot, id).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Delivery slot not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch delivery slot"})
+		return
+	}
+
+	updates := map[string]interface{}{}
+	if req.Capacity != nil {
+		if *req.Capacity < slot.Booked {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Capacity cannot be lower than the number of booked orders"})
+			return
+		}
+		updates["capacity"] = *req.Capacity
+	}
+	if req.IsActive != nil {
+		updates["is_active"] = *req.IsActive
+	}
+
+	if err := h.db.Model(&slot).Updates(updates).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update delivery slot"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Delivery slot updated successfully",
+		"slot":    slot,
+	})
+}
+
+// DeleteSlot removes an unused slot, or deactivates it when orders are booked (admin)
+func (h *DeliverySlotHandler) DeleteSlot(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid slot ID"})
+		return
+	}
+
+	var slot models.DeliverySlot
+	if err := h.db.First(&slot, id).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Delivery slot not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch delivery slot"})
+		return
+	}
+
+	if slot.Booked > 0 {
+		if err := h.db.Model(&slot).Update("is_active", false).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to deactivate delivery slot"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"message": "Delivery slot deactivated (has booked orders)"})
+		return
+	}
+
+	if err := h.db.Delete(&slot).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete delivery slot"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Delivery slot deleted successfully"})
+}
+
+// GetSlotOrders lists the orders booked into a slot for route planning (admin)
+func (h *DeliverySlotHandler) GetSlotOrders(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid slot ID"})
+		return
+	}
+
+	var slot models.DeliverySlot
+	if err := h.db.First(&slot, id).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Delivery slot not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch delivery slot"})
+		return
+	}
+
+	var orders []models.Order
+	if err := h.db.Preload("Items.Product").Preload("User").
+		Where("delivery_slot_id = ? AND status != ?", slot.ID, "cancelled").
+		Order("shipping_city ASC, shipping_address1 ASC").
+		Find(&orders).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch orders"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"slot":   slot,
+		"orders": orders,
+	})
+}
+
+// bookDeliverySlot takes one unit of a slot's capacity for an order shipping to the address.
+// The slot's zone must be the address's city or county. The conditional update keeps
+// concurrent checkouts from overbooking.
+func bookDeliverySlot(tx *gorm.DB, slotID uint, address models.Address) error {
+	var slot models.DeliverySlot
+	if err := tx.First(&slot, slotID).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return errDeliverySlotUnavailable
+		}
+		return err
+	}
+	zone := strings.TrimSpace(slot.Zone)
+	if !strings.EqualFold(zone, strings.TrimSpace(address.City)) && !strings.EqualFold(zone, strings.TrimSpace(address.State)) {
+		return errDeliverySlotOutOfZone
+	}
+
+	result := tx.Model(&models.DeliverySlot{}).
+		Where("id = ? AND is_active = ? AND booked < capacity AND date >= ?", slotID, true, time.Now().Format("2006-01-02")).
+		Update("booked", gorm.Expr("booked + 1"))
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return errDeliverySlotUnavailable
+	}
+	return nil
+}
+
+// releaseDeliverySlot gives back the capacity held by an order
+func releaseDeliverySlot(tx *gorm.DB, slotID uint) error {
+	return tx.Model(&models.DeliverySlot{}).
+		Where("id = ? AND booked > 0", slotID).
+		Update("booked", gorm.Expr("booked - 1")).Error
+}
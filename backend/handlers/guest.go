@@ -0,0 +1,453 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+	"github.com/yourname/sakifarm-ecommerce/middleware"
+	"github.com/yourname/sakifarm-ecommerce/models"
+	"github.com/yourname/sakifarm-ecommerce/services"
+	"gorm.io/gorm"
+)
+
+// OTP purposes used by the guest checkout flow
+const (
+	otpPurposeGuestCheckout = "guest_checkout"
+	otpPurposeGuestLookup   = "guest_order_lookup"
+)
+
+var errGuestOrderVerified = errors.New("Order has already been verified")
+
+type GuestHandler struct {
+	db             *gorm.DB
+	authService    *services.AuthService
+	paymentService *services.PaymentService
+	smsService     *services.SMSService
+	orderNumbers   *services.OrderNumberService
+	jwtSecret      string
+	cart           *CartHandler
+	validator      *validator.Validate
+}
+
+func NewGuestHandler(db *gorm.DB, authService *services.AuthService, paymentService *services.PaymentService, smsService *services.SMSService, orderNumbers *services.OrderNumberService, jwtSecret string, cart *CartHandler) *GuestHandler {
+	return &GuestHandler{
+		db:             db,
+		authService:    authService,
+		paymentService: paymentService,
+		smsService:     smsService,
+		orderNumbers:   orderNumbers,
+		jwtSecret:      jwtSecret,
+		cart:           cart,
+		validator:      validator.New(),
+	}
+}
+
+type GuestCheckoutRequest struct {
+	Email           string             `json:"email" validate:"required,email"`
+	Phone           string             `json:"phone" validate:"required,min=10"`
+	Items           []OrderItemRequest `json:"items" validate:"required,min=1,dive"`
+	ShippingAddress models.Address     `json:"shipping_address" validate:"required"`
+	BillingAddress  models.Address     `json:"billing_address" validate:"required"`
+	PaymentMethod   string             `json:"payment_method" validate:"required,oneof=mpesa airtel"`
+	Notes           string             `json:"notes"`
+	DeliverySlotID  *uint              `json:"delivery_slot_id,omitempty"`
+	FulfilmentType  string             `json:"fulfilment_type" validate:"omitempty,oneof=delivery pickup"`
+	PickupPointID   *uint              `json:"pickup_point_id,omitempty" validate:"required_if=FulfilmentType pickup"`
+	CouponCode      string             `json:"coupon_code"`
+}
+
+type GuestOrderOTPRequest struct {
+	OrderNumber string `json:"order_number" validate:"required"`
+}
+
+type GuestOrderVerifyRequest struct {
+	OrderNumber string `json:"order_number" validate:"required"`
+	Code        string `json:"code" validate:"required"`
+}
+
+type ClaimGuestOrderRequest struct {
+	OrderNumber string `json:"order_number" validate:"required"`
+	Code        string `json:"code" validate:"required"`
+	Username    string `json:"username" validate:"required,min=3,max=50"`
+	Password    string `json:"password" validate:"required,min=6"`
+	FirstName   string `json:"first_name" validate:"required"`
+	LastName    string `json:"last_name" validate:"required"`
+}
+
+// Checkout creates an unverified guest order and sends an OTP to the guest's phone.
+// Stock is taken and payment requested only once the phone is verified.
+func (h *GuestHandler) Checkout(c *gin.Context) {
+	var req GuestCheckoutRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := h.validator.Struct(req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Stock held by the guest's own cart is theirs to buy
+	orderItems, err := buildOrderItems(h.db, req.Items, h.guestCartID(c))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	fulfilmentType := req.FulfilmentType
+	if fulfilmentType == "" {
+		fulfilmentType = "delivery"
+	}
+	if fulfilmentType == "pickup" && req.DeliverySlotID != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Pickup orders cannot book a delivery slot"})
+		return
+	}
+
+	breakdown, err := priceOrderItems(h.db, orderItems, fulfilmentType, req.CouponCode)
+	if err != nil {
+		if checkoutErr, ok := err.(*checkoutError); ok {
+			c.JSON(checkoutErr.status, gin.H{"error": checkoutErr.message})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to price order"})
+		return
+	}
+
+	orderNumber, err := h.orderNumbers.Next(h.db)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create order"})
+		return
+	}
+
+	order := &models.Order{
+		GuestEmail:      req.Email,
+		GuestPhone:      req.Phone,
+		OrderNumber:     orderNumber,
+		Status:          "pending",
+		PaymentStatus:   "pending",
+		PaymentMethod:   req.PaymentMethod,
+		TotalAmount:     breakdown.Total,
+		ShippingAmount:  breakdown.Shipping,
+		TaxAmount:       breakdown.Tax,
+		DiscountAmount:  breakdown.Discount,
+		CouponCode:      breakdown.CouponCode,
+		Items:           orderItems,
+		ShippingAddress: req.ShippingAddress,
+		BillingAddress:  req.BillingAddress,
+		Notes:           req.Notes,
+		DeliverySlotID:  req.DeliverySlotID,
+		FulfilmentType:  fulfilmentType,
+	}
+	if fulfilmentType == "pickup" {
+		order.PickupPointID = req.PickupPointID
+	}
+
+	err = h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Omit("User").Create(order).Error; err != nil {
+			return err
+		}
+		return splitOrderByVendor(tx, order.ID)
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create order"})
+		return
+	}
+
+	// A code sent a moment ago for another order from this phone confirms this one too
+	if err := h.authService.SendOTP(req.Phone, otpPurposeGuestCheckout); err != nil && !errors.Is(err, services.ErrOTPTooSoon) {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to send verification code"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"message":      "Order created. Enter the code sent to your phone to confirm it",
+		"order_number": order.OrderNumber,
+		"total_amount": order.TotalAmount,
+	})
+}
+
+// guestCartID finds the guest cart sent along with the request, or 0 when there is none
+func (h *GuestHandler) guestCartID(c *gin.Context) uint {
+	cartKey, err := middleware.ParseCartToken(c.GetHeader(middleware.CartTokenHeader), h.jwtSecret)
+	if err != nil {
+		return 0
+	}
+
+	var cart models.Cart
+	h.db.Select("id").Where("guest_key = ? AND user_id IS NULL", cartKey).First(&cart)
+	return cart.ID
+}
+
+// VerifyCheckout confirms a guest order with the OTP, reserves stock and the delivery slot,
+// and requests payment
+func (h *GuestHandler) VerifyCheckout(c *gin.Context) {
+	var req GuestOrderVerifyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := h.validator.Struct(req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var order models.Order
+	if err := h.db.Preload("Items.Product").
+		Where("order_number = ? AND user_id IS NULL", req.OrderNumber).First(&order).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Order not found"})
+		return
+	}
+
+	if order.GuestVerified {
+		c.JSON(http.StatusBadRequest, gin.H{"error": errGuestOrderVerified.Error()})
+		return
+	}
+
+	if err := h.authService.VerifyOTP(order.GuestPhone, req.Code, otpPurposeGuestCheckout); err != nil {
+		otpError(c, err)
+		return
+	}
+
+	var stockErr error
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		// Only one verification may reserve the order's stock, slot and coupon
+		result := tx.Model(&models.Order{}).Where("id = ? AND guest_verified = ?", order.ID, false).
+			Update("guest_verified", true)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			stockErr = errGuestOrderVerified
+			return stockErr
+		}
+		order.GuestVerified = true
+
+		if order.DeliverySlotID != nil {
+			if err := bookDeliverySlot(tx, *order.DeliverySlotID, order.ShippingAddress); err != nil {
+				stockErr = err
+				return err
+			}
+		}
+		if order.PickupPointID != nil {
+			code, err := reservePickupPoint(tx, *order.PickupPointID)
+			if err != nil {
+				stockErr = err
+				return err
+			}
+			if err := tx.Model(&order).Update("pickup_code", code).Error; err != nil {
+				return err
+			}
+			order.PickupCode = code
+		}
+		if order.CouponCode != "" {
+			if err := redeemCoupon(tx, order.CouponCode); err != nil {
+				if err == errCouponUnavailable {
+					stockErr = err
+				}
+				return err
+			}
+		}
+		if err := reserveOrderItems(tx, order.Items); err != nil {
+			if _, ok := err.(*checkoutError); ok {
+				stockErr = err
+			}
+			return err
+		}
+
+		// The stock the guest's cart was holding now belongs to the order
+		if cartID := h.guestCartID(c); cartID != 0 {
+			if err := releasePurchasedReservations(tx, cartID, order.Items); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		if stockErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": stockErr.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to confirm order"})
+		return
+	}
+
+	if order.FulfilmentType == "pickup" {
+		go sendPickupCode(h.db, h.smsService, order.GuestPhone, &order)
+	}
+
+	payment, err := initiateOrderPayment(h.paymentService, order.ID, order.PaymentMethod, order.GuestPhone, order.TotalAmount, "order")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to initiate payment"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Order confirmed successfully",
+		"order":   order,
+		"payment": payment,
+	})
+}
+
+// SendLookupOTP sends a one-time code to the phone on a guest order so it can be viewed or claimed
+func (h *GuestHandler) SendLookupOTP(c *gin.Context) {
+	var req GuestOrderOTPRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := h.validator.Struct(req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var order models.Order
+	if err := h.db.Where("order_number = ? AND user_id IS NULL", req.OrderNumber).First(&order).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Order not found"})
+		return
+	}
+
+	if err := h.authService.SendOTP(order.GuestPhone, otpPurposeGuestLookup); err != nil {
+		if errors.Is(err, services.ErrOTPTooSoon) {
+			c.JSON(http.StatusTooManyRequests, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to send verification code"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": fmt.Sprintf("Verification code sent to the phone ending in %s", lastDigits(order.GuestPhone, 3)),
+	})
+}
+
+// LookupOrder returns a guest order given its number and a lookup OTP
+func (h *GuestHandler) LookupOrder(c *gin.Context) {
+	var req GuestOrderVerifyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := h.validator.Struct(req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var order models.Order
+	if err := h.db.Preload("Items.Product").Preload("Shipments.Items").
+		Where("order_number = ? AND user_id IS NULL", req.OrderNumber).First(&order).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Order not found"})
+		return
+	}
+
+	if err := h.authService.VerifyOTP(order.GuestPhone, req.Code, otpPurposeGuestLookup); err != nil {
+		otpError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"order": order})
+}
+
+// ClaimOrder creates an account from a guest order's contact details and moves every
+// guest order placed with the same phone into it
+func (h *GuestHandler) ClaimOrder(c *gin.Context) {
+	var req ClaimGuestOrderRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := h.validator.Struct(req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var order models.Order
+	if err := h.db.Where("order_number = ? AND user_id IS NULL", req.OrderNumber).First(&order).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Order not found"})
+		return
+	}
+
+	if err := h.authService.VerifyOTP(order.GuestPhone, req.Code, otpPurposeGuestLookup); err != nil {
+		otpError(c, err)
+		return
+	}
+
+	user := &models.User{
+		Username:  req.Username,
+		Email:     order.GuestEmail,
+		Password:  req.Password,
+		Phone:     order.GuestPhone,
+		FirstName: req.FirstName,
+		LastName:  req.LastName,
+		Role:      "customer",
+	}
+
+	if err := h.authService.Register(user); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// The phone was just verified with the OTP
+	h.authService.VerifyUser(user.ID)
+
+	result := h.db.Model(&models.Order{}).
+		Where("user_id IS NULL AND guest_phone = ?", order.GuestPhone).
+		Update("user_id", user.ID)
+	if result.Error != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to claim orders"})
+		return
+	}
+
+	token, err := middleware.GenerateToken(user.ID, user.Email, user.Role, h.jwtSecret)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
+		return
+	}
+
+	response := gin.H{
+		"message":        "Account created successfully",
+		"claimed_orders": result.RowsAffected,
+		"token":          token,
+		"user": gin.H{
+			"id":         user.ID,
+			"username":   user.Username,
+			"email":      user.Email,
+			"first_name": user.FirstName,
+			"last_name":  user.LastName,
+			"role":       user.Role,
+		},
+	}
+
+	if merge := h.cart.mergeCartOnSignIn(c, user.ID); merge != nil {
+		response["cart"] = merge
+	}
+
+	c.JSON(http.StatusCreated, response)
+}
+
+// otpError writes the response for a one-time code that could not be used
+func otpError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, services.ErrOTPLocked):
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrInvalidOTP):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check verification code"})
+	}
+}
+
+// lastDigits returns the last n characters of a phone number for masked display
+func lastDigits(phone string, n int) string {
+	if len(phone) <= n {
+		return phone
+	}
+	return phone[len(phone)-n:]
+}
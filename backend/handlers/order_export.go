@@ -0,0 +1,293 @@
+package handlers
+
+import (
+	"encoding/csv"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/xuri/excelize/v2"
+	"github.com/yourname/sakifarm-ecommerce/models"
+	"gorm.io/gorm"
+)
+
+// Orders are read from the database in batches of this size while exporting
+const exportBatchSize = 500
+
+var orderExportColumns = []string{
+	"Order Number", "Date", "Customer", "Email", "Phone", "Status", "Payment Status", "Payment Method",
+	"Items", "Subtotal", "Shipping", "Tax", "Discount", "Total", "Shipping City", "Tracking Number",
+}
+
+var orderItemExportColumns = []string{
+	"Order Number", "Date", "Customer", "Email", "Status", "Payment Status", "Payment Method",
+	"Product ID", "SKU", "Product", "Quantity", "Unit Price", "Line Total", "Item Status",
+}
+
+// exportWriter writes spreadsheet rows to the response in one output format
+type exportWriter interface {
+	WriteRow(values []interface{}) error
+	Flush() error
+	Close() error
+}
+
+type csvExportWriter struct {
+	writer *csv.Writer
+}
+
+// escapeFormula stops text that customers control, such as names and addresses, from being
+// run as a formula when the export is opened in a spreadsheet
+func escapeFormula(value string) string {
+	if value != "" && strings.ContainsRune("=+-@\t\r", rune(value[0])) {
+		return "'" + value
+	}
+	return value
+}
+
+func (w *csvExportWriter) WriteRow(values []interface{}) error {
+	record := make([]string, len(values))
+	for i, value := range values {
+		switch v := value.(type) {
+		case float64:
+			record[i] = fmt.Sprintf("%.2f", v)
+		case string:
+			record[i] = escapeFormula(v)
+		default:
+			record[i] = fmt.Sprint(v)
+		}
+	}
+	return w.writer.Write(record)
+}
+
+func (w *csvExportWriter) Flush() error {
+	w.writer.Flush()
+	return w.writer.Error()
+}
+
+func (w *csvExportWriter) Close() error {
+	return w.Flush()
+}
+
+// xlsxExportWriter uses excelize's stream writer, which spills rows to a temporary file once
+// the sheet outgrows its buffer instead of keeping the whole sheet in memory, and zips the
+// workbook straight into the response
+type xlsxExportWriter struct {
+	file   *excelize.File
+	stream *excelize.StreamWriter
+	output http.ResponseWriter
+	row    int
+}
+
+func newXLSXExportWriter(output http.ResponseWriter) (*xlsxExportWriter, error) {
+	file := excelize.NewFile()
+	stream, err := file.NewStreamWriter("Sheet1")
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return &xlsxExportWriter{file: file, stream: stream, output: output}, nil
+}
+
+func (w *xlsxExportWriter) WriteRow(values []interface{}) error {
+	for i, value := range values {
+		if text, ok := value.(string); ok {
+			values[i] = escapeFormula(text)
+		}
+	}
+
+	w.row++
+	cell, err := excelize.CoordinatesToCellName(1, w.row)
+	if err != nil {
+		return err
+	}
+	return w.stream.SetRow(cell, values)
+}
+
+func (w *xlsxExportWriter) Flush() error {
+	return nil
+}
+
+func (w *xlsxExportWriter) Close() error {
+	defer w.file.Close()
+	if err := w.stream.Flush(); err != nil {
+		return err
+	}
+	return w.file.Write(w.output)
+}
+
+// ExportOrders streams orders as a CSV or XLSX spreadsheet with one row per order or per order item
+func (h *AdminDashboardHandler) ExportOrders(c *gin.Context) {
+	format := c.DefaultQuery("format", "csv")
+	if format != "csv" && format != "xlsx" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Format must be csv or xlsx"})
+		return
+	}
+
+	granularity := c.DefaultQuery("granularity", "order")
+	if granularity != "order" && granularity != "item" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Granularity must be order or item"})
+		return
+	}
+
+	query := h.db.Model(&models.Order{})
+
+	if from := c.Query("from"); from != "" {
+		date, err := time.Parse("2006-01-02", from)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid from date, expected YYYY-MM-DD"})
+			return
+		}
+		query = query.Where("created_at >= ?", date)
+	}
+	if to := c.Query("to"); to != "" {
+		date, err := time.Parse("2006-01-02", to)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid to date, expected YYYY-MM-DD"})
+			return
+		}
+		// The end date is inclusive
+		query = query.Where("created_at < ?", date.AddDate(0, 0, 1))
+	}
+	if status := c.Query("status"); status != "" {
+		query = query.Where("status = ?", status)
+	}
+	if paymentStatus := c.Query("payment_status"); paymentStatus != "" {
+		query = query.Where("payment_status = ?", paymentStatus)
+	}
+	if paymentMethod := c.Query("payment_method"); paymentMethod != "" {
+		query = query.Where("payment_method = ?", paymentMethod)
+	}
+
+	filename := fmt.Sprintf("orders-%s.%s", time.Now().Format("20060102-150405"), format)
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%s", filename))
+
+	var writer exportWriter
+	if format == "xlsx" {
+		xlsxWriter, err := newXLSXExportWriter(c.Writer)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create spreadsheet"})
+			return
+		}
+		writer = xlsxWriter
+		c.Header("Content-Type", "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet")
+	} else {
+		writer = &csvExportWriter{writer: csv.NewWriter(c.Writer)}
+		c.Header("Content-Type", "text/csv")
+	}
+
+	columns := orderExportColumns
+	if granularity == "item" {
+		columns = orderItemExportColumns
+	}
+	header := make([]interface{}, len(columns))
+	for i, column := range columns {
+		header[i] = column
+	}
+	c.Status(http.StatusOK)
+	if err := writer.WriteRow(header); err != nil {
+		log.Printf("Order export failed: %v", err)
+		return
+	}
+
+	// Headers are already sent, so failures from here on can only be logged.
+	// FindInBatches walks the orders by primary key.
+	var batch []models.Order
+	result := query.Preload("User").Preload("Items.Product").
+		FindInBatches(&batch, exportBatchSize, func(tx *gorm.DB, _ int) error {
+			for _, order := range batch {
+				var err error
+				if granularity == "item" {
+					err = writeOrderItemRows(writer, order)
+				} else {
+					err = writer.WriteRow(orderExportRow(order))
+				}
+				if err != nil {
+					return err
+				}
+			}
+			if err := writer.Flush(); err != nil {
+				return err
+			}
+			c.Writer.Flush()
+			return nil
+		})
+	if result.Error != nil {
+		log.Printf("Order export failed: %v", result.Error)
+		return
+	}
+
+	if err := writer.Close(); err != nil {
+		log.Printf("Order export failed: %v", err)
+	}
+}
+
+func orderExportRow(order models.Order) []interface{} {
+	name, email, phone := exportCustomer(order)
+
+	itemCount := 0
+	for _, item := range order.Items {
+		itemCount += item.Quantity
+	}
+	subtotal := order.TotalAmount - order.ShippingAmount - order.TaxAmount + order.DiscountAmount
+
+	return []interface{}{
+		order.OrderNumber,
+		order.CreatedAt.Format("2006-01-02 15:04"),
+		name,
+		email,
+		phone,
+		order.Status,
+		order.PaymentStatus,
+		order.PaymentMethod,
+		itemCount,
+		subtotal,
+		order.ShippingAmount,
+		order.TaxAmount,
+		order.DiscountAmount,
+		order.TotalAmount,
+		order.ShippingAddress.City,
+		order.TrackingNumber,
+	}
+}
+
+func writeOrderItemRows(writer exportWriter, order models.Order) error {
+	name, email, _ := exportCustomer(order)
+
+	for _, item := range order.Items {
+		if err := writer.WriteRow([]interface{}{
+			order.OrderNumber,
+			order.CreatedAt.Format("2006-01-02 15:04"),
+			name,
+			email,
+			order.Status,
+			order.PaymentStatus,
+			order.PaymentMethod,
+			item.ProductID,
+			item.Product.SKU,
+			item.Product.Name,
+			item.Quantity,
+			item.Price,
+			item.Total,
+			item.Status,
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// exportCustomer returns the name, email and phone of an order's customer or guest
+func exportCustomer(order models.Order) (string, string, string) {
+	if order.User.ID != 0 {
+		return order.User.FirstName + " " + order.User.LastName, order.User.Email, order.User.Phone
+	}
+	name := order.ShippingAddress.FirstName + " " + order.ShippingAddress.LastName
+	phone := order.GuestPhone
+	if phone == "" {
+		phone = order.ShippingAddress.Phone
+	}
+	return name, order.GuestEmail, phone
+}
@@ -1,33 +1,44 @@
 package handlers
 
 import (
+	"errors"
 	"fmt"
+	"math"
 	"net/http"
+	"sort"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/go-playground/validator/v10"
-	"github.com/google/uuid"
 	"github.com/yourname/sakifarm-ecommerce/models"
+	"github.com/yourname/sakifarm-ecommerce/pricing"
 	"github.com/yourname/sakifarm-ecommerce/services"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 type OrderHandler struct {
 	db             *gorm.DB
 	paymentService *services.PaymentService
 	emailService   *services.EmailService
+	smsService     *services.SMSService
 	pdfService     *services.PDFService
+	orderNumbers   *services.OrderNumberService
+	cart           *CartHandler
 	validator      *validator.Validate
 }
 
-func NewOrderHandler(db *gorm.DB, paymentService *services.PaymentService, emailService *services.EmailService, pdfService *services.PDFService) *OrderHandler {
+func NewOrderHandler(db *gorm.DB, paymentService *services.PaymentService, emailService *services.EmailService, smsService *services.SMSService, pdfService *services.PDFService, orderNumbers *services.OrderNumberService, cart *CartHandler) *OrderHandler {
 	return &OrderHandler{
 		db:             db,
 		paymentService: paymentService,
 		emailService:   emailService,
+		smsService:     smsService,
 		pdfService:     pdfService,
+		orderNumbers:   orderNumbers,
+		cart:           cart,
 		validator:      validator.New(),
 	}
 }
@@ -39,15 +50,25 @@ type CreateOrderRequest struct {
 	PaymentMethod   string            `json:"payment_method" validate:"required,oneof=mpesa airtel"`
 	PhoneNumber     string            `json:"phone_number" validate:"required"`
 	Notes           string            `json:"notes"`
+	DeliverySlotID  *uint             `json:"delivery_slot_id,omitempty"`
+	FulfilmentType  string            `json:"fulfilment_type" validate:"omitempty,oneof=delivery pickup"`
+	PickupPointID   *uint             `json:"pickup_point_id,omitempty" validate:"required_if=FulfilmentType pickup"`
+	CouponCode      string            `json:"coupon_code"`
 }
 
 type OrderItemRequest struct {
-	ProductID uint `json:"product_id" validate:"required"`
-	Quantity  int  `json:"quantity" validate:"required,min=1"`
+	ProductID uint  `json:"product_id" validate:"required"`
+	VariantID *uint `json:"variant_id"` // required for products with variants
+	Quantity  int   `json:"quantity" validate:"required,min=1"`
+}
+
+type EditOrderRequest struct {
+	Items       []OrderItemRequest `json:"items" validate:"required,min=1,dive"`
+	PhoneNumber string             `json:"phone_number"`
 }
 
 type UpdateOrderStatusRequest struct {
-	Status         string `json:"status" validate:"required,oneof=pending confirmed processing shipped delivered cancelled"`
+	Status         string `json:"status" validate:"required,oneof=pending confirmed processing partially_shipped shipped delivered cancelled"`
 	TrackingNumber string `json:"tracking_number,omitempty"`
 	Notes          string `json:"notes,omitempty"`
 }
@@ -66,85 +87,48 @@ func (h *OrderHandler) CreateOrder(c *gin.Context) {
 		return
 	}
 
-	// Calculate order totals
-	var totalAmount float64
-	var orderItems []models.OrderItem
-
-	for _, item := range req.Items {
-		var product models.Product
-		if err := h.db.First(&product, item.ProductID).Error; err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Product %d not found", item.ProductID)})
-			return
-		}
-
-		if product.Stock < item.Quantity {
-			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Insufficient stock for product %s", product.Name)})
-			return
-		}
-
-		itemTotal := product.Price * float64(item.Quantity)
-		totalAmount += itemTotal
-
-		orderItems = append(orderItems, models.OrderItem{
-			ProductID: item.ProductID,
-			Quantity:  item.Quantity,
-			Price:     product.Price,
-			Total:     itemTotal,
-		})
-	}
-
-	// Add shipping and tax
-	shippingAmount := 200.0 // Fixed shipping for now
-	taxAmount := totalAmount * 0.16 // 16% VAT
-	totalAmount += shippingAmount + taxAmount
+	uid := userID.(uint)
 
-	// Generate order number
-	orderNumber := fmt.Sprintf("ORD-%s", uuid.New().String()[:8])
+	// Stock held by the customer's own cart is theirs to buy
+	var cart models.Cart
+	h.db.Select("id").Where("user_id = ?", uid).First(&cart)
 
-	// Create order
-	order := &models.Order{
-		UserID:          userID.(uint),
-		OrderNumber:     orderNumber,
-		Status:          "pending",
-		PaymentStatus:   "pending",
-		PaymentMethod:   req.PaymentMethod,
-		TotalAmount:     totalAmount,
-		ShippingAmount:  shippingAmount,
-		TaxAmount:       taxAmount,
-		Items:           orderItems,
+	order, err := createOrder(h.db, h.orderNumbers, orderInput{
+		UserID:          &uid,
+		CartID:          cart.ID,
+		Items:           req.Items,
 		ShippingAddress: req.ShippingAddress,
 		BillingAddress:  req.BillingAddress,
+		PaymentMethod:   req.PaymentMethod,
 		Notes:           req.Notes,
-	}
-
-	if err := h.db.Create(order).Error; err != nil {
+		DeliverySlotID:  req.DeliverySlotID,
+		FulfilmentType:  req.FulfilmentType,
+		PickupPointID:   req.PickupPointID,
+		CouponCode:      req.CouponCode,
+	})
+	if err != nil {
+		if checkoutErr, ok := err.(*checkoutError); ok {
+			c.JSON(checkoutErr.status, gin.H{"error": checkoutErr.message})
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create order"})
 		return
 	}
 
-	// Update product stock
-	for _, item := range req.Items {
-		h.db.Model(&models.Product{}).Where("id = ?", item.ProductID).
-			Update("stock", gorm.Expr("stock - ?", item.Quantity))
-	}
-
 	// Initiate payment
-	var payment *models.Payment
-	var err error
-
-	if req.PaymentMethod == "mpesa" {
-		payment, err = h.paymentService.InitiateMPesaPayment(order.ID, req.PhoneNumber, totalAmount)
-	} else if req.PaymentMethod == "airtel" {
-		payment, err = h.paymentService.InitiateAirtelPayment(order.ID, req.PhoneNumber, totalAmount)
-	}
-
+	payment, err := initiateOrderPayment(h.paymentService, order.ID, req.PaymentMethod, req.PhoneNumber, order.TotalAmount, "order")
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to initiate payment"})
 		return
 	}
 
+	// Text the pickup code to the customer
+	if order.FulfilmentType == "pickup" {
+		go sendPickupCode(h.db, h.smsService, req.PhoneNumber, order)
+	}
+
 	// Load order with relationships
-	h.db.Preload("Items.Product").Preload("User").First(order, order.ID)
+	h.db.Preload("Items.Product").Preload("User").Preload("PickupPoint").First(order, order.ID)
 
 	c.JSON(http.StatusCreated, gin.H{
 		"message": "Order created successfully",
@@ -196,25 +180,64 @@ func (h *OrderHandler) GetOrders(c *gin.Context) {
 	})
 }
 
-func (h *OrderHandler) GetOrder(c *gin.Context) {
-	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid order ID"})
-		return
+// CustomerVendorOrder is what a customer sees of the part of their order one vendor fulfils
+type CustomerVendorOrder struct {
+	ID             uint       `json:"id"`
+	VendorName     string     `json:"vendor_name,omitempty"`
+	Status         string     `json:"status"`
+	TrackingNumber string     `json:"tracking_number"`
+	ShippedAt      *time.Time `json:"shipped_at"`
+	DeliveredAt    *time.Time `json:"delivered_at"`
+}
+
+// customerVendorOrders lists an order's sub-orders without the vendors' payout details or
+// the store's commission
+func customerVendorOrders(db *gorm.DB, orderID uint) ([]CustomerVendorOrder, error) {
+	var vendorOrders []models.VendorOrder
+	if err := db.Preload("Vendor").Where("order_id = ?", orderID).Order("id ASC").Find(&vendorOrders).Error; err != nil {
+		return nil, err
+	}
+
+	result := make([]CustomerVendorOrder, 0, len(vendorOrders))
+	for _, vendorOrder := range vendorOrders {
+		entry := CustomerVendorOrder{
+			ID:             vendorOrder.ID,
+			Status:         vendorOrder.Status,
+			TrackingNumber: vendorOrder.TrackingNumber,
+			ShippedAt:      vendorOrder.ShippedAt,
+			DeliveredAt:    vendorOrder.DeliveredAt,
+		}
+		if vendorOrder.Vendor != nil {
+			entry.VendorName = vendorOrder.Vendor.Name
+		}
+		result = append(result, entry)
 	}
+	return result, nil
+}
 
+// GetOrder looks an order up by ID or by order number, in the current or the legacy ORD- format
+func (h *OrderHandler) GetOrder(c *gin.Context) {
 	userID, _ := c.Get("user_id")
 	userRole := c.GetString("user_role")
 
 	var order models.Order
-	query := h.db.Preload("Items.Product").Preload("User")
+	query := h.db.Preload("Items.Product").Preload("User").Preload("Returns.Items").Preload("Shipments.Items").Preload("DeliverySlot").Preload("PickupPoint")
 
-	// If not admin, only allow access to own orders
+	// If not admin, only allow access to own orders. Vendors' payout details and commission
+	// are for admins only.
 	if userRole != "admin" {
 		query = query.Where("user_id = ?", userID)
+	} else {
+		query = query.Preload("VendorOrders.Vendor")
 	}
 
-	if err := query.First(&order, id).Error; err != nil {
+	if id, err := strconv.ParseUint(c.Param("id"), 10, 32); err == nil {
+		query = query.Where("id = ?", id)
+	} else {
+		query = query.Where("UPPER(order_number) = UPPER(?)", strings.TrimSpace(c.Param("id")))
+	}
+
+	if err := query.First(&order).Error; err != nil {
 		if err == gorm.ErrRecordNotFound {
 			c.JSON(http.StatusNotFound, gin.H{"error": "Order not found"})
 			return
@@ -223,7 +246,21 @@ func (h *OrderHandler) GetOrder(c *gin.Context) {
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"order": order})
+	response := gin.H{"order": order}
+	if userRole != "admin" {
+		vendorOrders, err := customerVendorOrders(h.db, order.ID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch order"})
+			return
+		}
+		response["vendor_orders"] = vendorOrders
+	}
+	// Only shown here, to the order's owner, in case the SMS went missing
+	if order.FulfilmentType == "pickup" && order.CollectedAt == nil {
+		response["pickup_code"] = order.PickupCode
+	}
+
+	c.JSON(http.StatusOK, response)
 }
 
 func (h *OrderHandler) UpdateOrderStatus(c *gin.Context) {
@@ -296,41 +333,40 @@ func (h *OrderHandler) CancelOrder(c *gin.Context) {
 	userRole := c.GetString("user_role")
 
 	var order models.Order
-	query := h.db.Preload("Items")
+	var statusErr error
+	err = h.db.Transaction(func(tx *gorm.DB) error {
+		// Lock the order so two cancellations cannot both give back its stock, slot and coupon
+		query := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Preload("Items")
+
+		// If not admin, only allow cancellation of own orders
+		if userRole != "admin" {
+			query = query.Where("user_id = ?", userID)
+		}
+		if err := query.First(&order, id).Error; err != nil {
+			return err
+		}
 
-	// If not admin, only allow cancellation of own orders
-	if userRole != "admin" {
-		query = query.Where("user_id = ?", userID)
-	}
+		// Check if order can be cancelled
+		if order.Status == "delivered" || order.Status == "cancelled" {
+			statusErr = errors.New("Order cannot be cancelled")
+			return statusErr
+		}
 
-	if err := query.First(&order, id).Error; err != nil {
+		return cancelOrderTx(tx, &order)
+	})
+	if err != nil {
+		if statusErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": statusErr.Error()})
+			return
+		}
 		if err == gorm.ErrRecordNotFound {
 			c.JSON(http.StatusNotFound, gin.H{"error": "Order not found"})
 			return
 		}
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch order"})
-		return
-	}
-
-	// Check if order can be cancelled
-	if order.Status == "delivered" || order.Status == "cancelled" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Order cannot be cancelled"})
-		return
-	}
-
-	// Update order status
-	order.Status = "cancelled"
-	if err := h.db.Save(&order).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to cancel order"})
 		return
 	}
 
-	// Restore product stock
-	for _, item := range order.Items {
-		h.db.Model(&models.Product{}).Where("id = ?", item.ProductID).
-			Update("stock", gorm.Expr("stock + ?", item.Quantity))
-	}
-
 	c.JSON(http.StatusOK, gin.H{
 		"message": "Order cancelled successfully",
 		"order":   order,
@@ -366,7 +402,7 @@ func (h *OrderHandler) GenerateReceipt(c *gin.Context) {
 
 	// Only generate receipt for delivered orders
 	if order.Status != "delivered" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Receipt can only be generated for delivered orders"})
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Receipt can only be generated for delivered orders, download an invoice instead"})
 		return
 	}
 
@@ -385,12 +421,182 @@ func (h *OrderHandler) GenerateReceipt(c *gin.Context) {
 	c.Data(http.StatusOK, "application/pdf", pdfData)
 }
 
+// GenerateInvoice downloads an invoice for an order at any stage. Paid orders get a tax
+// invoice with a sequential number; other orders, or ?type=proforma, get a pro-forma invoice.
+func (h *OrderHandler) GenerateInvoice(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid order ID"})
+		return
+	}
+
+	userID, _ := c.Get("user_id")
+	userRole := c.GetString("user_role")
+
+	var order models.Order
+	query := h.db.Preload("Items.Product").Preload("User")
+
+	// If not admin, only allow access to own orders
+	if userRole != "admin" {
+		query = query.Where("user_id = ?", userID)
+	}
+
+	if err := query.First(&order, id).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Order not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch order"})
+		return
+	}
+
+	paid := order.PaymentStatus == "paid" || order.PaymentStatus == "partially_paid" || order.PaymentStatus == "refunded"
+
+	invoiceType := c.Query("type")
+	if invoiceType == "" {
+		invoiceType = "proforma"
+		if paid {
+			invoiceType = "tax"
+		}
+	}
+
+	var pdfData []byte
+	var filename string
+	switch invoiceType {
+	case "proforma":
+		pdfData, err = h.pdfService.GenerateProformaInvoice(&order)
+		filename = fmt.Sprintf("proforma-%s.pdf", order.OrderNumber)
+	case "tax":
+		if !paid {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "A tax invoice is only issued once the order is paid"})
+			return
+		}
+		invoice, issueErr := issueInvoice(h.db, &order)
+		if issueErr != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to issue invoice"})
+			return
+		}
+		pdfData, err = h.pdfService.GenerateTaxInvoice(&order, invoice)
+		filename = fmt.Sprintf("invoice-%s.pdf", invoice.InvoiceNumber)
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invoice type must be proforma or tax"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate invoice"})
+		return
+	}
+
+	// Set headers for PDF download
+	c.Header("Content-Type", "application/pdf")
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%s", filename))
+	c.Header("Content-Length", strconv.Itoa(len(pdfData)))
+
+	c.Data(http.StatusOK, "application/pdf", pdfData)
+}
+
+// issueInvoice returns the order's tax invoice, numbering a new one on first request.
+// Numbers come from invoice_number_seq, which never hands out the same value twice.
+func issueInvoice(db *gorm.DB, order *models.Order) (*models.Invoice, error) {
+	var invoice models.Invoice
+	err := db.Preload("Lines", func(query *gorm.DB) *gorm.DB { return query.Order("id ASC") }).
+		Where("order_id = ?", order.ID).First(&invoice).Error
+	if err == nil {
+		return &invoice, nil
+	}
+	if err != gorm.ErrRecordNotFound {
+		return nil, err
+	}
+
+	var sequence int64
+	if err := db.Raw("SELECT nextval('invoice_number_seq')").Scan(&sequence).Error; err != nil {
+		return nil, err
+	}
+
+	invoice = models.Invoice{
+		InvoiceNumber:  fmt.Sprintf("INV-%06d", sequence),
+		OrderID:        order.ID,
+		Subtotal:       order.TotalAmount - order.ShippingAmount - order.TaxAmount + order.DiscountAmount,
+		ShippingAmount: order.ShippingAmount,
+		DiscountAmount: order.DiscountAmount,
+		TaxAmount:      order.TaxAmount,
+		TotalAmount:    order.TotalAmount,
+		IssuedAt:       time.Now(),
+	}
+
+	// The lines are frozen with the invoice, the order's tax split across them
+	lineTotals := make([]float64, len(order.Items))
+	for i, item := range order.Items {
+		lineTotals[i] = item.Total
+	}
+	taxes := pricing.LineTaxes(lineTotals, order.DiscountAmount, order.TaxAmount)
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		// A concurrent request may have issued the invoice first; keep theirs
+		result := tx.Clauses(clause.OnConflict{DoNothing: true}).Omit("Lines").Create(&invoice)
+		if result.Error != nil || result.RowsAffected == 0 {
+			return result.Error
+		}
+
+		lines := make([]models.InvoiceLine, 0, len(order.Items))
+		for i, item := range order.Items {
+			description := item.Product.Name
+			if item.VariantName != "" {
+				description = fmt.Sprintf("%s (%s)", description, item.VariantName)
+			}
+			lines = append(lines, models.InvoiceLine{
+				InvoiceID:   invoice.ID,
+				Description: description,
+				Quantity:    item.Quantity,
+				UnitPrice:   item.Price,
+				Total:       item.Total,
+				TaxAmount:   taxes[i],
+			})
+		}
+		if len(lines) == 0 {
+			return nil
+		}
+		return tx.Create(&lines).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	invoice = models.Invoice{}
+	if err := db.Preload("Lines", func(query *gorm.DB) *gorm.DB { return query.Order("id ASC") }).
+		Where("order_id = ?", order.ID).First(&invoice).Error; err != nil {
+		return nil, err
+	}
+	return &invoice, nil
+}
+
+// Timeline titles for carrier tracking events
+var trackingEventTitles = map[string]string{
+	services.TrackingPickedUp:       "Picked Up by Courier",
+	services.TrackingInTransit:      "In Transit",
+	services.TrackingOutForDelivery: "Out for Delivery",
+	services.TrackingDelivered:      "Delivered by Courier",
+	services.TrackingFailedAttempt:  "Delivery Attempt Failed",
+	services.TrackingReturned:       "Returned to Sender",
+}
+
 func (h *OrderHandler) TrackOrder(c *gin.Context) {
 	trackingNumber := c.Param("trackingNumber")
+	contact := strings.TrimSpace(c.Query("contact"))
+
+	// Customers may enter a tracking number belonging to the order itself or to any of its
+	// shipments. Order numbers are sequential, so they are only accepted together with the
+	// email or phone the order was placed with.
+	conditions := h.db.Where("tracking_number = ? OR id IN (?)", trackingNumber,
+		h.db.Model(&models.Shipment{}).Select("order_id").Where("tracking_number = ?", trackingNumber))
+	if contact != "" {
+		conditions = conditions.Or("UPPER(order_number) = UPPER(?)", trackingNumber)
+	}
 
 	var order models.Order
-	if err := h.db.Preload("Items.Product").Preload("User").
-		Where("tracking_number = ?", trackingNumber).First(&order).Error; err != nil {
+	if err := h.db.Preload("User").Preload("Shipments.TrackingEvents", func(db *gorm.DB) *gorm.DB {
+		return db.Order("occurred_at ASC")
+	}).Where(conditions).First(&order).Error; err != nil {
 		if err == gorm.ErrRecordNotFound {
 			c.JSON(http.StatusNotFound, gin.H{"error": "Order not found"})
 			return
@@ -399,6 +605,17 @@ func (h *OrderHandler) TrackOrder(c *gin.Context) {
 		return
 	}
 
+	matchedTracking := order.TrackingNumber != "" && order.TrackingNumber == trackingNumber
+	for _, shipment := range order.Shipments {
+		if shipment.TrackingNumber != "" && shipment.TrackingNumber == trackingNumber {
+			matchedTracking = true
+		}
+	}
+	if !matchedTracking && !orderContactMatches(order, contact) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Order not found"})
+		return
+	}
+
 	// Create tracking timeline
 	timeline := []gin.H{
 		{
@@ -418,7 +635,13 @@ func (h *OrderHandler) TrackOrder(c *gin.Context) {
 			"status":      "processing",
 			"title":       "Processing",
 			"description": "Your order is being processed and packed",
-			"completed":   order.Status == "processing" || order.Status == "shipped" || order.Status == "delivered",
+			"completed":   order.Status == "processing" || order.Status == "partially_shipped" || order.Status == "shipped" || order.Status == "delivered",
+		},
+		{
+			"status":      "partially_shipped",
+			"title":       "Partially Shipped",
+			"description": "Some of your items have been shipped, the rest will follow",
+			"completed":   order.Status == "partially_shipped" || order.Status == "shipped" || order.Status == "delivered",
 		},
 		{
 			"status":      "shipped",
@@ -426,17 +649,865 @@ func (h *OrderHandler) TrackOrder(c *gin.Context) {
 			"description": "Your order has been shipped and is on its way",
 			"completed":   order.Status == "shipped" || order.Status == "delivered",
 		},
-		{
-			"status":      "delivered",
-			"title":       "Delivered",
-			"description": "Your order has been delivered successfully",
-			"timestamp":   order.DeliveredAt,
-			"completed":   order.Status == "delivered",
-		},
+	}
+
+	// Carrier updates go between shipping and delivery, oldest first
+	var carrierEvents []models.TrackingEvent
+	for _, shipment := range order.Shipments {
+		carrierEvents = append(carrierEvents, shipment.TrackingEvents...)
+	}
+	sort.Slice(carrierEvents, func(i, j int) bool {
+		return carrierEvents[i].OccurredAt.Before(carrierEvents[j].OccurredAt)
+	})
+	for _, event := range carrierEvents {
+		timeline = append(timeline, gin.H{
+			"status":          event.Status,
+			"title":           trackingEventTitles[event.Status],
+			"description":     event.Description,
+			"location":        event.Location,
+			"timestamp":       event.OccurredAt,
+			"completed":       true,
+			"carrier":         event.Carrier,
+			"tracking_number": event.TrackingNumber,
+		})
+	}
+
+	timeline = append(timeline, gin.H{
+		"status":      "delivered",
+		"title":       "Delivered",
+		"description": "Your order has been delivered successfully",
+		"timestamp":   order.DeliveredAt,
+		"completed":   order.Status == "delivered",
+	})
+
+	// Only the progress of the parcels is shown; items, addresses and the customer are not.
+	// The shipment the tracking number refers to is pointed out, if any.
+	shipments := make([]gin.H, 0, len(order.Shipments))
+	var shipment gin.H
+	for _, parcel := range order.Shipments {
+		summary := gin.H{
+			"id":              parcel.ID,
+			"carrier":         parcel.Carrier,
+			"tracking_number": parcel.TrackingNumber,
+			"status":          parcel.Status,
+			"shipped_at":      parcel.ShippedAt,
+			"delivered_at":    parcel.DeliveredAt,
+		}
+		shipments = append(shipments, summary)
+		if parcel.TrackingNumber != "" && parcel.TrackingNumber == trackingNumber {
+			shipment = summary
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"order_number": order.OrderNumber,
+		"status":       order.Status,
+		"timeline":     timeline,
+		"shipments":    shipments,
+		"shipment":     shipment,
+	})
+}
+
+// orderContactMatches reports whether a contact, an email or a phone number, is one the order
+// was placed with
+func orderContactMatches(order models.Order, contact string) bool {
+	if contact == "" {
+		return false
+	}
+
+	emails := []string{order.GuestEmail}
+	phones := []string{order.GuestPhone, order.ShippingAddress.Phone}
+	if order.UserID != nil {
+		emails = append(emails, order.User.Email)
+		phones = append(phones, order.User.Phone)
+	}
+
+	for _, email := range emails {
+		if email != "" && strings.EqualFold(email, contact) {
+			return true
+		}
+	}
+	for _, phone := range phones {
+		if samePhone(phone, contact) {
+			return true
+		}
+	}
+	return false
+}
+
+// samePhone compares phone numbers by their last nine digits, so 0712345678 and
+// +254712345678 are the same number
+func samePhone(a, b string) bool {
+	digits := func(phone string) string {
+		var kept []rune
+		for _, r := range phone {
+			if r >= '0' && r <= '9' {
+				kept = append(kept, r)
+			}
+		}
+		return string(kept)
+	}
+
+	a, b = digits(a), digits(b)
+	if len(a) < 9 || len(b) < 9 {
+		return false
+	}
+	return a[len(a)-9:] == b[len(b)-9:]
+}
+
+// orderInput holds everything needed to place an order, whether it comes from checkout
+// or from a subscription
+type orderInput struct {
+	UserID          *uint
+	Items           []OrderItemRequest
+	ShippingAddress models.Address
+	BillingAddress  models.Address
+	PaymentMethod   string
+	Notes           string
+	DeliverySlotID  *uint
+	FulfilmentType  string // delivery when empty
+	PickupPointID   *uint
+	CouponCode      string
+	CartID          uint // the buyer's cart, whose stock reservations are used up by the order
+}
+
+var errCouponUnavailable = errors.New("Coupon has reached its usage limit")
+
+// checkoutError is an order creation failure whose message can be shown to the customer
+type checkoutError struct {
+	status  int
+	message string
+}
+
+func (e *checkoutError) Error() string {
+	return e.message
+}
+
+// createOrder prices the items, books the delivery slot, stores the order and deducts stock
+// in one transaction. Payment is left to the caller.
+func createOrder(db *gorm.DB, orderNumbers *services.OrderNumberService, input orderInput) (*models.Order, error) {
+	orderItems, err := buildOrderItems(db, input.Items, input.CartID)
+	if err != nil {
+		return nil, &checkoutError{status: http.StatusBadRequest, message: err.Error()}
+	}
+
+	orderNumber, err := orderNumbers.Next(db)
+	if err != nil {
+		return nil, err
+	}
+
+	fulfilmentType := input.FulfilmentType
+	if fulfilmentType == "" {
+		fulfilmentType = "delivery"
+	}
+	if fulfilmentType == "pickup" && (input.PickupPointID == nil || input.DeliverySlotID != nil) {
+		return nil, &checkoutError{status: http.StatusBadRequest, message: "Pickup orders need a pickup point and no delivery slot"}
+	}
+
+	// Price the order exactly as the cart quote did
+	breakdown, err := priceOrderItems(db, orderItems, fulfilmentType, input.CouponCode)
+	if err != nil {
+		return nil, err
+	}
+
+	order := &models.Order{
+		UserID:          input.UserID,
+		OrderNumber:     orderNumber,
+		Status:          "pending",
+		PaymentStatus:   "pending",
+		PaymentMethod:   input.PaymentMethod,
+		TotalAmount:     breakdown.Total,
+		ShippingAmount:  breakdown.Shipping,
+		TaxAmount:       breakdown.Tax,
+		DiscountAmount:  breakdown.Discount,
+		CouponCode:      breakdown.CouponCode,
+		Items:           orderItems,
+		ShippingAddress: input.ShippingAddress,
+		BillingAddress:  input.BillingAddress,
+		Notes:           input.Notes,
+		DeliverySlotID:  input.DeliverySlotID,
+		FulfilmentType:  fulfilmentType,
+	}
+	if fulfilmentType == "pickup" {
+		order.PickupPointID = input.PickupPointID
+	}
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if order.DeliverySlotID != nil {
+			if err := bookDeliverySlot(tx, *order.DeliverySlotID, order.ShippingAddress); err != nil {
+				return err
+			}
+		}
+		if order.PickupPointID != nil {
+			code, err := reservePickupPoint(tx, *order.PickupPointID)
+			if err != nil {
+				return err
+			}
+			order.PickupCode = code
+		}
+		if order.CouponCode != "" {
+			if err := redeemCoupon(tx, order.CouponCode); err != nil {
+				return err
+			}
+		}
+
+		if err := tx.Create(order).Error; err != nil {
+			return err
+		}
+
+		// Take stock, or count backordered items against the product's cap
+		if err := reserveOrderItems(tx, order.Items); err != nil {
+			return err
+		}
+
+		// The stock the cart was holding now belongs to the order
+		if input.CartID != 0 {
+			if err := releasePurchasedReservations(tx, input.CartID, order.Items); err != nil {
+				return err
+			}
+		}
+
+		// Each vendor fulfils their own items
+		return splitOrderByVendor(tx, order.ID)
+	})
+	if err != nil {
+		if err == errDeliverySlotOutOfZone {
+			return nil, &checkoutError{status: http.StatusBadRequest, message: err.Error()}
+		}
+		if err == errDeliverySlotUnavailable || err == errPickupPointUnavailable || err == errCouponUnavailable {
+			return nil, &checkoutError{status: http.StatusConflict, message: err.Error()}
+		}
+		return nil, err
+	}
+
+	return order, nil
+}
+
+// buildOrderItems prices the requested items at current product prices after checking stock.
+// Stock reserved by carts other than the buyer's is not counted as available.
+// The returned error is safe to show to the customer.
+func buildOrderItems(db *gorm.DB, items []OrderItemRequest, cartID uint) ([]models.OrderItem, error) {
+	var orderItems []models.OrderItem
+
+	for _, item := range mergeOrderItemRequests(items) {
+		var product models.Product
+		if err := db.First(&product, item.ProductID).Error; err != nil {
+			return nil, fmt.Errorf("Product %d not found", item.ProductID)
+		}
+
+		variant, err := findOrderVariant(db, product, item.VariantID)
+		if checkoutErr, ok := err.(*checkoutError); ok {
+			return nil, errors.New(checkoutErr.message)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("Failed to check options for product %s", product.Name)
+		}
+
+		available, err := availableStock(db, product, variant, cartID)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to check stock for product %s", product.Name)
+		}
+
+		// Out-of-stock lines are accepted whole as backorders or pre-orders when the product allows it
+		status := "allocated"
+		if available < item.Quantity {
+			if !acceptsItemBackorder(product, variant, item.Quantity) {
+				return nil, fmt.Errorf("Insufficient stock for product %s", itemName(product, variant))
+			}
+			status = waitingStatus(product)
+		}
+
+		price := itemPrice(product, variant)
+		itemTotal := price * float64(item.Quantity)
+
+		orderItem := models.OrderItem{
+			ProductID: item.ProductID,
+			Quantity:  item.Quantity,
+			Price:     price,
+			Total:     itemTotal,
+			Status:    status,
+		}
+		if variant != nil {
+			orderItem.VariantID = &variant.ID
+			orderItem.VariantName = variant.Name
+		}
+		orderItems = append(orderItems, orderItem)
+	}
+
+	return orderItems, nil
+}
+
+// mergeOrderItemRequests adds up requested lines for the same product and variant, so an
+// order has one line for each
+func mergeOrderItemRequests(items []OrderItemRequest) []OrderItemRequest {
+	merged := make([]OrderItemRequest, 0, len(items))
+	index := make(map[orderLineKey]int)
+	for _, item := range items {
+		key := lineKey(item.ProductID, item.VariantID)
+		if i, ok := index[key]; ok {
+			merged[i].Quantity += item.Quantity
+			continue
+		}
+		index[key] = len(merged)
+		merged = append(merged, item)
+	}
+	return merged
+}
+
+// initiateOrderPayment sends a payment request for an order through the chosen provider
+func initiateOrderPayment(paymentService *services.PaymentService, orderID uint, method, phoneNumber string, amount float64, purpose string) (*models.Payment, error) {
+	switch method {
+	case "mpesa":
+		return paymentService.InitiateMPesaPayment(orderID, phoneNumber, amount, purpose)
+	case "airtel":
+		return paymentService.InitiateAirtelPayment(orderID, phoneNumber, amount, purpose)
+	default:
+		return nil, fmt.Errorf("unsupported payment method: %s", method)
+	}
+}
+
+// priceOrderItems prices order lines through the pricing engine with an optional coupon code.
+// Errors about the coupon are returned as a checkoutError.
+func priceOrderItems(db *gorm.DB, items []models.OrderItem, fulfilmentType, couponCode string) (*pricing.Breakdown, error) {
+	coupon, err := findCoupon(db, couponCode)
+	if err != nil {
+		return nil, err
+	}
+
+	lines := make([]pricing.Item, 0, len(items))
+	for _, item := range items {
+		lines = append(lines, pricing.Item{ProductID: item.ProductID, Quantity: item.Quantity, UnitPrice: item.Price})
+	}
+
+	breakdown, err := pricing.Calculate(lines, pricing.Options{FulfilmentType: fulfilmentType, Coupon: coupon})
+	if err != nil {
+		return nil, &checkoutError{status: http.StatusBadRequest, message: err.Error()}
+	}
+	return breakdown, nil
+}
+
+// findCoupon looks a coupon up by code, ignoring case. No code means no coupon.
+func findCoupon(db *gorm.DB, code string) (*models.Coupon, error) {
+	code = strings.TrimSpace(code)
+	if code == "" {
+		return nil, nil
+	}
+
+	var coupon models.Coupon
+	if err := db.Where("UPPER(code) = UPPER(?)", code).First(&coupon).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, &checkoutError{status: http.StatusBadRequest, message: "Invalid coupon code"}
+		}
+		return nil, err
+	}
+	return &coupon, nil
+}
+
+// redeemCoupon counts a use of a coupon, failing if another order took its last use first
+func redeemCoupon(tx *gorm.DB, code string) error {
+	result := tx.Model(&models.Coupon{}).
+		Where("code = ? AND is_active = ? AND (usage_limit = 0 OR used_count < usage_limit)", code, true).
+		Update("used_count", gorm.Expr("used_count + 1"))
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return errCouponUnavailable
+	}
+	return nil
+}
+
+// releaseCoupon gives back the use of a coupon taken by a cancelled order
+func releaseCoupon(tx *gorm.DB, code string) error {
+	return tx.Model(&models.Coupon{}).Where("code = ? AND used_count > 0", code).
+		Update("used_count", gorm.Expr("used_count - 1")).Error
+}
+
+// cancelOrderTx cancels a locked order, with its items loaded, and gives back what it holds:
+// stock and backorder places, its delivery slot and its coupon use. Its place at a pickup
+// point frees up with the status. Unverified guest orders never took any of these.
+func cancelOrderTx(tx *gorm.DB, order *models.Order) error {
+	if err := tx.Model(order).Update("status", "cancelled").Error; err != nil {
+		return err
+	}
+	order.Status = "cancelled"
+	if err := cancelVendorOrders(tx, order.ID); err != nil {
+		return err
+	}
+	if order.GuestPhone != "" && !order.GuestVerified {
+		return nil
+	}
+
+	if order.CouponCode != "" {
+		if err := releaseCoupon(tx, order.CouponCode); err != nil {
+			return err
+		}
+	}
+	if order.DeliverySlotID != nil {
+		if err := releaseDeliverySlot(tx, *order.DeliverySlotID); err != nil {
+			return err
+		}
+	}
+	return releaseOrderItems(tx, order.Items)
+}
+
+// releaseOrderCoupon removes an order's coupon, giving back its use if the order redeemed it.
+// Unverified guest orders never did.
+func releaseOrderCoupon(tx *gorm.DB, order *models.Order) error {
+	if order.CouponCode == "" {
+		return nil
+	}
+	if order.GuestPhone == "" || order.GuestVerified {
+		if err := releaseCoupon(tx, order.CouponCode); err != nil {
+			return err
+		}
+	}
+	order.CouponCode = ""
+	return nil
+}
+
+// paidFor is what the customer paid for items worth itemsTotal: their share of the order's
+// discount comes off and their share of its tax goes on, in proportion to the subtotal the
+// order was last priced on
+func paidFor(order models.Order, itemsTotal float64) float64 {
+	subtotal := order.TotalAmount - order.ShippingAmount - order.TaxAmount + order.DiscountAmount
+	if subtotal <= 0 {
+		return itemsTotal
+	}
+	return math.Round((itemsTotal-itemsTotal*order.DiscountAmount/subtotal+itemsTotal*order.TaxAmount/subtotal)*100) / 100
+}
+
+// settledAmount is what has been paid towards an order, less store credit already given back
+// for earlier edits and for sub-orders its vendors cancelled
+func settledAmount(db *gorm.DB, orderID uint) (float64, error) {
+	var paid, credited float64
+	if err := db.Model(&models.Payment{}).Where("order_id = ? AND status = ?", orderID, "success").
+		Select("COALESCE(SUM(amount), 0)").Scan(&paid).Error; err != nil {
+		return 0, err
+	}
+	if err := db.Model(&models.StoreCredit{}).
+		Where("(source = ? AND reference_id = ?) OR (source = ? AND reference_id IN (?))",
+			"order_edit", orderID, "vendor_cancellation", db.Model(&models.VendorOrder{}).Select("id").Where("order_id = ?", orderID)).
+		Select("COALESCE(SUM(amount), 0)").Scan(&credited).Error; err != nil {
+		return 0, err
+	}
+	return paid - credited, nil
+}
+
+// EditOrder replaces the items of a pending order, adjusting stock and settling any
+// payment difference: a new payment request for unpaid orders, a balance request when a paid
+// total rises, store credit when it falls
+func (h *OrderHandler) EditOrder(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid order ID"})
+		return
+	}
+
+	userID, _ := c.Get("user_id")
+	userRole := c.GetString("user_role")
+
+	var req EditOrderRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := h.validator.Struct(req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Merge duplicate lines so each product and variant appears once
+	quantities := make(map[orderLineKey]int)
+	var keys []orderLineKey
+	for _, item := range req.Items {
+		key := lineKey(item.ProductID, item.VariantID)
+		if _, ok := quantities[key]; !ok {
+			keys = append(keys, key)
+		}
+		quantities[key] += item.Quantity
+	}
+
+	var order models.Order
+	var previousTotal float64
+	var editErr error
+	var balance, credited float64
+	couponRemoved := false
+	err = h.db.Transaction(func(tx *gorm.DB) error {
+		query := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Preload("Items")
+		if userRole != "admin" {
+			query = query.Where("user_id = ?", userID)
+		}
+		if err := query.First(&order, id).Error; err != nil {
+			return err
+		}
+
+		if order.Status != "pending" {
+			editErr = errors.New("Only pending orders can be edited")
+			return editErr
+		}
+		previousTotal = order.TotalAmount
+
+		existing := make(map[orderLineKey]models.OrderItem)
+		for _, item := range order.Items {
+			// Items cancelled with their vendor's sub-order were restocked then and aren't charged
+			if item.Status == "cancelled" {
+				continue
+			}
+			// Editing would reshuffle the backorder queue
+			if isWaitingItem(item) {
+				editErr = errors.New("Orders with backordered or pre-ordered items cannot be edited")
+				return editErr
+			}
+			// Orders placed before lines were merged may have the same product twice; the
+			// lines are folded into one so none is left out of the edit
+			key := lineKey(item.ProductID, item.VariantID)
+			if first, ok := existing[key]; ok {
+				first.Quantity += item.Quantity
+				first.Total += item.Total
+				existing[key] = first
+				if err := tx.Delete(&item).Error; err != nil {
+					return err
+				}
+				continue
+			}
+			existing[key] = item
+		}
+
+		// Release stock for removed lines
+		for key, item := range existing {
+			if _, ok := quantities[key]; ok {
+				continue
+			}
+			if err := returnStock(tx, item.ProductID, item.VariantID, item.Quantity); err != nil {
+				return err
+			}
+			if err := tx.Delete(&item).Error; err != nil {
+				return err
+			}
+		}
+
+		var lines []pricing.Item
+		for _, key := range keys {
+			quantity := quantities[key]
+
+			var product models.Product
+			if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&product, key.ProductID).Error; err != nil {
+				if err == gorm.ErrRecordNotFound {
+					editErr = fmt.Errorf("Product %d not found", key.ProductID)
+					return editErr
+				}
+				return err
+			}
+
+			item, found := existing[key]
+
+			// New lines must name an available variant; existing ones keep theirs
+			var variant *models.ProductVariant
+			if !found {
+				var variantID *uint
+				if key.VariantID != 0 {
+					variantID = &key.VariantID
+				}
+				var err error
+				if variant, err = findOrderVariant(tx, product, variantID); err != nil {
+					if checkoutErr, ok := err.(*checkoutError); ok {
+						editErr = errors.New(checkoutErr.message)
+						return editErr
+					}
+					return err
+				}
+				if variant != nil {
+					item.VariantID = &variant.ID
+					item.VariantName = variant.Name
+				}
+			} else if item.VariantID != nil {
+				variant = &models.ProductVariant{}
+				if err := tx.Unscoped().First(variant, *item.VariantID).Error; err != nil {
+					return err
+				}
+			}
+
+			delta := quantity - item.Quantity
+			if delta > 0 {
+				taken, err := takeStock(tx, product.ID, item.VariantID, delta)
+				if err != nil {
+					return err
+				}
+				if !taken {
+					editErr = fmt.Errorf("Insufficient stock for product %s", itemName(product, variant))
+					return editErr
+				}
+			}
+			if delta < 0 {
+				if err := returnStock(tx, product.ID, item.VariantID, -delta); err != nil {
+					return err
+				}
+			}
+
+			// Untouched lines keep the price they were ordered at
+			price := item.Price
+			if !found || delta != 0 {
+				if !found && product.Status != "active" {
+					editErr = fmt.Errorf("Product %s is not available", product.Name)
+					return editErr
+				}
+				price = itemPrice(product, variant)
+			}
+
+			item.OrderID = order.ID
+			item.ProductID = key.ProductID
+			item.Quantity = quantity
+			item.Price = price
+			item.Total = price * float64(quantity)
+			lines = append(lines, pricing.Item{ProductID: key.ProductID, Quantity: quantity, UnitPrice: price})
+			if err := tx.Save(&item).Error; err != nil {
+				return err
+			}
+		}
+
+		// Vendors only see the items that are still theirs
+		if err := splitOrderByVendor(tx, order.ID); err != nil {
+			return err
+		}
+
+		// The order's coupon still applies, recalculated for the new items. A coupon deleted
+		// since keeps the discount the order already had.
+		var coupon *models.Coupon
+		if order.CouponCode != "" {
+			var err error
+			if coupon, err = findCoupon(tx, order.CouponCode); err != nil {
+				if _, ok := err.(*checkoutError); !ok {
+					return err
+				}
+				coupon = &models.Coupon{Code: order.CouponCode, Type: "fixed", Value: order.DiscountAmount}
+			}
+		}
+		breakdown, err := pricing.Calculate(lines, pricing.Options{
+			FulfilmentType: order.FulfilmentType,
+			Coupon:         coupon,
+			CouponRedeemed: true,
+		})
+		if err == pricing.ErrCouponMinAmount {
+			// The edited order no longer qualifies, so the coupon is dropped and its use given back
+			if err := releaseOrderCoupon(tx, &order); err != nil {
+				return err
+			}
+			couponRemoved = true
+			breakdown, err = pricing.Calculate(lines, pricing.Options{FulfilmentType: order.FulfilmentType})
+		}
+		if err != nil {
+			return err
+		}
+		order.ShippingAmount = breakdown.Shipping
+		order.TaxAmount = breakdown.Tax
+		order.DiscountAmount = breakdown.Discount
+		order.TotalAmount = breakdown.Total
+
+		if order.TotalAmount == previousTotal {
+			return tx.Omit("Items", "User").Save(&order).Error
+		}
+
+		// Payment requests for the old total can no longer settle the order
+		if err := tx.Model(&models.Payment{}).Where("order_id = ? AND status = ?", order.ID, "pending").
+			Update("status", "superseded").Error; err != nil {
+			return err
+		}
+
+		// Orders paid in full or in part settle against what has been paid so far
+		if order.PaymentStatus == "paid" || order.PaymentStatus == "partially_paid" {
+			settled, err := settledAmount(tx, order.ID)
+			if err != nil {
+				return err
+			}
+			switch {
+			case order.TotalAmount > settled+0.005:
+				balance = math.Round((order.TotalAmount-settled)*100) / 100
+				order.PaymentStatus = "partially_paid"
+			case order.TotalAmount < settled-0.005 && order.UserID != nil:
+				credited = math.Round((settled-order.TotalAmount)*100) / 100
+				credit := models.StoreCredit{
+					UserID:      *order.UserID,
+					Amount:      credited,
+					Source:      "order_edit",
+					ReferenceID: order.ID,
+					Description: fmt.Sprintf("Credit for changes to order %s", order.OrderNumber),
+				}
+				if err := tx.Create(&credit).Error; err != nil {
+					return err
+				}
+				order.PaymentStatus = "paid"
+			default:
+				order.PaymentStatus = "paid"
+			}
+		}
+
+		return tx.Omit("Items", "User").Save(&order).Error
+	})
+	if err != nil {
+		if editErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": editErr.Error()})
+			return
+		}
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Order not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to edit order"})
+		return
+	}
+
+	difference := order.TotalAmount - previousTotal
+	response := gin.H{
+		"message":    "Order updated successfully",
+		"difference": difference,
+	}
+	if couponRemoved {
+		response["coupon_removed"] = true
+	}
+
+	phone := req.PhoneNumber
+	if phone == "" {
+		phone = order.ShippingAddress.Phone
+	}
+
+	switch {
+	case balance > 0:
+		// Request the balance for orders that were already paid. A failed balance payment
+		// leaves the order's stock alone.
+		payment, err := initiateOrderPayment(h.paymentService, order.ID, order.PaymentMethod, phone, balance, "balance")
+		if err != nil {
+			response["payment_error"] = "Failed to initiate payment for the balance"
+		} else {
+			response["payment"] = payment
+		}
+	case order.PaymentStatus == "pending" && difference != 0 && (order.GuestPhone == "" || order.GuestVerified):
+		// Unpaid orders are asked for the new total instead of the old one
+		payment, err := initiateOrderPayment(h.paymentService, order.ID, order.PaymentMethod, phone, order.TotalAmount, "order")
+		if err != nil {
+			response["payment_error"] = "Failed to initiate payment for the new total"
+		} else {
+			response["payment"] = payment
+		}
+	case credited > 0:
+		response["store_credit"] = credited
+	}
+
+	h.db.Preload("Items.Product").Preload("User").First(&order, order.ID)
+	response["order"] = order
+
+	c.JSON(http.StatusOK, response)
+}
+
+// ReorderOrder copies the items of a previous order into the user's cart, skipping products
+// that are no longer available and flagging quantity or price changes
+func (h *OrderHandler) ReorderOrder(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid order ID"})
+		return
+	}
+
+	userID, _ := c.Get("user_id")
+
+	var order models.Order
+	if err := h.db.Preload("Items").Where("user_id = ?", userID).First(&order, id).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Order not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch order"})
+		return
+	}
+
+	added := []gin.H{}
+	skipped := []gin.H{}
+
+	err = h.db.Transaction(func(tx *gorm.DB) error {
+		var cart models.Cart
+		uid := userID.(uint)
+		if err := tx.Where("user_id = ?", userID).FirstOrCreate(&cart, models.Cart{UserID: &uid}).Error; err != nil {
+			return err
+		}
+
+		for _, item := range order.Items {
+			var product models.Product
+			if err := tx.First(&product, item.ProductID).Error; err != nil {
+				if err == gorm.ErrRecordNotFound {
+					skipped = append(skipped, gin.H{"product_id": item.ProductID, "reason": "unavailable"})
+					continue
+				}
+				return err
+			}
+
+			if product.Status != "active" {
+				skipped = append(skipped, gin.H{"product_id": product.ID, "name": product.Name, "reason": "inactive"})
+				continue
+			}
+
+			var variant *models.ProductVariant
+			if item.VariantID != nil {
+				variant = &models.ProductVariant{}
+				if err := tx.First(variant, *item.VariantID).Error; err != nil || !variant.IsActive {
+					if err != nil && err != gorm.ErrRecordNotFound {
+						return err
+					}
+					skipped = append(skipped, gin.H{"product_id": product.ID, "name": itemName(product, &models.ProductVariant{Name: item.VariantName}), "reason": "inactive"})
+					continue
+				}
+			}
+
+			// Stock held in other shoppers' carts is not for sale
+			available, err := availableStock(tx, product, variant, cart.ID)
+			if err != nil {
+				return err
+			}
+			var cartItem models.CartItem
+			if err := cartLine(tx, cart.ID, product.ID, item.VariantID).First(&cartItem).Error; err == nil {
+				available -= cartItem.Quantity
+			}
+			if available <= 0 {
+				skipped = append(skipped, gin.H{"product_id": product.ID, "name": product.Name, "reason": "out_of_stock"})
+				continue
+			}
+
+			quantity := item.Quantity
+			if quantity > available {
+				quantity = available
+			}
+
+			// Added like any other cart line, so the line's price is recorded and its stock held
+			if _, err := h.cart.addCartItem(tx, &cart, product, variant, quantity); err != nil {
+				if _, ok := err.(*checkoutError); ok {
+					skipped = append(skipped, gin.H{"product_id": product.ID, "name": product.Name, "reason": "out_of_stock"})
+					continue
+				}
+				return err
+			}
+
+			added = append(added, gin.H{
+				"product_id":         product.ID,
+				"name":               product.Name,
+				"requested_quantity": item.Quantity,
+				"quantity":           quantity,
+				"quantity_reduced":   quantity < item.Quantity,
+				"previous_price":     item.Price,
+				"price":              itemPrice(product, variant),
+				"price_changed":      itemPrice(product, variant) != item.Price,
+			})
+		}
+
+		return tx.Model(&cart).Update("updated_at", time.Now()).Error
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to add items to cart"})
+		return
 	}
 
 	c.JSON(http.StatusOK, gin.H{
-		"order":    order,
-		"timeline": timeline,
+		"message": fmt.Sprintf("%d of %d items added to cart", len(added), len(order.Items)),
+		"added":   added,
+		"skipped": skipped,
 	})
 }
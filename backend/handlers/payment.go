@@ -5,6 +5,7 @@ import (
 	"crypto/rand"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
@@ -12,12 +13,16 @@ import (
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/yourname/sakifarm-ecommerce/models"
+	"github.com/yourname/sakifarm-ecommerce/services"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 type PaymentHandler struct {
-	db     *gorm.DB
-	config *Config
+	db             *gorm.DB
+	config         *Config
+	paymentService *services.PaymentService
 }
 
 type Config struct {
@@ -51,6 +56,34 @@ type MobilePaymentRequest struct {
 	OrderID     uint    `json:"order_id"`
 }
 
+// MPesaCallbackRequest is the body M-Pesa posts once the customer answers an STK push
+type MPesaCallbackRequest struct {
+	Body struct {
+		STKCallback struct {
+			MerchantRequestID string `json:"MerchantRequestID"`
+			CheckoutRequestID string `json:"CheckoutRequestID"`
+			ResultCode        int    `json:"ResultCode"`
+			ResultDesc        string `json:"ResultDesc"`
+			CallbackMetadata  struct {
+				Item []struct {
+					Name  string      `json:"Name"`
+					Value interface{} `json:"Value"`
+				} `json:"Item"`
+			} `json:"CallbackMetadata"`
+		} `json:"stkCallback"`
+	} `json:"Body"`
+}
+
+// AirtelCallbackRequest is the body Airtel Money posts once a collection completes
+type AirtelCallbackRequest struct {
+	Transaction struct {
+		ID            string `json:"id"`
+		Message       string `json:"message"`
+		StatusCode    string `json:"status_code"` // TS successful, TF failed
+		AirtelMoneyID string `json:"airtel_money_id"`
+	} `json:"transaction"`
+}
+
 type MPesaTokenResponse struct {
 	AccessToken string `json:"access_token"`
 	ExpiresIn   string `json:"expires_in"`
@@ -78,10 +111,11 @@ type MPesaSTKPushResponse struct {
 	CustomerMessage     string `json:"CustomerMessage"`
 }
 
-func NewPaymentHandler(db *gorm.DB, config *Config) *PaymentHandler {
+func NewPaymentHandler(db *gorm.DB, config *Config, paymentService *services.PaymentService) *PaymentHandler {
 	return &PaymentHandler{
-		db:     db,
-		config: config,
+		db:             db,
+		config:         config,
+		paymentService: paymentService,
 	}
 }
 
@@ -167,50 +201,73 @@ func (h *PaymentHandler) GetPaymentStatus(c *gin.Context) {
 	})
 }
 
+// MPesaCallback receives the outcome of an STK push. The payment is found by the
+// CheckoutRequestID stored when the push was sent, and the result is passed on to the order.
 func (h *PaymentHandler) MPesaCallback(c *gin.Context) {
-	var callbackData map[string]interface{}
-	if err := c.ShouldBindJSON(&callbackData); err != nil {
+	var req MPesaCallbackRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid callback data"})
+		return
+	}
+
+	callback := req.Body.STKCallback
+	if callback.CheckoutRequestID == "" {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid callback data"})
 		return
 	}
 
-	// Process M-Pesa callback
-	// This is a simplified version - in production, you'd need to handle the full callback structure
-	if body, exists := callbackData["Body"]; exists {
-		if stkCallback, ok := body.(map[string]interface{})["stkCallback"]; ok {
-			callback := stkCallback.(map[string]interface{})
-			
-			checkoutRequestID := callback["CheckoutRequestID"].(string)
-			resultCode := callback["ResultCode"].(float64)
-			
-			var payment Payment
-			if err := h.db.Where("reference LIKE ?", "%"+checkoutRequestID+"%").First(&payment).Error; err == nil {
-				if resultCode == 0 {
-					payment.Status = "completed"
-				} else {
-					payment.Status = "failed"
-				}
-				h.db.Save(&payment)
-			}
+	var receipt string
+	for _, item := range callback.CallbackMetadata.Item {
+		if item.Name == "MpesaReceiptNumber" {
+			receipt = fmt.Sprint(item.Value)
 		}
 	}
 
-	c.JSON(http.StatusOK, gin.H{"status": "success"})
+	h.processCallback(c, "mpesa", callback.CheckoutRequestID, callback.ResultCode == 0, receipt)
 }
 
+// AirtelCallback receives the outcome of an Airtel Money collection, found by the
+// transaction ID sent with the request
 func (h *PaymentHandler) AirtelCallback(c *gin.Context) {
-	var callbackData map[string]interface{}
-	if err := c.ShouldBindJSON(&callbackData); err != nil {
+	var req AirtelCallbackRequest
+	if err := c.ShouldBindJSON(&req); err != nil || req.Transaction.ID == "" {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid callback data"})
 		return
 	}
 
-	// Process Airtel callback
-	// Implementation depends on Airtel's callback structure
-	
+	h.processCallback(c, "airtel", req.Transaction.ID, req.Transaction.StatusCode == "TS", req.Transaction.AirtelMoneyID)
+}
+
+// processCallback records a provider's result against its payment and order. Results for
+// unknown payments are acknowledged so the provider stops resending them.
+func (h *PaymentHandler) processCallback(c *gin.Context, method, transactionID string, success bool, externalRef string) {
+	err := h.paymentService.ProcessPaymentCallback(method, transactionID, success, externalRef, cancelFailedOrder)
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to process callback"})
+		return
+	}
+
 	c.JSON(http.StatusOK, gin.H{"status": "success"})
 }
 
+// cancelFailedOrder cancels an order whose payment failed, giving back what it holds. Orders
+// paid or cancelled in the meantime are left as they are.
+func cancelFailedOrder(tx *gorm.DB, orderID uint) error {
+	var order models.Order
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Preload("Items").First(&order, orderID).Error; err != nil {
+		return err
+	}
+	if order.Status == "cancelled" || order.PaymentStatus == "paid" {
+		return nil
+	}
+
+	// Backordered items leave the queue along with the stock they gave back
+	if err := tx.Model(&order).Update("payment_status", "failed").Error; err != nil {
+		return err
+	}
+	return cancelOrderTx(tx, &order)
+}
+
 func (h *PaymentHandler) initiateMPesaPayment(payment *Payment) error {
 	// Get M-Pesa access token
 	token, err := h.getMPesaAccessToken()
@@ -0,0 +1,425 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+	"github.com/yourname/sakifarm-ecommerce/models"
+	"github.com/yourname/sakifarm-ecommerce/services"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var errPickupPointUnavailable = errors.New("Selected pickup point is full or no longer available")
+
+const (
+	maxPickupCodeAttempts = 5                // wrong codes at a pickup point before it is locked
+	pickupCodeLockout     = 15 * time.Minute // how long a locked pickup point refuses codes
+)
+
+type PickupPointHandler struct {
+	db        *gorm.DB
+	validator *validator.Validate
+}
+
+func NewPickupPointHandler(db *gorm.DB) *PickupPointHandler {
+	return &PickupPointHandler{
+		db:        db,
+		validator: validator.New(),
+	}
+}
+
+type PickupPointRequest struct {
+	Name         string  `json:"name" validate:"required"`
+	Address      string  `json:"address" validate:"required"`
+	City         string  `json:"city" validate:"required"`
+	Latitude     float64 `json:"latitude" validate:"omitempty,latitude"`
+	Longitude    float64 `json:"longitude" validate:"omitempty,longitude"`
+	Phone        string  `json:"phone"`
+	OpeningHours string  `json:"opening_hours" validate:"required"`
+	Capacity     int     `json:"capacity" validate:"min=0"`
+	IsActive     *bool   `json:"is_active,omitempty"`
+}
+
+type ConfirmCollectionRequest struct {
+	PickupPointID uint   `json:"pickup_point_id" validate:"required"`
+	Code          string `json:"code" validate:"required,len=6,numeric"`
+}
+
+// GetPickupPoints lists active pickup points and whether they can take more orders
+func (h *PickupPointHandler) GetPickupPoints(c *gin.Context) {
+	query := h.db.Where("is_active = ?", true)
+	if city := c.Query("city"); city != "" {
+		query = query.Where("city ILIKE ?", city)
+	}
+
+	var points []models.PickupPoint
+	if err := query.Order("name ASC").Find(&points).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch pickup points"})
+		return
+	}
+
+	waiting, err := awaitingCollection(h.db)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch pickup points"})
+		return
+	}
+
+	available := make([]gin.H, 0, len(points))
+	for _, point := range points {
+		entry := gin.H{
+			"id":            point.ID,
+			"name":          point.Name,
+			"address":       point.Address,
+			"city":          point.City,
+			"latitude":      point.Latitude,
+			"longitude":     point.Longitude,
+			"phone":         point.Phone,
+			"opening_hours": point.OpeningHours,
+			"available":     point.Capacity == 0 || waiting[point.ID] < point.Capacity,
+		}
+		available = append(available, entry)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"pickup_points": available})
+}
+
+// GetAllPickupPoints lists every pickup point with the number of orders awaiting collection (admin)
+func (h *PickupPointHandler) GetAllPickupPoints(c *gin.Context) {
+	var points []models.PickupPoint
+	if err := h.db.Order("name ASC").Find(&points).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch pickup points"})
+		return
+	}
+
+	waiting, err := awaitingCollection(h.db)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch pickup points"})
+		return
+	}
+
+	result := make([]gin.H, 0, len(points))
+	for _, point := range points {
+		result = append(result, gin.H{
+			"pickup_point":        point,
+			"awaiting_collection": waiting[point.ID],
+		})
+	}
+
+	c.JSON(http.StatusOK, gin.H{"pickup_points": result})
+}
+
+// CreatePickupPoint adds a new collection location (admin)
+func (h *PickupPointHandler) CreatePickupPoint(c *gin.Context) {
+	var req PickupPointRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := h.validator.Struct(req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	point := models.PickupPoint{
+		Name:         req.Name,
+		Address:      req.Address,
+		City:         req.City,
+		Latitude:     req.Latitude,
+		Longitude:    req.Longitude,
+		Phone:        req.Phone,
+		OpeningHours: req.OpeningHours,
+		Capacity:     req.Capacity,
+		IsActive:     true,
+	}
+
+	if err := h.db.Create(&point).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create pickup point"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"message":      "Pickup point created successfully",
+		"pickup_point": point,
+	})
+}
+
+// UpdatePickupPoint changes a pickup point's details, hours, capacity or activation (admin)
+func (h *PickupPointHandler) UpdatePickupPoint(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid pickup point ID"})
+		return
+	}
+
+	var req PickupPointRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := h.validator.Struct(req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var point models.PickupPoint
+	if err := h.db.First(&point, id).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Pickup point not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch pickup point"})
+		return
+	}
+
+	point.Name = req.Name
+	point.Address = req.Address
+	point.City = req.City
+	point.Latitude = req.Latitude
+	point.Longitude = req.Longitude
+	point.Phone = req.Phone
+	point.OpeningHours = req.OpeningHours
+	point.Capacity = req.Capacity
+	if req.IsActive != nil {
+		point.IsActive = *req.IsActive
+	}
+
+	if err := h.db.Save(&point).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update pickup point"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":      "Pickup point updated successfully",
+		"pickup_point": point,
+	})
+}
+
+// DeletePickupPoint removes a pickup point, or deactivates it while orders await collection (admin)
+func (h *PickupPointHandler) DeletePickupPoint(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid pickup point ID"})
+		return
+	}
+
+	var point models.PickupPoint
+	if err := h.db.First(&point, id).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Pickup point not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch pickup point"})
+		return
+	}
+
+	var orderCount int64
+	h.db.Model(&models.Order{}).Where("pickup_point_id = ?", point.ID).Count(&orderCount)
+	if orderCount > 0 {
+		if err := h.db.Model(&point).Update("is_active", false).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to deactivate pickup point"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"message": "Pickup point deactivated (has orders)"})
+		return
+	}
+
+	if err := h.db.Delete(&point).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete pickup point"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Pickup point deleted successfully"})
+}
+
+// ConfirmCollection lets staff at a pickup point hand over an order against its pickup code.
+// The order is marked delivered.
+func (h *PickupPointHandler) ConfirmCollection(c *gin.Context) {
+	var req ConfirmCollectionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := h.validator.Struct(req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Wrong codes are counted against the pickup point, and the point is locked for a
+	// while once too many miss, so codes can't be guessed by trying them all
+	var order models.Order
+	var codeErr *checkoutError
+	now := time.Now()
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		var point models.PickupPoint
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&point, req.PickupPointID).Error; err != nil {
+			if err == gorm.ErrRecordNotFound {
+				codeErr = &checkoutError{http.StatusNotFound, "Pickup point not found"}
+				return nil
+			}
+			return err
+		}
+		if point.CodeLockedUntil != nil && point.CodeLockedUntil.After(now) {
+			codeErr = &checkoutError{http.StatusTooManyRequests, "Too many wrong codes, try again later"}
+			return nil
+		}
+
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("pickup_point_id = ? AND pickup_code = ? AND fulfilment_type = ? AND status NOT IN ?",
+				req.PickupPointID, req.Code, "pickup", []string{"delivered", "cancelled"}).
+			First(&order).Error
+		if err == gorm.ErrRecordNotFound {
+			updates := map[string]interface{}{"failed_code_attempts": point.FailedCodeAttempts + 1}
+			if point.FailedCodeAttempts+1 >= maxPickupCodeAttempts {
+				updates = map[string]interface{}{
+					"failed_code_attempts": 0,
+					"code_locked_until":    now.Add(pickupCodeLockout),
+				}
+			}
+			if err := tx.Model(&point).Updates(updates).Error; err != nil {
+				return err
+			}
+			codeErr = &checkoutError{http.StatusNotFound, "No order awaiting collection matches this code"}
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		if point.FailedCodeAttempts > 0 {
+			if err := tx.Model(&point).Update("failed_code_attempts", 0).Error; err != nil {
+				return err
+			}
+		}
+
+		if order.PaymentStatus != "paid" {
+			codeErr = &checkoutError{http.StatusBadRequest, "Order has not been paid"}
+			return nil
+		}
+
+		return tx.Model(&order).Updates(map[string]interface{}{
+			"status":       "delivered",
+			"delivered_at": now,
+			"collected_at": now,
+		}).Error
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to confirm collection"})
+		return
+	}
+	if codeErr != nil {
+		c.JSON(codeErr.status, gin.H{"error": codeErr.message})
+		return
+	}
+	h.db.Preload("Items.Product").First(&order, order.ID)
+
+	if order.UserID != nil {
+		h.db.Create(&models.Notification{
+			UserID:  *order.UserID,
+			Title:   "Order collected",
+			Message: fmt.Sprintf("Order %s was collected on %s. Enjoy!", order.OrderNumber, now.Format("January 2, 2006 at 3:04 PM")),
+			Type:    "order",
+		})
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Collection confirmed",
+		"order":   order,
+	})
+}
+
+// awaitingCollection counts open pickup orders per pickup point. Unverified guest
+// orders don't hold a place yet.
+func awaitingCollection(db *gorm.DB) (map[uint]int, error) {
+	var rows []struct {
+		PickupPointID uint
+		Count         int
+	}
+	err := db.Model(&models.Order{}).
+		Select("pickup_point_id, COUNT(*) as count").
+		Where("fulfilment_type = ? AND pickup_point_id IS NOT NULL AND status NOT IN ?", "pickup", []string{"delivered", "cancelled"}).
+		Where("COALESCE(guest_phone, '') = '' OR guest_verified = ?", true).
+		Group("pickup_point_id").
+		Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+
+	counts := make(map[uint]int)
+	for _, row := range rows {
+		counts[row.PickupPointID] = row.Count
+	}
+	return counts, nil
+}
+
+// reservePickupPoint checks that a pickup point can take one more order and returns a
+// pickup code that is unique among orders awaiting collection there. Locking the point's
+// row keeps concurrent checkouts from overfilling it.
+func reservePickupPoint(tx *gorm.DB, pickupPointID uint) (string, error) {
+	var point models.PickupPoint
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("id = ? AND is_active = ?", pickupPointID, true).First(&point).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return "", errPickupPointUnavailable
+		}
+		return "", err
+	}
+
+	if point.Capacity > 0 {
+		var waiting int64
+		if err := tx.Model(&models.Order{}).
+			Where("pickup_point_id = ? AND fulfilment_type = ? AND status NOT IN ?", point.ID, "pickup", []string{"delivered", "cancelled"}).
+			Where("COALESCE(guest_phone, '') = '' OR guest_verified = ?", true).
+			Count(&waiting).Error; err != nil {
+			return "", err
+		}
+		if int(waiting) >= point.Capacity {
+			return "", errPickupPointUnavailable
+		}
+	}
+
+	for attempt := 0; attempt < 5; attempt++ {
+		n, err := rand.Int(rand.Reader, big.NewInt(1000000))
+		if err != nil {
+			return "", err
+		}
+		code := fmt.Sprintf("%06d", n.Int64())
+
+		var taken int64
+		if err := tx.Model(&models.Order{}).
+			Where("pickup_point_id = ? AND pickup_code = ? AND status NOT IN ?", point.ID, code, []string{"delivered", "cancelled"}).
+			Count(&taken).Error; err != nil {
+			return "", err
+		}
+		if taken == 0 {
+			return code, nil
+		}
+	}
+	return "", errors.New("failed to generate a unique pickup code")
+}
+
+// sendPickupCode texts the pickup code and location to the customer
+func sendPickupCode(db *gorm.DB, smsService *services.SMSService, phone string, order *models.Order) {
+	if smsService == nil || phone == "" || order.PickupPointID == nil {
+		return
+	}
+
+	var point models.PickupPoint
+	if err := db.First(&point, *order.PickupPointID).Error; err != nil {
+		return
+	}
+
+	message := fmt.Sprintf("Order %s will be ready for collection at %s, %s (%s). Your pickup code is %s. Show it when collecting.",
+		order.OrderNumber, point.Name, point.Address, point.OpeningHours, order.PickupCode)
+	smsService.SendSMS(phone, message)
+}
@@ -26,7 +26,8 @@ type CreateProductRequest struct {
 	Name        string  `json:"name" validate:"required,min=2"`
 	Description string  `json:"description"`
 	Price       float64 `json:"price" validate:"required,min=0"`
-	Category    string  `json:"category" validate:"required"`
+	Category    string  `json:"category" validate:"required_without=CategoryID"` // a category name, if no ID is given
+	CategoryID  *uint   `json:"category_id"`
 	Brand       string  `json:"brand"`
 	SKU         string  `json:"sku"`
 	Stock       int     `json:"stock" validate:"min=0"`
@@ -47,6 +48,7 @@ type UpdateProductRequest struct {
 	Description *string  `json:"description,omitempty"`
 	Price       *float64 `json:"price,omitempty"`
 	Category    *string  `json:"category,omitempty"`
+	CategoryID  *uint    `json:"category_id,omitempty"`
 	Brand       *string  `json:"brand,omitempty"`
 	SKU         *string  `json:"sku,omitempty"`
 	Stock       *int     `json:"stock,omitempty"`
@@ -68,11 +70,22 @@ func (h *ProductHandler) CreateProduct(c *gin.Context) {
 		return
 	}
 
+	category, err := productCategory(h.db, req.CategoryID, req.Category)
+	if err != nil {
+		if checkoutErr, ok := err.(*checkoutError); ok {
+			c.JSON(checkoutErr.status, gin.H{"error": checkoutErr.message})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch category"})
+		return
+	}
+
 	product := &models.Product{
 		Name:        req.Name,
 		Description: req.Description,
 		Price:       req.Price,
-		Category:    req.Category,
+		Category:    category.Name,
+		CategoryID:  &category.ID,
 		Brand:       req.Brand,
 		SKU:         req.SKU,
 		Stock:       req.Stock,
@@ -107,6 +120,12 @@ func (h *ProductHandler) CreateProduct(c *gin.Context) {
 	})
 }
 
+// ProductDetail is a product with the options its variants are chosen by
+type ProductDetail struct {
+	models.Product
+	Options []ProductOption `json:"options"`
+}
+
 // GetProduct gets a single product by ID
 func (h *ProductHandler) GetProduct(c *gin.Context) {
 	productIDStr := c.Param("id")
@@ -117,7 +136,9 @@ func (h *ProductHandler) GetProduct(c *gin.Context) {
 	}
 
 	var product models.Product
-	if err := h.db.Preload("Images").First(&product, productID).Error; err != nil {
+	if err := h.db.Preload("Images").Preload("Variants", func(db *gorm.DB) *gorm.DB {
+		return db.Where("is_active = ?", true).Order("position ASC, id ASC")
+	}).Preload("Variants.OptionValues.OptionType").Preload("Variants.Images").First(&product, productID).Error; err != nil {
 		if err == gorm.ErrRecordNotFound {
 			c.JSON(http.StatusNotFound, gin.H{"error": "Product not found"})
 			return
@@ -126,7 +147,17 @@ func (h *ProductHandler) GetProduct(c *gin.Context) {
 		return
 	}
 
-	c.JSON(http.StatusOK, product)
+	// Show what is left once other shoppers' reservations are taken out
+	if available, err := availableStock(h.db, product, nil, 0); err == nil {
+		product.AvailableStock = &available
+	}
+	for i := range product.Variants {
+		if available, err := availableStock(h.db, product, &product.Variants[i], 0); err == nil {
+			product.Variants[i].AvailableStock = &available
+		}
+	}
+
+	c.JSON(http.StatusOK, ProductDetail{Product: product, Options: productOptions(product.Variants)})
 }
 
 func (h *ProductHandler) GetProducts(c *gin.Context) {
@@ -137,20 +168,32 @@ func (h *ProductHandler) GetProducts(c *gin.Context) {
 	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "20"))
 	category := c.Query("category")
 	search := c.Query("search")
-	sort := c.DefaultQuery("sort", "name")
+	sort := c.Query("sort")
 	status := c.DefaultQuery("status", "active")
 
 	offset := (page - 1) * limit
 
 	query := h.db.Model(&models.Product{}).Preload("Images")
 
+	// A category includes its subcategories
 	if category != "" {
-		query = query.Where("category = ?", category)
+		var err error
+		if query, err = filterByCategory(h.db, query, category, true); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch products"})
+			return
+		}
+	}
+
+	// Searches are ranked by relevance unless another order is asked for
+	if sort == "" {
+		sort = "name"
+		if search != "" {
+			sort = "relevance"
+		}
 	}
 
 	if search != "" {
-		query = query.Where("name ILIKE ? OR description ILIKE ? OR brand ILIKE ? OR tags ILIKE ?", 
-			"%"+search+"%", "%"+search+"%", "%"+search+"%", "%"+search+"%")
+		query = searchProducts(query, search, sort == "relevance")
 	}
 
 	if status != "" {
@@ -159,6 +202,11 @@ func (h *ProductHandler) GetProducts(c *gin.Context) {
 
 	// Add sorting
 	switch sort {
+	case "relevance":
+		// Ordered by searchProducts; without search words, by name
+		if productTSQuery(search) == "" {
+			query = query.Order("name ASC")
+		}
 	case "name":
 		query = query.Order("name ASC")
 	case "price_asc":
@@ -231,8 +279,22 @@ func (h *ProductHandler) UpdateProduct(c *gin.Context) {
 	if req.Price != nil {
 		product.Price = *req.Price
 	}
-	if req.Category != nil {
-		product.Category = *req.Category
+	if req.Category != nil || req.CategoryID != nil {
+		var name string
+		if req.Category != nil {
+			name = *req.Category
+		}
+		category, err := productCategory(h.db, req.CategoryID, name)
+		if err != nil {
+			if checkoutErr, ok := err.(*checkoutError); ok {
+				c.JSON(checkoutErr.status, gin.H{"error": checkoutErr.message})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch category"})
+			return
+		}
+		product.Category = category.Name
+		product.CategoryID = &category.ID
 	}
 	if req.Brand != nil {
 		product.Brand = *req.Brand
@@ -261,6 +323,12 @@ func (h *ProductHandler) UpdateProduct(c *gin.Context) {
 		return
 	}
 
+	// Products with variants keep the total of their variants' stock
+	if err := syncProductStock(h.db, product.ID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update product"})
+		return
+	}
+
 	// Load images for response
 	h.db.Preload("Images").First(&product, product.ID)
 
@@ -353,13 +421,20 @@ func (h *ProductHandler) DeleteProductImage(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"message": "Image deleted successfully"})
 }
 
+// GetCategories returns the active categories as a tree, each with the number of active
+// products in it and its subcategories
 func (h *ProductHandler) GetCategories(c *gin.Context) {
-	var categories []models.Category
-	
-	if err := h.db.Where("is_active = ?", true).Find(&categories).Error; err != nil {
+	categories, err := loadCategories(h.db, true)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch categories"})
+		return
+	}
+
+	counts, err := categoryProductCounts(h.db, true)
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch categories"})
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"categories": categories})
+	c.JSON(http.StatusOK, gin.H{"categories": categoryTree(categories, counts)})
 }
@@ -0,0 +1,183 @@
+package handlers
+
+import (
+	"log"
+	"time"
+
+	"github.com/yourname/sakifarm-ecommerce/middleware"
+	"github.com/yourname/sakifarm-ecommerce/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// reservedStock is how much of a product, or of one of its variants, other shoppers' carts
+// are holding. The cart given is left out so shoppers never compete with their own reservations.
+func reservedStock(db *gorm.DB, productID uint, variantID *uint, excludeCartID uint) (int, error) {
+	query := db.Model(&models.InventoryReservation{}).
+		Where("product_id = ? AND cart_id != ? AND expires_at > ?", productID, excludeCartID, time.Now())
+	if variantID != nil {
+		query = query.Where("variant_id = ?", *variantID)
+	}
+
+	var reserved int
+	err := query.Select("COALESCE(SUM(quantity), 0)").Scan(&reserved).Error
+	return reserved, err
+}
+
+// availableStock is the stock of a product, or of the variant given, less what other carts
+// are holding
+func availableStock(db *gorm.DB, product models.Product, variant *models.ProductVariant, excludeCartID uint) (int, error) {
+	stock := product.Stock
+	var variantID *uint
+	if variant != nil {
+		stock, variantID = variant.Stock, &variant.ID
+	}
+
+	reserved, err := reservedStock(db, product.ID, variantID, excludeCartID)
+	if err != nil {
+		return 0, err
+	}
+	if available := stock - reserved; available > 0 {
+		return available, nil
+	}
+	return 0, nil
+}
+
+// lockProductStock locks a product's row for the rest of the transaction and reloads its stock,
+// and the variant's if given. Carts reserving the product wait their turn, so checking the
+// available stock and reserving it act as one step.
+func lockProductStock(tx *gorm.DB, product *models.Product, variant *models.ProductVariant) error {
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(product, product.ID).Error; err != nil {
+		return err
+	}
+	if variant != nil {
+		return tx.First(variant, variant.ID).Error
+	}
+	return nil
+}
+
+// reserveCartItem holds stock for a cart line until the reservation expires. Lines beyond
+// the available stock, such as backorders, only hold what is there.
+func (h *CartHandler) reserveCartItem(db *gorm.DB, item models.CartItem, available int) error {
+	if h.reservationTTL <= 0 {
+		return nil
+	}
+
+	quantity := item.Quantity
+	if quantity > available {
+		quantity = available
+	}
+	if quantity <= 0 {
+		return db.Where("cart_item_id = ?", item.ID).Delete(&models.InventoryReservation{}).Error
+	}
+
+	reservation := models.InventoryReservation{
+		CartID:     item.CartID,
+		CartItemID: item.ID,
+		ProductID:  item.ProductID,
+		VariantID:  item.VariantID,
+		Quantity:   quantity,
+		ExpiresAt:  time.Now().Add(h.reservationTTL),
+	}
+	return db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "cart_item_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"quantity", "expires_at", "updated_at"}),
+	}).Create(&reservation).Error
+}
+
+// extendReservations keeps a cart's unexpired reservations alive while the shopper is active
+func (h *CartHandler) extendReservations(cartID uint) {
+	if h.reservationTTL <= 0 {
+		return
+	}
+
+	now := time.Now()
+	if err := h.db.Model(&models.InventoryReservation{}).
+		Where("cart_id = ? AND expires_at > ?", cartID, now).
+		Update("expires_at", now.Add(h.reservationTTL)).Error; err != nil {
+		log.Printf("Failed to extend reservations for cart %d: %v", cartID, err)
+	}
+}
+
+// releaseCartReservations drops all of a cart's reservations
+func releaseCartReservations(db *gorm.DB, cartID uint) error {
+	return db.Where("cart_id = ?", cartID).Delete(&models.InventoryReservation{}).Error
+}
+
+// releasePurchasedReservations lets go of what a cart was holding for items just bought. Only
+// the quantity bought is released; the rest of a line's reservation stays with the cart.
+func releasePurchasedReservations(tx *gorm.DB, cartID uint, items []models.OrderItem) error {
+	for _, item := range items {
+		query := tx.Model(&models.InventoryReservation{}).Where("cart_id = ? AND product_id = ?", cartID, item.ProductID)
+		if item.VariantID != nil {
+			query = query.Where("variant_id = ?", *item.VariantID)
+		} else {
+			query = query.Where("variant_id IS NULL")
+		}
+		if err := query.Update("quantity", gorm.Expr("quantity - ?", item.Quantity)).Error; err != nil {
+			return err
+		}
+	}
+	return tx.Where("cart_id = ? AND quantity <= 0", cartID).Delete(&models.InventoryReservation{}).Error
+}
+
+// StartReservationSweeper periodically deletes expired reservations. Expired rows are already
+// ignored when counting available stock; sweeping keeps the table small.
+func (h *CartHandler) StartReservationSweeper(interval time.Duration) {
+	if h.reservationTTL <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	go func() {
+		for range ticker.C {
+			h.sweepReservations()
+		}
+	}()
+}
+
+// StartGuestCartPurge periodically deletes guest carts left untouched for longer than their
+// token lasts, along with their items and reservations
+func (h *CartHandler) StartGuestCartPurge(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	go func() {
+		for range ticker.C {
+			h.purgeGuestCarts()
+		}
+	}()
+}
+
+func (h *CartHandler) purgeGuestCarts() {
+	cutoff := time.Now().Add(-middleware.GuestCartTTL)
+	var purged int64
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		stale := tx.Model(&models.Cart{}).Select("id").Where("user_id IS NULL AND updated_at <= ?", cutoff)
+		if err := tx.Where("cart_id IN (?)", stale).Delete(&models.InventoryReservation{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("cart_id IN (?)", stale).Delete(&models.CartItem{}).Error; err != nil {
+			return err
+		}
+		result := tx.Where("user_id IS NULL AND updated_at <= ?", cutoff).Delete(&models.Cart{})
+		purged = result.RowsAffected
+		return result.Error
+	})
+	if err != nil {
+		log.Printf("Failed to purge old guest carts: %v", err)
+		return
+	}
+	if purged > 0 {
+		log.Printf("Purged %d old guest carts", purged)
+	}
+}
+
+func (h *CartHandler) sweepReservations() {
+	result := h.db.Where("expires_at <= ?", time.Now()).Delete(&models.InventoryReservation{})
+	if result.Error != nil {
+		log.Printf("Failed to release expired reservations: %v", result.Error)
+		return
+	}
+	if result.RowsAffected > 0 {
+		log.Printf("Released %d expired stock reservations", result.RowsAffected)
+	}
+}
@@ -0,0 +1,574 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+	"github.com/google/uuid"
+	"github.com/yourname/sakifarm-ecommerce/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type ReturnHandler struct {
+	db               *gorm.DB
+	returnWindowDays int
+	validator        *validator.Validate
+}
+
+func NewReturnHandler(db *gorm.DB, returnWindowDays int) *ReturnHandler {
+	return &ReturnHandler{
+		db:               db,
+		returnWindowDays: returnWindowDays,
+		validator:        validator.New(),
+	}
+}
+
+type CreateReturnRequest struct {
+	Reason string              `json:"reason" validate:"required"`
+	Items  []ReturnItemRequest `json:"items" validate:"required,min=1,dive"`
+}
+
+type ReturnItemRequest struct {
+	OrderItemID uint     `json:"order_item_id" validate:"required"`
+	Quantity    int      `json:"quantity" validate:"required,min=1"`
+	Reason      string   `json:"reason"`
+	Photos      []string `json:"photos"`
+}
+
+type ReviewReturnRequest struct {
+	AdminNotes string `json:"admin_notes"`
+}
+
+type CompleteReturnRequest struct {
+	Restock    bool     `json:"restock"`
+	Resolution string   `json:"resolution" validate:"required,oneof=refund store_credit"`
+	Amount     *float64 `json:"amount,omitempty" validate:"omitempty,min=0"`
+	AdminNotes string   `json:"admin_notes"`
+}
+
+// CreateReturn lets a customer request a return for items of a delivered order
+func (h *ReturnHandler) CreateReturn(c *gin.Context) {
+	orderID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid order ID"})
+		return
+	}
+
+	userID, _ := c.Get("user_id")
+
+	var req CreateReturnRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := h.validator.Struct(req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var order models.Order
+	if err := h.db.Preload("Items.Product").Where("user_id = ?", userID).First(&order, orderID).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Order not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch order"})
+		return
+	}
+
+	if order.Status != "delivered" || order.DeliveredAt == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Only delivered orders can be returned"})
+		return
+	}
+
+	deadline := order.DeliveredAt.AddDate(0, 0, h.returnWindowDays)
+	if time.Now().After(deadline) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Return window of %d days has expired", h.returnWindowDays)})
+		return
+	}
+
+	orderItems := make(map[uint]models.OrderItem)
+	for _, item := range order.Items {
+		orderItems[item.ID] = item
+	}
+
+	returned, err := returnedQuantities(h.db, order.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch existing returns"})
+		return
+	}
+
+	requested := make(map[uint]int)
+	var items []models.ReturnItem
+	for _, itemReq := range req.Items {
+		orderItem, ok := orderItems[itemReq.OrderItemID]
+		if !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Order item %d does not belong to this order", itemReq.OrderItemID)})
+			return
+		}
+
+		requested[orderItem.ID] += itemReq.Quantity
+		if returned[orderItem.ID]+requested[orderItem.ID] > orderItem.Quantity {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Return quantity exceeds purchased quantity for %s", orderItem.Product.Name)})
+			return
+		}
+
+		reason := itemReq.Reason
+		if reason == "" {
+			reason = req.Reason
+		}
+
+		items = append(items, models.ReturnItem{
+			OrderItemID: orderItem.ID,
+			Quantity:    itemReq.Quantity,
+			Reason:      reason,
+			Photos:      strings.Join(itemReq.Photos, ","),
+		})
+	}
+
+	returnRequest := models.ReturnRequest{
+		RMANumber: fmt.Sprintf("RMA-%s", strings.ToUpper(uuid.New().String()[:8])),
+		OrderID:   order.ID,
+		UserID:    *order.UserID,
+		Status:    "requested",
+		Reason:    req.Reason,
+		Items:     items,
+	}
+
+	var quantityErr error
+	err = h.db.Transaction(func(tx *gorm.DB) error {
+		// Lock the order so concurrent requests cannot return the same items twice
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&models.Order{}, order.ID).Error; err != nil {
+			return err
+		}
+		returned, err := returnedQuantities(tx, order.ID)
+		if err != nil {
+			return err
+		}
+		for itemID, quantity := range requested {
+			if returned[itemID]+quantity > orderItems[itemID].Quantity {
+				quantityErr = fmt.Errorf("Return quantity exceeds purchased quantity for %s", orderItems[itemID].Product.Name)
+				return quantityErr
+			}
+		}
+
+		if err := tx.Create(&returnRequest).Error; err != nil {
+			return err
+		}
+		return refreshOrderReturnStatus(tx, order.ID)
+	})
+	if err != nil {
+		if quantityErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": quantityErr.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create return request"})
+		return
+	}
+
+	h.db.Preload("Items.OrderItem.Product").First(&returnRequest, returnRequest.ID)
+
+	c.JSON(http.StatusCreated, gin.H{
+		"message": "Return request submitted successfully",
+		"return":  returnRequest,
+	})
+}
+
+// GetReturns lists the current user's return requests, or all of them for admins
+func (h *ReturnHandler) GetReturns(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+	userRole := c.GetString("user_role")
+
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "20"))
+	status := c.Query("status")
+	offset := (page - 1) * limit
+
+	query := h.db.Model(&models.ReturnRequest{}).Preload("Items.OrderItem.Product").Preload("User")
+
+	if userRole != "admin" {
+		query = query.Where("user_id = ?", userID)
+	}
+
+	if orderID := c.Param("id"); orderID != "" {
+		query = query.Where("order_id = ?", orderID)
+	}
+
+	if status != "" {
+		query = query.Where("status = ?", status)
+	}
+
+	var total int64
+	query.Count(&total)
+
+	var returns []models.ReturnRequest
+	if err := query.Order("created_at DESC").Offset(offset).Limit(limit).Find(&returns).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch returns"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"returns": returns,
+		"pagination": gin.H{
+			"page":  page,
+			"limit": limit,
+			"total": total,
+			"pages": (total + int64(limit) - 1) / int64(limit),
+		},
+	})
+}
+
+// ApproveReturn accepts a pending return request (admin)
+func (h *ReturnHandler) ApproveReturn(c *gin.Context) {
+	h.reviewReturn(c, "approved")
+}
+
+// RejectReturn declines a pending return request (admin)
+func (h *ReturnHandler) RejectReturn(c *gin.Context) {
+	h.reviewReturn(c, "rejected")
+}
+
+func (h *ReturnHandler) reviewReturn(c *gin.Context, status string) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid return ID"})
+		return
+	}
+
+	// Notes are optional, so an empty body is accepted
+	var req ReviewReturnRequest
+	if err := c.ShouldBindJSON(&req); err != nil && err != io.EOF {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var returnRequest models.ReturnRequest
+	if err := h.db.First(&returnRequest, id).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Return request not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch return request"})
+		return
+	}
+
+	if returnRequest.Status != "requested" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Only pending return requests can be reviewed"})
+		return
+	}
+
+	returnRequest.Status = status
+	if req.AdminNotes != "" {
+		returnRequest.AdminNotes = req.AdminNotes
+	}
+	if status == "rejected" {
+		now := time.Now()
+		returnRequest.ResolvedAt = &now
+	}
+
+	var statusErr error
+	err = h.db.Transaction(func(tx *gorm.DB) error {
+		// Another admin may have reviewed the request in the meantime
+		var current models.ReturnRequest
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&current, returnRequest.ID).Error; err != nil {
+			return err
+		}
+		if current.Status != "requested" {
+			statusErr = errors.New("Only pending return requests can be reviewed")
+			return statusErr
+		}
+
+		if err := tx.Save(&returnRequest).Error; err != nil {
+			return err
+		}
+		return refreshOrderReturnStatus(tx, returnRequest.OrderID)
+	})
+	if err != nil {
+		if statusErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": statusErr.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update return request"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": fmt.Sprintf("Return request %s", status),
+		"return":  returnRequest,
+	})
+}
+
+// CompleteReturn records receipt of the goods, optionally restocks them and issues a
+// refund or store credit (admin)
+func (h *ReturnHandler) CompleteReturn(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid return ID"})
+		return
+	}
+
+	var req CompleteReturnRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := h.validator.Struct(req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var returnRequest models.ReturnRequest
+	if err := h.db.Preload("Items.OrderItem").First(&returnRequest, id).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Return request not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch return request"})
+		return
+	}
+
+	if returnRequest.Status != "approved" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Only approved return requests can be completed"})
+		return
+	}
+
+	var order models.Order
+	if err := h.db.First(&order, returnRequest.OrderID).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch order"})
+		return
+	}
+
+	// Default to what was paid for the returned items: their value less their share of the
+	// discount, plus their share of tax
+	var itemsTotal float64
+	for _, item := range returnRequest.Items {
+		itemsTotal += item.OrderItem.Price * float64(item.Quantity)
+	}
+	maxAmount := paidFor(order, itemsTotal)
+
+	amount := maxAmount
+	if req.Amount != nil {
+		if *req.Amount > maxAmount+0.005 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Amount cannot exceed KES %.2f", maxAmount)})
+			return
+		}
+		amount = *req.Amount
+	}
+
+	var statusErr error
+	err = h.db.Transaction(func(tx *gorm.DB) error {
+		// Lock the request so two admins completing it at once cannot both pay out
+		var current models.ReturnRequest
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&current, returnRequest.ID).Error; err != nil {
+			return err
+		}
+		if current.Status != "approved" {
+			statusErr = errors.New("Only approved return requests can be completed")
+			return statusErr
+		}
+
+		if req.Restock {
+			for _, item := range returnRequest.Items {
+				if err := returnStock(tx, item.OrderItem.ProductID, item.OrderItem.VariantID, item.Quantity); err != nil {
+					return err
+				}
+			}
+		}
+
+		switch req.Resolution {
+		case "refund":
+			payment := models.Payment{
+				OrderID:       order.ID,
+				PaymentMethod: order.PaymentMethod,
+				Amount:        -amount,
+				Currency:      "KES",
+				Status:        "refund_pending",
+				TransactionID: fmt.Sprintf("REFUND-%s", returnRequest.RMANumber),
+				PhoneNumber:   order.ShippingAddress.Phone,
+			}
+			if err := tx.Create(&payment).Error; err != nil {
+				return err
+			}
+			returnRequest.PaymentID = &payment.ID
+
+			var refunded float64
+			if err := tx.Model(&models.Payment{}).
+				Where("order_id = ? AND amount < 0", order.ID).
+				Select("COALESCE(SUM(-amount), 0)").Scan(&refunded).Error; err != nil {
+				return err
+			}
+			if refunded >= order.TotalAmount-0.005 {
+				if err := tx.Model(&order).Update("payment_status", "refunded").Error; err != nil {
+					return err
+				}
+			}
+		case "store_credit":
+			credit := models.StoreCredit{
+				UserID:      returnRequest.UserID,
+				Amount:      amount,
+				Source:      "return",
+				ReferenceID: returnRequest.ID,
+				Description: fmt.Sprintf("Store credit for return %s", returnRequest.RMANumber),
+			}
+			if err := tx.Create(&credit).Error; err != nil {
+				return err
+			}
+		}
+
+		now := time.Now()
+		returnRequest.Status = "completed"
+		returnRequest.Restocked = req.Restock
+		returnRequest.Resolution = req.Resolution
+		returnRequest.RefundAmount = amount
+		returnRequest.ResolvedAt = &now
+		if req.AdminNotes != "" {
+			returnRequest.AdminNotes = req.AdminNotes
+		}
+		if err := tx.Omit("Items").Save(&returnRequest).Error; err != nil {
+			return err
+		}
+
+		return refreshOrderReturnStatus(tx, order.ID)
+	})
+	if err != nil {
+		if statusErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": statusErr.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to complete return"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Return completed successfully",
+		"return":  returnRequest,
+	})
+}
+
+// UploadReturnPhoto stores a photo of a returned item and returns its URL
+func (h *ReturnHandler) UploadReturnPhoto(c *gin.Context) {
+	file, err := c.FormFile("image")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "No image file provided"})
+		return
+	}
+
+	contentType := file.Header.Get("Content-Type")
+	if contentType != "image/jpeg" && contentType != "image/png" && contentType != "image/webp" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Only JPEG, PNG, and WebP images are allowed"})
+		return
+	}
+
+	if file.Size > 5*1024*1024 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Image size must be less than 5MB"})
+		return
+	}
+
+	uploadsDir := filepath.Join("uploads", "returns")
+	if err := os.MkdirAll(uploadsDir, 0755); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create uploads directory"})
+		return
+	}
+
+	// Customer uploads get a random name rather than the client-supplied one
+	filename := fmt.Sprintf("%d_%s%s", time.Now().Unix(), uuid.New().String()[:8], filepath.Ext(file.Filename))
+	if err := c.SaveUploadedFile(file, filepath.Join(uploadsDir, filename)); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save image"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"imageUrl": fmt.Sprintf("/uploads/returns/%s", filename),
+		"message":  "Image uploaded successfully",
+	})
+}
+
+// GetStoreCredit returns the current user's store credit balance and history
+func (h *ReturnHandler) GetStoreCredit(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+
+	var entries []models.StoreCredit
+	if err := h.db.Where("user_id = ?", userID).Order("created_at DESC").Find(&entries).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch store credit"})
+		return
+	}
+
+	var balance float64
+	for _, entry := range entries {
+		balance += entry.Amount
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"balance": balance,
+		"history": entries,
+	})
+}
+
+// returnedQuantities sums quantities per order item across returns that are not rejected
+func returnedQuantities(db *gorm.DB, orderID uint) (map[uint]int, error) {
+	var rows []struct {
+		OrderItemID uint
+		Quantity    int
+	}
+	err := db.Model(&models.ReturnItem{}).
+		Select("return_items.order_item_id, SUM(return_items.quantity) as quantity").
+		Joins("JOIN return_requests ON return_requests.id = return_items.return_request_id").
+		Where("return_requests.order_id = ? AND return_requests.status != ?", orderID, "rejected").
+		Group("return_items.order_item_id").
+		Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+
+	quantities := make(map[uint]int)
+	for _, row := range rows {
+		quantities[row.OrderItemID] = row.Quantity
+	}
+	return quantities, nil
+}
+
+// refreshOrderReturnStatus derives Order.ReturnStatus from the order's return requests
+func refreshOrderReturnStatus(tx *gorm.DB, orderID uint) error {
+	var open int64
+	if err := tx.Model(&models.ReturnRequest{}).
+		Where("order_id = ? AND status IN ?", orderID, []string{"requested", "approved"}).
+		Count(&open).Error; err != nil {
+		return err
+	}
+
+	status := ""
+	if open > 0 {
+		status = "return_requested"
+	} else {
+		var ordered, returned int64
+		if err := tx.Model(&models.OrderItem{}).Where("order_id = ?", orderID).
+			Select("COALESCE(SUM(quantity), 0)").Scan(&ordered).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(&models.ReturnItem{}).
+			Joins("JOIN return_requests ON return_requests.id = return_items.return_request_id").
+			Where("return_requests.order_id = ? AND return_requests.status = ?", orderID, "completed").
+			Select("COALESCE(SUM(return_items.quantity), 0)").Scan(&returned).Error; err != nil {
+			return err
+		}
+		if returned > 0 && returned >= ordered {
+			status = "returned"
+		} else if returned > 0 {
+			status = "partially_returned"
+		}
+	}
+
+	return tx.Model(&models.Order{}).Where("id = ?", orderID).Update("return_status", status).Error
+}
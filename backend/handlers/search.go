@@ -0,0 +1,72 @@
+package handlers
+
+import (
+	"regexp"
+	"strings"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// searchWord picks the words out of a search, leaving out punctuation that would otherwise be
+// read as tsquery operators
+var searchWord = regexp.MustCompile(`[\p{L}\p{N}]+`)
+
+// maxSearchWords caps how many words of a search are matched
+const maxSearchWords = 10
+
+// EnsureProductSearch sets up full-text product search. Products get a search_vector column
+// weighting the name above the brand, tags and description, which Postgres recomputes whenever
+// a product changes, with a GIN index on it. A trigram index on names lets searches with small
+// typos still find products.
+func EnsureProductSearch(db *gorm.DB) error {
+	statements := []string{
+		"CREATE EXTENSION IF NOT EXISTS pg_trgm",
+		`ALTER TABLE products ADD COLUMN IF NOT EXISTS search_vector tsvector GENERATED ALWAYS AS (
+			setweight(to_tsvector('english', coalesce(name, '')), 'A') ||
+			setweight(to_tsvector('english', coalesce(brand, '')), 'B') ||
+			setweight(to_tsvector('english', coalesce(tags, '')), 'C') ||
+			setweight(to_tsvector('english', coalesce(description, '')), 'D')
+		) STORED`,
+		"CREATE INDEX IF NOT EXISTS idx_products_search_vector ON products USING GIN (search_vector)",
+		"CREATE INDEX IF NOT EXISTS idx_products_name_trgm ON products USING GIN (name gin_trgm_ops)",
+	}
+
+	for _, statement := range statements {
+		if err := db.Exec(statement).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// productTSQuery turns a search into a tsquery matching products that contain every word, or
+// a word starting with it, so "tom" finds tomatoes while the shopper is still typing
+func productTSQuery(search string) string {
+	words := searchWord.FindAllString(strings.ToLower(search), maxSearchWords)
+	for i, word := range words {
+		words[i] = word + ":*"
+	}
+	return strings.Join(words, " & ")
+}
+
+// searchProducts narrows a product query to products matching a search, by their words or by a
+// name close to the search so "tomatos" still finds tomatoes. With rank set the best matches
+// come first, names weighing most. A search without words leaves the query as it was.
+func searchProducts(query *gorm.DB, search string, rank bool) *gorm.DB {
+	tsQuery := productTSQuery(search)
+	if tsQuery == "" {
+		return query
+	}
+	search = strings.TrimSpace(search)
+
+	query = query.Where("(search_vector @@ to_tsquery('english', ?) OR ? <% name)", tsQuery, search)
+	if rank {
+		query = query.Clauses(clause.OrderBy{Expression: clause.Expr{
+			SQL:                "ts_rank(search_vector, to_tsquery('english', ?)) + word_similarity(?, name) DESC, name ASC",
+			Vars:               []interface{}{tsQuery, search},
+			WithoutParentheses: true,
+		}})
+	}
+	return query
+}
@@ -0,0 +1,26 @@
+package handlers
+
+import "testing"
+
+func TestProductTSQuery(t *testing.T) {
+	tests := []struct {
+		search string
+		want   string
+	}{
+		{"Tom", "tom:*"},
+		{"red onions", "red:* & onions:*"},
+		{"  sukuma-wiki & kale!", "sukuma:* & wiki:* & kale:*"},
+		{"maziwa 500ml", "maziwa:* & 500ml:*"},
+		{"Crème fraîche", "crème:* & fraîche:*"},
+		{"a b c d e f g h i j k l", "a:* & b:* & c:* & d:* & e:* & f:* & g:* & h:* & i:* & j:*"},
+		{"'); DROP TABLE products; --", "drop:* & table:* & products:*"},
+		{"", ""},
+		{"!!! ---", ""},
+	}
+
+	for _, tt := range tests {
+		if got := productTSQuery(tt.search); got != tt.want {
+			t.Errorf("productTSQuery(%q) = %q, want %q", tt.search, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,330 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+	"github.com/yourname/sakifarm-ecommerce/models"
+	"github.com/yourname/sakifarm-ecommerce/services"
+	"gorm.io/gorm"
+)
+
+type ShipmentHandler struct {
+	db           *gorm.DB
+	emailService *services.EmailService
+	carriers     map[string]services.Carrier
+	validator    *validator.Validate
+}
+
+func NewShipmentHandler(db *gorm.DB, emailService *services.EmailService, carriers map[string]services.Carrier) *ShipmentHandler {
+	return &ShipmentHandler{
+		db:           db,
+		emailService: emailService,
+		carriers:     carriers,
+		validator:    validator.New(),
+	}
+}
+
+type CreateShipmentRequest struct {
+	Carrier        string                `json:"carrier"`
+	TrackingNumber string                `json:"tracking_number"`
+	Items          []ShipmentItemRequest `json:"items" validate:"required,min=1,dive"`
+	Notes          string                `json:"notes"`
+}
+
+type ShipmentItemRequest struct {
+	OrderItemID uint `json:"order_item_id" validate:"required"`
+	Quantity    int  `json:"quantity" validate:"required,min=1"`
+}
+
+type UpdateShipmentStatusRequest struct {
+	Status         string `json:"status" validate:"required,oneof=shipped delivered"`
+	Carrier        string `json:"carrier,omitempty"`
+	TrackingNumber string `json:"tracking_number,omitempty"`
+}
+
+// CreateShipment packs some or all of an order's unshipped items into a new shipment (admin)
+func (h *ShipmentHandler) CreateShipment(c *gin.Context) {
+	orderID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid order ID"})
+		return
+	}
+
+	var req CreateShipmentRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := h.validator.Struct(req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var order models.Order
+	if err := h.db.Preload("Items.Product").First(&order, orderID).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Order not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch order"})
+		return
+	}
+
+	if order.Status == "cancelled" || order.Status == "delivered" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Cannot ship a %s order", order.Status)})
+		return
+	}
+
+	orderItems := make(map[uint]models.OrderItem)
+	for _, item := range order.Items {
+		orderItems[item.ID] = item
+	}
+
+	packed, err := shippedQuantities(h.db, order.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch existing shipments"})
+		return
+	}
+
+	var items []models.ShipmentItem
+	for _, itemReq := range req.Items {
+		orderItem, ok := orderItems[itemReq.OrderItemID]
+		if !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Order item %d does not belong to this order", itemReq.OrderItemID)})
+			return
+		}
+		if isWaitingItem(orderItem) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("%s is still waiting for stock", orderItem.Product.Name)})
+			return
+		}
+
+		packed[orderItem.ID] += itemReq.Quantity
+		if packed[orderItem.ID] > orderItem.Quantity {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Shipment quantity exceeds remaining quantity for %s", orderItem.Product.Name)})
+			return
+		}
+
+		items = append(items, models.ShipmentItem{
+			OrderItemID: orderItem.ID,
+			Quantity:    itemReq.Quantity,
+		})
+	}
+
+	shipment := models.Shipment{
+		OrderID:        order.ID,
+		Carrier:        req.Carrier,
+		TrackingNumber: req.TrackingNumber,
+		Status:         "pending",
+		Items:          items,
+		Notes:          req.Notes,
+	}
+
+	if err := h.db.Create(&shipment).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create shipment"})
+		return
+	}
+
+	h.db.Preload("Items.OrderItem.Product").First(&shipment, shipment.ID)
+
+	c.JSON(http.StatusCreated, gin.H{
+		"message":  "Shipment created successfully",
+		"shipment": shipment,
+	})
+}
+
+// GetShipments lists the shipments of an order
+func (h *ShipmentHandler) GetShipments(c *gin.Context) {
+	orderID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid order ID"})
+		return
+	}
+
+	userID, _ := c.Get("user_id")
+	userRole := c.GetString("user_role")
+
+	var order models.Order
+	query := h.db.Preload("Shipments.Items.OrderItem.Product").Preload("Shipments.TrackingEvents")
+
+	// If not admin, only allow access to own orders
+	if userRole != "admin" {
+		query = query.Where("user_id = ?", userID)
+	}
+
+	if err := query.First(&order, orderID).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Order not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch order"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"shipments": order.Shipments})
+}
+
+// UpdateShipmentStatus marks a shipment as shipped or delivered and re-derives the order status (admin)
+func (h *ShipmentHandler) UpdateShipmentStatus(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid shipment ID"})
+		return
+	}
+
+	var req UpdateShipmentStatusRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := h.validator.Struct(req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var shipment models.Shipment
+	if err := h.db.First(&shipment, id).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Shipment not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch shipment"})
+		return
+	}
+
+	if shipment.Status == "delivered" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Shipment has already been delivered"})
+		return
+	}
+
+	if req.Carrier != "" {
+		shipment.Carrier = req.Carrier
+	}
+	if req.TrackingNumber != "" {
+		shipment.TrackingNumber = req.TrackingNumber
+	}
+
+	now := time.Now()
+	shipment.Status = req.Status
+	if shipment.ShippedAt == nil {
+		shipment.ShippedAt = &now
+	}
+	if req.Status == "delivered" {
+		shipment.DeliveredAt = &now
+	}
+
+	var order models.Order
+	err = h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Omit("Items").Save(&shipment).Error; err != nil {
+			return err
+		}
+		if err := refreshOrderFulfilmentStatus(tx, shipment.OrderID); err != nil {
+			return err
+		}
+		return tx.Preload("User").First(&order, shipment.OrderID).Error
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update shipment"})
+		return
+	}
+
+	// Notify the customer once the last shipment arrives
+	if order.Status == "delivered" && req.Status == "delivered" && h.emailService != nil {
+		go h.emailService.SendDeliveryNotification(order.User.Email, order.OrderNumber, shipment.TrackingNumber)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":      "Shipment status updated successfully",
+		"shipment":     shipment,
+		"order_status": order.Status,
+	})
+}
+
+// shippedQuantities sums quantities per order item across all of the order's shipments
+func shippedQuantities(db *gorm.DB, orderID uint) (map[uint]int, error) {
+	var rows []struct {
+		OrderItemID uint
+		Quantity    int
+	}
+	err := db.Model(&models.ShipmentItem{}).
+		Select("shipment_items.order_item_id, SUM(shipment_items.quantity) as quantity").
+		Joins("JOIN shipments ON shipments.id = shipment_items.shipment_id").
+		Where("shipments.order_id = ?", orderID).
+		Group("shipment_items.order_item_id").
+		Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+
+	quantities := make(map[uint]int)
+	for _, row := range rows {
+		quantities[row.OrderItemID] = row.Quantity
+	}
+	return quantities, nil
+}
+
+// refreshOrderFulfilmentStatus derives the order status from its shipments: delivered once
+// every unit has been delivered, shipped once every unit is on its way, partially_shipped
+// while only some are. Orders with nothing dispatched keep their current status.
+func refreshOrderFulfilmentStatus(tx *gorm.DB, orderID uint) error {
+	var order models.Order
+	if err := tx.Preload("Items").Preload("Shipments.Items").First(&order, orderID).Error; err != nil {
+		return err
+	}
+
+	dispatched := make(map[uint]int)
+	delivered := make(map[uint]int)
+	var lastDelivery *time.Time
+	for _, shipment := range order.Shipments {
+		if shipment.Status == "pending" {
+			continue
+		}
+		for _, item := range shipment.Items {
+			dispatched[item.OrderItemID] += item.Quantity
+			if shipment.Status == "delivered" {
+				delivered[item.OrderItemID] += item.Quantity
+			}
+		}
+		if shipment.DeliveredAt != nil && (lastDelivery == nil || shipment.DeliveredAt.After(*lastDelivery)) {
+			lastDelivery = shipment.DeliveredAt
+		}
+		if order.TrackingNumber == "" && shipment.TrackingNumber != "" {
+			order.TrackingNumber = shipment.TrackingNumber
+		}
+	}
+
+	if len(dispatched) == 0 {
+		return nil
+	}
+
+	allDispatched, allDelivered := true, true
+	for _, item := range order.Items {
+		if dispatched[item.ID] < item.Quantity {
+			allDispatched = false
+		}
+		if delivered[item.ID] < item.Quantity {
+			allDelivered = false
+		}
+	}
+
+	updates := map[string]interface{}{"tracking_number": order.TrackingNumber}
+	switch {
+	case allDelivered:
+		updates["status"] = "delivered"
+		if order.DeliveredAt == nil {
+			updates["delivered_at"] = lastDelivery
+		}
+	case allDispatched:
+		updates["status"] = "shipped"
+	default:
+		updates["status"] = "partially_shipped"
+	}
+
+	return tx.Model(&models.Order{}).Where("id = ?", orderID).Updates(updates).Error
+}
@@ -0,0 +1,461 @@
+package handlers
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+	"github.com/yourname/sakifarm-ecommerce/models"
+	"github.com/yourname/sakifarm-ecommerce/services"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type SubscriptionHandler struct {
+	db                   *gorm.DB
+	paymentService       *services.PaymentService
+	orderNumbers         *services.OrderNumberService
+	maxPaymentAttempts   int
+	paymentRetryInterval time.Duration
+	validator            *validator.Validate
+}
+
+func NewSubscriptionHandler(db *gorm.DB, paymentService *services.PaymentService, orderNumbers *services.OrderNumberService, maxPaymentAttempts int, paymentRetryInterval time.Duration) *SubscriptionHandler {
+	return &SubscriptionHandler{
+		db:                   db,
+		paymentService:       paymentService,
+		orderNumbers:         orderNumbers,
+		maxPaymentAttempts:   maxPaymentAttempts,
+		paymentRetryInterval: paymentRetryInterval,
+		validator:            validator.New(),
+	}
+}
+
+type CreateSubscriptionRequest struct {
+	Items           []OrderItemRequest `json:"items" validate:"required,min=1,dive"`
+	Frequency       string             `json:"frequency" validate:"required,oneof=weekly biweekly monthly"`
+	StartDate       string             `json:"start_date" validate:"omitempty,datetime=2006-01-02"`
+	ShippingAddress models.Address     `json:"shipping_address" validate:"required"`
+	BillingAddress  models.Address     `json:"billing_address" validate:"required"`
+	PaymentMethod   string             `json:"payment_method" validate:"required,oneof=mpesa airtel"`
+	PhoneNumber     string             `json:"phone_number" validate:"required"`
+}
+
+// CreateSubscription sets up a recurring order for the current user
+func (h *SubscriptionHandler) CreateSubscription(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+
+	var req CreateSubscriptionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := h.validator.Struct(req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	nextRun := time.Now()
+	if req.StartDate != "" {
+		nextRun, _ = time.ParseInLocation("2006-01-02", req.StartDate, time.Local)
+		if nextRun.Before(time.Now().Truncate(24 * time.Hour)) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Start date cannot be in the past"})
+			return
+		}
+	}
+
+	var items []models.SubscriptionItem
+	for _, item := range req.Items {
+		var product models.Product
+		if err := h.db.First(&product, item.ProductID).Error; err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Product %d not found", item.ProductID)})
+			return
+		}
+		if _, err := findOrderVariant(h.db, product, item.VariantID); err != nil {
+			if checkoutErr, ok := err.(*checkoutError); ok {
+				c.JSON(checkoutErr.status, gin.H{"error": checkoutErr.message})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+			return
+		}
+		items = append(items, models.SubscriptionItem{
+			ProductID: item.ProductID,
+			VariantID: item.VariantID,
+			Quantity:  item.Quantity,
+		})
+	}
+
+	subscription := models.Subscription{
+		UserID:          userID.(uint),
+		Status:          "active",
+		Frequency:       req.Frequency,
+		NextRunDate:     nextRun,
+		Items:           items,
+		ShippingAddress: req.ShippingAddress,
+		BillingAddress:  req.BillingAddress,
+		PaymentMethod:   req.PaymentMethod,
+		PhoneNumber:     req.PhoneNumber,
+	}
+
+	if err := h.db.Omit("User").Create(&subscription).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create subscription"})
+		return
+	}
+
+	h.db.Preload("Items.Product").First(&subscription, subscription.ID)
+
+	c.JSON(http.StatusCreated, gin.H{
+		"message":      "Subscription created successfully",
+		"subscription": subscription,
+	})
+}
+
+// GetSubscriptions lists the current user's subscriptions, or all of them for admins
+func (h *SubscriptionHandler) GetSubscriptions(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+	userRole := c.GetString("user_role")
+
+	query := h.db.Preload("Items.Product")
+	if userRole != "admin" {
+		query = query.Where("user_id = ?", userID)
+	}
+	if status := c.Query("status"); status != "" {
+		query = query.Where("status = ?", status)
+	}
+
+	var subscriptions []models.Subscription
+	if err := query.Order("created_at DESC").Find(&subscriptions).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch subscriptions"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"subscriptions": subscriptions})
+}
+
+// GetSubscription returns a single subscription
+func (h *SubscriptionHandler) GetSubscription(c *gin.Context) {
+	subscription, ok := h.findSubscription(c)
+	if !ok {
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"subscription": subscription})
+}
+
+// PauseSubscription stops new cycles until resumed
+func (h *SubscriptionHandler) PauseSubscription(c *gin.Context) {
+	subscription, ok := h.findSubscription(c)
+	if !ok {
+		return
+	}
+
+	if subscription.Status != "active" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Only active subscriptions can be paused"})
+		return
+	}
+
+	h.updateStatus(c, subscription, map[string]interface{}{"status": "paused"}, "Subscription paused")
+}
+
+// ResumeSubscription restarts a paused or past-due subscription from the next due date
+func (h *SubscriptionHandler) ResumeSubscription(c *gin.Context) {
+	subscription, ok := h.findSubscription(c)
+	if !ok {
+		return
+	}
+
+	if subscription.Status != "paused" && subscription.Status != "past_due" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Only paused or past due subscriptions can be resumed"})
+		return
+	}
+
+	// Missed cycles are not delivered retroactively
+	nextRun := subscription.NextRunDate
+	for nextRun.Before(time.Now()) {
+		nextRun = advanceSubscriptionDate(nextRun, subscription.Frequency)
+	}
+
+	h.updateStatus(c, subscription, map[string]interface{}{
+		"status":          "active",
+		"next_run_date":   nextRun,
+		"failed_attempts": 0,
+		"next_retry_at":   nil,
+	}, "Subscription resumed")
+}
+
+// SkipSubscription skips the next upcoming cycle
+func (h *SubscriptionHandler) SkipSubscription(c *gin.Context) {
+	subscription, ok := h.findSubscription(c)
+	if !ok {
+		return
+	}
+
+	if subscription.Status != "active" && subscription.Status != "paused" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Subscription cannot be skipped"})
+		return
+	}
+
+	h.updateStatus(c, subscription, map[string]interface{}{
+		"next_run_date": advanceSubscriptionDate(subscription.NextRunDate, subscription.Frequency),
+	}, "Next delivery skipped")
+}
+
+// CancelSubscription ends the subscription; orders already placed are not affected
+func (h *SubscriptionHandler) CancelSubscription(c *gin.Context) {
+	subscription, ok := h.findSubscription(c)
+	if !ok {
+		return
+	}
+
+	if subscription.Status == "cancelled" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Subscription is already cancelled"})
+		return
+	}
+
+	h.updateStatus(c, subscription, map[string]interface{}{"status": "cancelled"}, "Subscription cancelled")
+}
+
+func (h *SubscriptionHandler) findSubscription(c *gin.Context) (*models.Subscription, bool) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid subscription ID"})
+		return nil, false
+	}
+
+	userID, _ := c.Get("user_id")
+	userRole := c.GetString("user_role")
+
+	query := h.db.Preload("Items.Product")
+	if userRole != "admin" {
+		query = query.Where("user_id = ?", userID)
+	}
+
+	var subscription models.Subscription
+	if err := query.First(&subscription, id).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Subscription not found"})
+			return nil, false
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch subscription"})
+		return nil, false
+	}
+
+	return &subscription, true
+}
+
+func (h *SubscriptionHandler) updateStatus(c *gin.Context, subscription *models.Subscription, updates map[string]interface{}, message string) {
+	if err := h.db.Model(subscription).Updates(updates).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update subscription"})
+		return
+	}
+
+	h.db.Preload("Items.Product").First(subscription, subscription.ID)
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":      message,
+		"subscription": subscription,
+	})
+}
+
+// StartScheduler processes subscriptions every interval until the process exits
+func (h *SubscriptionHandler) StartScheduler(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			h.ProcessSubscriptions()
+			<-ticker.C
+		}
+	}()
+}
+
+// ProcessSubscriptions follows up on unpaid cycles and places orders for subscriptions that are due
+func (h *SubscriptionHandler) ProcessSubscriptions() {
+	var retries []models.Subscription
+	h.db.Where("status = ? AND pending_order_id IS NOT NULL AND next_retry_at <= ?", "active", time.Now()).Find(&retries)
+	for _, subscription := range retries {
+		if err := h.followUpPayment(subscription.ID); err != nil {
+			log.Printf("Subscription %d: payment follow-up failed: %v", subscription.ID, err)
+		}
+	}
+
+	var due []models.Subscription
+	h.db.Where("status = ? AND pending_order_id IS NULL AND next_run_date <= ?", "active", time.Now()).Find(&due)
+	for _, subscription := range due {
+		if err := h.runCycle(subscription.ID); err != nil {
+			log.Printf("Subscription %d: failed to place order: %v", subscription.ID, err)
+		}
+	}
+}
+
+// runCycle turns one due subscription into an order and requests payment for it
+func (h *SubscriptionHandler) runCycle(subscriptionID uint) error {
+	var subscription models.Subscription
+	var order *models.Order
+	var skipped string
+
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		// Skip rows another worker is already processing
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).Preload("Items").
+			Where("status = ? AND pending_order_id IS NULL AND next_run_date <= ?", "active", time.Now()).
+			First(&subscription, subscriptionID).Error; err != nil {
+			if err == gorm.ErrRecordNotFound {
+				return nil
+			}
+			return err
+		}
+
+		var items []OrderItemRequest
+		for _, item := range subscription.Items {
+			items = append(items, OrderItemRequest{ProductID: item.ProductID, VariantID: item.VariantID, Quantity: item.Quantity})
+		}
+
+		userID := subscription.UserID
+		var err error
+		order, err = createOrder(tx, h.orderNumbers, orderInput{
+			UserID:          &userID,
+			Items:           items,
+			ShippingAddress: subscription.ShippingAddress,
+			BillingAddress:  subscription.BillingAddress,
+			PaymentMethod:   subscription.PaymentMethod,
+			Notes:           fmt.Sprintf("Subscription #%d", subscription.ID),
+		})
+
+		now := time.Now()
+		updates := map[string]interface{}{
+			"next_run_date": advanceSubscriptionDate(subscription.NextRunDate, subscription.Frequency),
+			"last_run_at":   now,
+		}
+		if err != nil {
+			checkoutErr, ok := err.(*checkoutError)
+			if !ok {
+				return err
+			}
+			// The cycle is skipped, e.g. when a product is out of stock
+			skipped = checkoutErr.message
+		} else {
+			retryAt := now.Add(h.paymentRetryInterval)
+			updates["pending_order_id"] = order.ID
+			updates["failed_attempts"] = 0
+			updates["next_retry_at"] = retryAt
+		}
+
+		return tx.Model(&subscription).Updates(updates).Error
+	})
+	if err != nil || subscription.ID == 0 {
+		return err
+	}
+
+	if skipped != "" {
+		h.notify(subscription.UserID, "Subscription delivery skipped",
+			fmt.Sprintf("We could not place the order for subscription #%d this cycle: %s", subscription.ID, skipped))
+		return nil
+	}
+
+	if _, err := initiateOrderPayment(h.paymentService, order.ID, subscription.PaymentMethod, subscription.PhoneNumber, order.TotalAmount, "subscription"); err != nil {
+		h.db.Model(&subscription).Update("failed_attempts", 1)
+		h.notify(subscription.UserID, "Subscription payment failed",
+			fmt.Sprintf("We could not request payment for order %s. We will try again shortly.", order.OrderNumber))
+		return nil
+	}
+
+	h.notify(subscription.UserID, "Subscription order placed",
+		fmt.Sprintf("Your order %s has been placed. Please approve the payment request on your phone.", order.OrderNumber))
+	return nil
+}
+
+// followUpPayment clears a paid cycle, retries the payment request for an unpaid one, and
+// once the attempts are used up cancels the order and marks the subscription past due
+func (h *SubscriptionHandler) followUpPayment(subscriptionID uint) error {
+	var subscription models.Subscription
+	if err := h.db.First(&subscription, subscriptionID).Error; err != nil {
+		return err
+	}
+	if subscription.PendingOrderID == nil {
+		return nil
+	}
+
+	var order models.Order
+	if err := h.db.Preload("Items").First(&order, *subscription.PendingOrderID).Error; err != nil {
+		return err
+	}
+
+	if order.PaymentStatus == "paid" || order.Status == "cancelled" {
+		return h.db.Model(&subscription).Updates(map[string]interface{}{
+			"pending_order_id": nil,
+			"failed_attempts":  0,
+			"next_retry_at":    nil,
+		}).Error
+	}
+
+	if subscription.FailedAttempts+1 >= h.maxPaymentAttempts {
+		err := h.db.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Model(&order).Update("status", "cancelled").Error; err != nil {
+				return err
+			}
+			if err := cancelVendorOrders(tx, order.ID); err != nil {
+				return err
+			}
+			if err := releaseOrderItems(tx, order.Items); err != nil {
+				return err
+			}
+			if err := tx.Model(&subscription).Updates(map[string]interface{}{
+				"pending_order_id": nil,
+				"next_retry_at":    nil,
+			}).Error; err != nil {
+				return err
+			}
+			// A subscription paused or cancelled in the meantime keeps its status
+			return tx.Model(&subscription).Where("status = ?", "active").Update("status", "past_due").Error
+		})
+		if err != nil {
+			return err
+		}
+
+		h.notify(subscription.UserID, "Subscription paused: payment not received",
+			fmt.Sprintf("Order %s was cancelled because payment was not received. Resume your subscription once your payment method is ready.", order.OrderNumber))
+		return nil
+	}
+
+	retryAt := time.Now().Add(h.paymentRetryInterval)
+	h.db.Model(&subscription).Updates(map[string]interface{}{
+		"failed_attempts": subscription.FailedAttempts + 1,
+		"next_retry_at":   retryAt,
+	})
+
+	if _, err := initiateOrderPayment(h.paymentService, order.ID, subscription.PaymentMethod, subscription.PhoneNumber, order.TotalAmount, "subscription"); err != nil {
+		return err
+	}
+
+	h.notify(subscription.UserID, "Subscription payment reminder",
+		fmt.Sprintf("We have sent another payment request for order %s.", order.OrderNumber))
+	return nil
+}
+
+func (h *SubscriptionHandler) notify(userID uint, title, message string) {
+	h.db.Create(&models.Notification{
+		UserID:  userID,
+		Title:   title,
+		Message: message,
+		Type:    "order",
+	})
+}
+
+// advanceSubscriptionDate returns the run date following date for the given frequency
+func advanceSubscriptionDate(date time.Time, frequency string) time.Time {
+	switch frequency {
+	case "biweekly":
+		return date.AddDate(0, 0, 14)
+	case "monthly":
+		return date.AddDate(0, 1, 0)
+	default:
+		return date.AddDate(0, 0, 7)
+	}
+}
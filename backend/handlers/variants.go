@@ -0,0 +1,678 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/yourname/sakifarm-ecommerce/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type OptionTypeRequest struct {
+	Name   string   `json:"name" binding:"required"`
+	Values []string `json:"values"`
+}
+
+type OptionValueRequest struct {
+	Value    string `json:"value" binding:"required"`
+	Position int    `json:"position"`
+}
+
+type VariantRequest struct {
+	SKU            string   `json:"sku" binding:"required"`
+	Price          float64  `json:"price" binding:"required,gt=0"`
+	Stock          int      `json:"stock" binding:"min=0"`
+	OptionValueIDs []uint   `json:"option_value_ids" binding:"required,min=1"`
+	Images         []string `json:"images"`
+	IsActive       *bool    `json:"is_active"`
+	Position       int      `json:"position"`
+}
+
+// ProductOption is an option type offered by a product, with the values its variants use
+type ProductOption struct {
+	ID     uint                 `json:"id"`
+	Name   string               `json:"name"`
+	Values []models.OptionValue `json:"values"`
+}
+
+// findOrderVariant resolves the variant an item asks for. Products with variants are only sold
+// by variant; products without them take no variant. Problems are returned as a *checkoutError.
+func findOrderVariant(db *gorm.DB, product models.Product, variantID *uint) (*models.ProductVariant, error) {
+	if variantID == nil {
+		var variants int64
+		if err := db.Model(&models.ProductVariant{}).Where("product_id = ?", product.ID).Count(&variants).Error; err != nil {
+			return nil, err
+		}
+		if variants > 0 {
+			return nil, &checkoutError{http.StatusBadRequest, fmt.Sprintf("Choose an option for %s", product.Name)}
+		}
+		return nil, nil
+	}
+
+	var variant models.ProductVariant
+	if err := db.Where("product_id = ?", product.ID).First(&variant, *variantID).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, &checkoutError{http.StatusBadRequest, fmt.Sprintf("Option %d of %s not found", *variantID, product.Name)}
+		}
+		return nil, err
+	}
+	if !variant.IsActive {
+		return nil, &checkoutError{http.StatusBadRequest, fmt.Sprintf("%s is not available", itemName(product, &variant))}
+	}
+	return &variant, nil
+}
+
+// acceptsItemBackorder is acceptsBackorder for a cart or order line. Backorders and pre-orders
+// are counted per product, so variant lines are never sold beyond their stock.
+func acceptsItemBackorder(product models.Product, variant *models.ProductVariant, quantity int) bool {
+	return variant == nil && acceptsBackorder(product, quantity)
+}
+
+// orderLineKey identifies an order line by product and variant, a zero VariantID meaning none
+type orderLineKey struct {
+	ProductID uint
+	VariantID uint
+}
+
+func lineKey(productID uint, variantID *uint) orderLineKey {
+	key := orderLineKey{ProductID: productID}
+	if variantID != nil {
+		key.VariantID = *variantID
+	}
+	return key
+}
+
+// cartLine narrows a query to a cart's line for a product and variant
+func cartLine(db *gorm.DB, cartID, productID uint, variantID *uint) *gorm.DB {
+	if variantID == nil {
+		return db.Where("cart_id = ? AND product_id = ? AND variant_id IS NULL", cartID, productID)
+	}
+	return db.Where("cart_id = ? AND product_id = ? AND variant_id = ?", cartID, productID, *variantID)
+}
+
+// itemPrice is what one unit of a product, or of one of its variants, sells for
+func itemPrice(product models.Product, variant *models.ProductVariant) float64 {
+	if variant != nil {
+		return variant.Price
+	}
+	return product.Price
+}
+
+// itemName names a product and, if given, its variant, e.g. "Tomatoes (5kg)"
+func itemName(product models.Product, variant *models.ProductVariant) string {
+	if variant != nil && variant.Name != "" {
+		return fmt.Sprintf("%s (%s)", product.Name, variant.Name)
+	}
+	return product.Name
+}
+
+// variantName lists option values in option type order, e.g. "5kg / Crate"
+func variantName(values []models.OptionValue) string {
+	sorted := append([]models.OptionValue(nil), values...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].OptionTypeID < sorted[j].OptionTypeID })
+
+	names := make([]string, 0, len(sorted))
+	for _, value := range sorted {
+		names = append(names, value.Value)
+	}
+	return strings.Join(names, " / ")
+}
+
+// takeStock deducts stock for an order line, failing when there is not enough. Variant lines
+// take the variant's stock, and the product's total follows.
+func takeStock(tx *gorm.DB, productID uint, variantID *uint, quantity int) (bool, error) {
+	if variantID == nil {
+		result := tx.Model(&models.Product{}).
+			Where("id = ? AND stock >= ?", productID, quantity).
+			Update("stock", gorm.Expr("stock - ?", quantity))
+		return result.RowsAffected > 0, result.Error
+	}
+
+	if err := lockProductRow(tx, productID); err != nil {
+		return false, err
+	}
+	result := tx.Model(&models.ProductVariant{}).
+		Where("id = ? AND stock >= ?", *variantID, quantity).
+		Update("stock", gorm.Expr("stock - ?", quantity))
+	if result.Error != nil || result.RowsAffected == 0 {
+		return false, result.Error
+	}
+	return true, syncProductStock(tx, productID)
+}
+
+// returnStock puts stock taken by takeStock back, even for variants deleted since
+func returnStock(tx *gorm.DB, productID uint, variantID *uint, quantity int) error {
+	if variantID == nil {
+		return tx.Model(&models.Product{}).Where("id = ?", productID).
+			Update("stock", gorm.Expr("stock + ?", quantity)).Error
+	}
+
+	if err := lockProductRow(tx, productID); err != nil {
+		return err
+	}
+	if err := tx.Unscoped().Model(&models.ProductVariant{}).Where("id = ?", *variantID).
+		Update("stock", gorm.Expr("stock + ?", quantity)).Error; err != nil {
+		return err
+	}
+	return syncProductStock(tx, productID)
+}
+
+// lockProductRow takes the product's row lock, deleted products included. Variant stock
+// changes take it before touching the variant so the product total is summed by one
+// transaction at a time.
+func lockProductRow(tx *gorm.DB, productID uint) error {
+	var product models.Product
+	err := tx.Unscoped().Clauses(clause.Locking{Strength: "UPDATE"}).Select("id").First(&product, productID).Error
+	if err == gorm.ErrRecordNotFound {
+		return nil
+	}
+	return err
+}
+
+// syncProductStock sets a product's stock to the total of its variants' stock. Products
+// without variants keep their own stock. The product row is locked first, so the total is
+// read after any other transaction changing the same product's variants has committed.
+func syncProductStock(db *gorm.DB, productID uint) error {
+	var variants int64
+	if err := db.Model(&models.ProductVariant{}).Where("product_id = ?", productID).Count(&variants).Error; err != nil {
+		return err
+	}
+	if variants == 0 {
+		return nil
+	}
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := lockProductRow(tx, productID); err != nil {
+			return err
+		}
+		return tx.Model(&models.Product{}).Where("id = ?", productID).
+			Update("stock", gorm.Expr("(SELECT COALESCE(SUM(stock), 0) FROM product_variants WHERE product_id = ? AND deleted_at IS NULL)", productID)).Error
+	})
+}
+
+// productOptions lists the option types a product's variants use, with the values in use
+func productOptions(variants []models.ProductVariant) []ProductOption {
+	options := []ProductOption{}
+	index := map[uint]int{}
+	seen := map[uint]bool{}
+
+	for _, variant := range variants {
+		for _, value := range variant.OptionValues {
+			if seen[value.ID] {
+				continue
+			}
+			seen[value.ID] = true
+
+			i, ok := index[value.OptionTypeID]
+			if !ok {
+				option := ProductOption{ID: value.OptionTypeID, Values: []models.OptionValue{}}
+				if value.OptionType != nil {
+					option.Name = value.OptionType.Name
+				}
+				options = append(options, option)
+				i = len(options) - 1
+				index[value.OptionTypeID] = i
+			}
+			withoutType := value
+			withoutType.OptionType = nil
+			options[i].Values = append(options[i].Values, withoutType)
+		}
+	}
+
+	for _, option := range options {
+		sort.Slice(option.Values, func(i, j int) bool { return option.Values[i].Position < option.Values[j].Position })
+	}
+	sort.Slice(options, func(i, j int) bool { return options[i].ID < options[j].ID })
+	return options
+}
+
+// GetOptionTypes lists option types with their values
+func (h *AdminProductHandler) GetOptionTypes(c *gin.Context) {
+	var optionTypes []models.OptionType
+	if err := h.db.Preload("Values", func(db *gorm.DB) *gorm.DB {
+		return db.Order("position ASC, id ASC")
+	}).Order("name ASC").Find(&optionTypes).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch option types"})
+		return
+	}
+
+	c.JSON(http.StatusOK, optionTypes)
+}
+
+// CreateOptionType creates an option type, optionally with its values
+func (h *AdminProductHandler) CreateOptionType(c *gin.Context) {
+	var req OptionTypeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var existing int64
+	h.db.Model(&models.OptionType{}).Where("LOWER(name) = LOWER(?)", strings.TrimSpace(req.Name)).Count(&existing)
+	if existing > 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "Option type with this name already exists"})
+		return
+	}
+
+	optionType := models.OptionType{Name: strings.TrimSpace(req.Name)}
+	for i, value := range req.Values {
+		if value = strings.TrimSpace(value); value != "" {
+			optionType.Values = append(optionType.Values, models.OptionValue{Value: value, Position: i})
+		}
+	}
+
+	if err := h.db.Create(&optionType).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create option type"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, optionType)
+}
+
+// UpdateOptionType renames an option type
+func (h *AdminProductHandler) UpdateOptionType(c *gin.Context) {
+	optionType, ok := h.findOptionType(c)
+	if !ok {
+		return
+	}
+
+	var req OptionTypeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var existing int64
+	h.db.Model(&models.OptionType{}).Where("LOWER(name) = LOWER(?) AND id != ?", strings.TrimSpace(req.Name), optionType.ID).Count(&existing)
+	if existing > 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "Option type with this name already exists"})
+		return
+	}
+
+	if err := h.db.Model(optionType).Update("name", strings.TrimSpace(req.Name)).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update option type"})
+		return
+	}
+
+	h.db.Preload("Values").First(optionType, optionType.ID)
+
+	c.JSON(http.StatusOK, optionType)
+}
+
+// DeleteOptionType deletes an option type that no variant uses
+func (h *AdminProductHandler) DeleteOptionType(c *gin.Context) {
+	optionType, ok := h.findOptionType(c)
+	if !ok {
+		return
+	}
+
+	var used int64
+	h.db.Table("variant_option_values").
+		Joins("JOIN option_values ON option_values.id = variant_option_values.option_value_id").
+		Where("option_values.option_type_id = ?", optionType.ID).
+		Count(&used)
+	if used > 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "Option type is used by product variants"})
+		return
+	}
+
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("option_type_id = ?", optionType.ID).Delete(&models.OptionValue{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(optionType).Error
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete option type"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Option type deleted successfully"})
+}
+
+// AddOptionValue adds a value to an option type
+func (h *AdminProductHandler) AddOptionValue(c *gin.Context) {
+	optionType, ok := h.findOptionType(c)
+	if !ok {
+		return
+	}
+
+	var req OptionValueRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var existing int64
+	h.db.Model(&models.OptionValue{}).Where("option_type_id = ? AND LOWER(value) = LOWER(?)", optionType.ID, strings.TrimSpace(req.Value)).Count(&existing)
+	if existing > 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "Option value already exists"})
+		return
+	}
+
+	value := models.OptionValue{OptionTypeID: optionType.ID, Value: strings.TrimSpace(req.Value), Position: req.Position}
+	if err := h.db.Create(&value).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to add option value"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, value)
+}
+
+// DeleteOptionValue deletes an option value that no variant uses
+func (h *AdminProductHandler) DeleteOptionValue(c *gin.Context) {
+	valueID, err := strconv.ParseUint(c.Param("valueId"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid option value ID"})
+		return
+	}
+
+	var used int64
+	h.db.Table("variant_option_values").Where("option_value_id = ?", valueID).Count(&used)
+	if used > 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "Option value is used by product variants"})
+		return
+	}
+
+	result := h.db.Where("option_type_id = ?", c.Param("id")).Delete(&models.OptionValue{}, valueID)
+	if result.Error != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete option value"})
+		return
+	}
+	if result.RowsAffected == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Option value not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Option value deleted successfully"})
+}
+
+// GetVariants lists a product's variants, inactive ones included
+func (h *AdminProductHandler) GetVariants(c *gin.Context) {
+	product, ok := h.findVariantProduct(c)
+	if !ok {
+		return
+	}
+
+	var variants []models.ProductVariant
+	if err := h.db.Preload("OptionValues.OptionType").Preload("Images").
+		Where("product_id = ?", product.ID).
+		Order("position ASC, id ASC").
+		Find(&variants).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch variants"})
+		return
+	}
+
+	c.JSON(http.StatusOK, variants)
+}
+
+// CreateVariant adds a variant to a product. Once a product has variants its stock is the
+// total of theirs.
+func (h *AdminProductHandler) CreateVariant(c *gin.Context) {
+	product, ok := h.findVariantProduct(c)
+	if !ok {
+		return
+	}
+
+	var req VariantRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	variant := models.ProductVariant{ProductID: product.ID, IsActive: true}
+	if !h.applyVariantRequest(c, &variant, req) {
+		return
+	}
+
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Omit("OptionValues", "Images").Create(&variant).Error; err != nil {
+			return err
+		}
+		// A false flag would otherwise fall back to the column default
+		if !variant.IsActive {
+			if err := tx.Model(&variant).Update("is_active", false).Error; err != nil {
+				return err
+			}
+		}
+		return h.saveVariantDetails(tx, &variant, req)
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create variant"})
+		return
+	}
+
+	h.db.Preload("OptionValues.OptionType").Preload("Images").First(&variant, variant.ID)
+
+	c.JSON(http.StatusCreated, variant)
+}
+
+// UpdateVariant replaces a variant's details
+func (h *AdminProductHandler) UpdateVariant(c *gin.Context) {
+	product, ok := h.findVariantProduct(c)
+	if !ok {
+		return
+	}
+
+	variant, ok := h.findVariant(c, product.ID)
+	if !ok {
+		return
+	}
+
+	var req VariantRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if !h.applyVariantRequest(c, variant, req) {
+		return
+	}
+
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(variant).Select("SKU", "Name", "Price", "Stock", "IsActive", "Position").Updates(variant).Error; err != nil {
+			return err
+		}
+		return h.saveVariantDetails(tx, variant, req)
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update variant"})
+		return
+	}
+
+	h.db.Preload("OptionValues.OptionType").Preload("Images").First(variant, variant.ID)
+
+	c.JSON(http.StatusOK, variant)
+}
+
+// DeleteVariant removes a variant. Past orders keep the variant's name.
+func (h *AdminProductHandler) DeleteVariant(c *gin.Context) {
+	product, ok := h.findVariantProduct(c)
+	if !ok {
+		return
+	}
+
+	variant, ok := h.findVariant(c, product.ID)
+	if !ok {
+		return
+	}
+
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Delete(variant).Error; err != nil {
+			return err
+		}
+		return syncProductStock(tx, product.ID)
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete variant"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Variant deleted successfully"})
+}
+
+// applyVariantRequest copies a request onto a variant after checking its SKU and options,
+// writing the error response when they are not acceptable
+func (h *AdminProductHandler) applyVariantRequest(c *gin.Context, variant *models.ProductVariant, req VariantRequest) bool {
+	var existing int64
+	h.db.Model(&models.ProductVariant{}).Unscoped().Where("sku = ? AND id != ?", req.SKU, variant.ID).Count(&existing)
+	if existing == 0 {
+		h.db.Model(&models.Product{}).Where("sku = ?", req.SKU).Count(&existing)
+	}
+	if existing > 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "A product or variant with this SKU already exists"})
+		return false
+	}
+
+	var values []models.OptionValue
+	if err := h.db.Where("id IN ?", req.OptionValueIDs).Find(&values).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return false
+	}
+	if len(values) != len(req.OptionValueIDs) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown option value"})
+		return false
+	}
+	types := map[uint]bool{}
+	for _, value := range values {
+		if types[value.OptionTypeID] {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "A variant takes one value per option type"})
+			return false
+		}
+		types[value.OptionTypeID] = true
+	}
+
+	// No two variants of a product may have the same options
+	var siblings []models.ProductVariant
+	h.db.Preload("OptionValues").Where("product_id = ? AND id != ?", variant.ProductID, variant.ID).Find(&siblings)
+	for _, sibling := range siblings {
+		if optionKey(sibling.OptionValues) == optionKey(values) {
+			c.JSON(http.StatusConflict, gin.H{"error": "A variant with these options already exists"})
+			return false
+		}
+	}
+
+	variant.SKU = req.SKU
+	variant.Name = variantName(values)
+	variant.Price = req.Price
+	variant.Stock = req.Stock
+	variant.Position = req.Position
+	variant.OptionValues = values
+	if req.IsActive != nil {
+		variant.IsActive = *req.IsActive
+	}
+	return true
+}
+
+// saveVariantDetails stores a variant's options and images, then refreshes the product's stock
+func (h *AdminProductHandler) saveVariantDetails(tx *gorm.DB, variant *models.ProductVariant, req VariantRequest) error {
+	if err := tx.Model(variant).Association("OptionValues").Replace(variant.OptionValues); err != nil {
+		return err
+	}
+
+	if req.Images != nil {
+		if err := tx.Where("variant_id = ?", variant.ID).Delete(&models.ProductImage{}).Error; err != nil {
+			return err
+		}
+		for i, imageURL := range req.Images {
+			if imageURL == "" {
+				continue
+			}
+			image := models.ProductImage{
+				ProductID: variant.ProductID,
+				VariantID: &variant.ID,
+				URL:       imageURL,
+				AltText:   variant.Name,
+				IsPrimary: i == 0,
+			}
+			if err := tx.Create(&image).Error; err != nil {
+				return err
+			}
+		}
+	}
+
+	return syncProductStock(tx, variant.ProductID)
+}
+
+// optionKey identifies a combination of option values regardless of order
+func optionKey(values []models.OptionValue) string {
+	ids := make([]int, 0, len(values))
+	for _, value := range values {
+		ids = append(ids, int(value.ID))
+	}
+	sort.Ints(ids)
+	return fmt.Sprint(ids)
+}
+
+// findVariantProduct loads the product in the URL, as long as the caller manages it
+func (h *AdminProductHandler) findVariantProduct(c *gin.Context) (*models.Product, bool) {
+	productID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid product ID"})
+		return nil, false
+	}
+
+	vendorID, ok := currentVendorID(h.db, c)
+	if !ok {
+		return nil, false
+	}
+
+	var product models.Product
+	if err := h.db.Scopes(ownedByVendor("vendor_id", vendorID)).First(&product, productID).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Product not found"})
+			return nil, false
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return nil, false
+	}
+
+	return &product, true
+}
+
+// findVariant loads the product's variant named in the URL
+func (h *AdminProductHandler) findVariant(c *gin.Context, productID uint) (*models.ProductVariant, bool) {
+	variantID, err := strconv.ParseUint(c.Param("variantId"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid variant ID"})
+		return nil, false
+	}
+
+	var variant models.ProductVariant
+	if err := h.db.Where("product_id = ?", productID).First(&variant, variantID).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Variant not found"})
+			return nil, false
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return nil, false
+	}
+
+	return &variant, true
+}
+
+// findOptionType loads the option type named in the URL
+func (h *AdminProductHandler) findOptionType(c *gin.Context) (*models.OptionType, bool) {
+	optionTypeID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid option type ID"})
+		return nil, false
+	}
+
+	var optionType models.OptionType
+	if err := h.db.First(&optionType, optionTypeID).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Option type not found"})
+			return nil, false
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return nil, false
+	}
+
+	return &optionType, true
+}
@@ -0,0 +1,676 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+	"github.com/yourname/sakifarm-ecommerce/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var errNoVendorAccount = errors.New("Your account is not linked to an active vendor")
+
+// vendorOrderStages orders sub-order statuses so the parent order can follow the slowest vendor
+var vendorOrderStages = map[string]int{
+	"pending":    0,
+	"processing": 1,
+	"shipped":    2,
+	"delivered":  3,
+}
+
+type VendorHandler struct {
+	db        *gorm.DB
+	validator *validator.Validate
+}
+
+func NewVendorHandler(db *gorm.DB) *VendorHandler {
+	return &VendorHandler{
+		db:        db,
+		validator: validator.New(),
+	}
+}
+
+type VendorRequest struct {
+	Name           string  `json:"name" validate:"required"`
+	Email          string  `json:"email" validate:"omitempty,email"`
+	Phone          string  `json:"phone"`
+	Address        string  `json:"address"`
+	KRAPIN         string  `json:"kra_pin"`
+	PayoutMethod   string  `json:"payout_method" validate:"omitempty,oneof=mpesa bank"`
+	PayoutAccount  string  `json:"payout_account"`
+	CommissionRate float64 `json:"commission_rate" validate:"min=0,max=100"`
+	IsActive       *bool   `json:"is_active,omitempty"`
+}
+
+type AssignVendorUserRequest struct {
+	UserID uint `json:"user_id" validate:"required"`
+}
+
+type UpdateVendorOrderStatusRequest struct {
+	Status         string `json:"status" validate:"required,oneof=processing shipped delivered cancelled"`
+	TrackingNumber string `json:"tracking_number"`
+}
+
+// VendorPayout is one vendor's line in a payout report
+type VendorPayout struct {
+	VendorID         *uint   `json:"vendor_id"`
+	VendorName       string  `json:"vendor_name"`
+	Orders           int64   `json:"orders"`
+	GrossSales       float64 `json:"gross_sales"`
+	CommissionAmount float64 `json:"commission_amount"`
+	PayoutAmount     float64 `json:"payout_amount"`
+}
+
+// GetVendors lists all vendors (admin)
+func (h *VendorHandler) GetVendors(c *gin.Context) {
+	query := h.db.Order("name ASC")
+	if active := c.Query("active"); active != "" {
+		query = query.Where("is_active = ?", active == "true")
+	}
+
+	var vendors []models.Vendor
+	if err := query.Find(&vendors).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch vendors"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"vendors": vendors})
+}
+
+// CreateVendor registers a new vendor (admin)
+func (h *VendorHandler) CreateVendor(c *gin.Context) {
+	var req VendorRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := h.validator.Struct(req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	vendor := models.Vendor{IsActive: true}
+	applyVendorRequest(&vendor, req)
+
+	if err := h.db.Create(&vendor).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create vendor"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"message": "Vendor created successfully",
+		"vendor":  vendor,
+	})
+}
+
+// UpdateVendor changes a vendor's details. A new commission rate applies to orders placed
+// from now on; existing sub-orders keep the rate they were split at. (admin)
+func (h *VendorHandler) UpdateVendor(c *gin.Context) {
+	vendor, ok := h.findVendor(c)
+	if !ok {
+		return
+	}
+
+	var req VendorRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := h.validator.Struct(req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	applyVendorRequest(vendor, req)
+
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Save(vendor).Error; err != nil {
+			return err
+		}
+		if !vendor.IsActive {
+			return deactivateVendorProducts(tx, vendor.ID)
+		}
+		return nil
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update vendor"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Vendor updated successfully",
+		"vendor":  vendor,
+	})
+}
+
+// DeleteVendor deactivates a vendor and takes their products off sale. Vendors are never
+// removed so their order history and payouts stay intact. (admin)
+func (h *VendorHandler) DeleteVendor(c *gin.Context) {
+	vendor, ok := h.findVendor(c)
+	if !ok {
+		return
+	}
+
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(vendor).Update("is_active", false).Error; err != nil {
+			return err
+		}
+		return deactivateVendorProducts(tx, vendor.ID)
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to deactivate vendor"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Vendor deactivated successfully"})
+}
+
+// AssignVendorUser gives a user the vendor role so they can manage the vendor's products
+// and sub-orders (admin)
+func (h *VendorHandler) AssignVendorUser(c *gin.Context) {
+	vendor, ok := h.findVendor(c)
+	if !ok {
+		return
+	}
+
+	var req AssignVendorUserRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := h.validator.Struct(req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var user models.User
+	if err := h.db.First(&user, req.UserID).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch user"})
+		return
+	}
+
+	if user.Role == "admin" || user.Role == "staff" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Admin and staff accounts cannot act for a vendor"})
+		return
+	}
+
+	if err := h.db.Model(&user).Updates(map[string]interface{}{
+		"role":      "vendor",
+		"vendor_id": vendor.ID,
+	}).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to assign user"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "User can now manage this vendor. They need to log in again to pick up the new role"})
+}
+
+// GetVendorOrders lists sub-orders. Vendors see their own; admins see all, optionally
+// filtered by vendor.
+func (h *VendorHandler) GetVendorOrders(c *gin.Context) {
+	vendorID, ok := currentVendorID(h.db, c)
+	if !ok {
+		return
+	}
+
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "20"))
+	if page < 1 {
+		page = 1
+	}
+	if limit < 1 || limit > 100 {
+		limit = 20
+	}
+
+	query := h.db.Model(&models.VendorOrder{}).Scopes(ownedByVendor("vendor_id", vendorID))
+	if filter := c.Query("vendor_id"); filter != "" && vendorID == nil {
+		query = query.Where("vendor_id = ?", filter)
+	}
+	if status := c.Query("status"); status != "" {
+		query = query.Where("status = ?", status)
+	}
+
+	var total int64
+	query.Count(&total)
+
+	var vendorOrders []models.VendorOrder
+	if err := query.Preload("Items.Product").Preload("Vendor").
+		Order("created_at DESC").Offset((page - 1) * limit).Limit(limit).
+		Find(&vendorOrders).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch orders"})
+		return
+	}
+
+	// Vendors need the delivery details but not the rest of the customer's order
+	orderIDs := make([]uint, 0, len(vendorOrders))
+	for _, vendorOrder := range vendorOrders {
+		orderIDs = append(orderIDs, vendorOrder.OrderID)
+	}
+	var orders []models.Order
+	h.db.Where("id IN ?", orderIDs).Find(&orders)
+	ordersByID := make(map[uint]models.Order, len(orders))
+	for _, order := range orders {
+		ordersByID[order.ID] = order
+	}
+
+	results := make([]gin.H, 0, len(vendorOrders))
+	for _, vendorOrder := range vendorOrders {
+		order := ordersByID[vendorOrder.OrderID]
+		results = append(results, gin.H{
+			"vendor_order":     vendorOrder,
+			"order_number":     order.OrderNumber,
+			"payment_status":   order.PaymentStatus,
+			"fulfilment_type":  order.FulfilmentType,
+			"shipping_address": order.ShippingAddress,
+		})
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"orders": results,
+		"pagination": gin.H{
+			"page":  page,
+			"limit": limit,
+			"total": total,
+			"pages": (total + int64(limit) - 1) / int64(limit),
+		},
+	})
+}
+
+// UpdateVendorOrderStatus moves a sub-order along on its own. The customer's order follows
+// once every vendor has caught up.
+func (h *VendorHandler) UpdateVendorOrderStatus(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid order ID"})
+		return
+	}
+
+	vendorID, ok := currentVendorID(h.db, c)
+	if !ok {
+		return
+	}
+
+	var req UpdateVendorOrderStatusRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := h.validator.Struct(req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var vendorOrder models.VendorOrder
+	var statusErr error
+	err = h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Scopes(ownedByVendor("vendor_id", vendorID)).First(&vendorOrder, id).Error; err != nil {
+			return err
+		}
+
+		var order models.Order
+		if err := tx.First(&order, vendorOrder.OrderID).Error; err != nil {
+			return err
+		}
+
+		switch {
+		case vendorOrder.Status == "cancelled" || vendorOrder.Status == "delivered":
+			statusErr = errors.New("Order is already closed")
+		case order.Status == "cancelled":
+			statusErr = errors.New("Customer order has been cancelled")
+		case req.Status == "cancelled" && vendorOrder.Status == "shipped":
+			statusErr = errors.New("Shipped orders cannot be cancelled")
+		case req.Status != "cancelled" && vendorOrderStages[req.Status] < vendorOrderStages[vendorOrder.Status]:
+			statusErr = errors.New("Order cannot move back to an earlier status")
+		case req.Status != "processing" && req.Status != "cancelled" && order.PaymentStatus != "paid":
+			statusErr = errors.New("Order has not been paid yet")
+		case req.Status == "cancelled" && order.PaymentStatus == "paid" && order.UserID == nil:
+			statusErr = errors.New("Paid guest orders must be refunded through a return")
+		}
+		if statusErr != nil {
+			return statusErr
+		}
+
+		// A cancelled sub-order gives its stock back, and a paid one is credited to the customer
+		if req.Status == "cancelled" {
+			var items []models.OrderItem
+			if err := tx.Where("vendor_order_id = ?", vendorOrder.ID).Find(&items).Error; err != nil {
+				return err
+			}
+			if err := releaseOrderItems(tx, items); err != nil {
+				return err
+			}
+			if err := tx.Model(&models.OrderItem{}).Where("vendor_order_id = ?", vendorOrder.ID).
+				Update("status", "cancelled").Error; err != nil {
+				return err
+			}
+			// The customer gets back what they paid for the items, after the discount
+			if amount := paidFor(order, vendorOrder.Subtotal); order.PaymentStatus == "paid" && amount > 0 {
+				credit := models.StoreCredit{
+					UserID:      *order.UserID,
+					Amount:      amount,
+					Source:      "vendor_cancellation",
+					ReferenceID: vendorOrder.ID,
+					Description: fmt.Sprintf("Credit for cancelled items on order %s", order.OrderNumber),
+				}
+				if err := tx.Create(&credit).Error; err != nil {
+					return err
+				}
+			}
+		}
+
+		now := time.Now()
+		vendorOrder.Status = req.Status
+		if req.TrackingNumber != "" {
+			vendorOrder.TrackingNumber = req.TrackingNumber
+		}
+		if (req.Status == "shipped" || req.Status == "delivered") && vendorOrder.ShippedAt == nil {
+			vendorOrder.ShippedAt = &now
+		}
+		if req.Status == "delivered" {
+			vendorOrder.DeliveredAt = &now
+		}
+		if err := tx.Omit("Items", "Vendor").Save(&vendorOrder).Error; err != nil {
+			return err
+		}
+
+		return rollUpVendorOrders(tx, vendorOrder.OrderID)
+	})
+	if err != nil {
+		if statusErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": statusErr.Error()})
+			return
+		}
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Order not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update order"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":      "Order status updated successfully",
+		"vendor_order": vendorOrder,
+	})
+}
+
+// GetPayoutReport totals delivered sub-orders of paid orders per vendor for a period, split
+// into the store's commission and what is owed to the vendor. from and to are YYYY-MM-DD
+// delivery dates, with to inclusive. Vendors only see their own line.
+func (h *VendorHandler) GetPayoutReport(c *gin.Context) {
+	vendorID, ok := currentVendorID(h.db, c)
+	if !ok {
+		return
+	}
+
+	query := h.db.Table("vendor_orders").
+		Select("vendor_orders.vendor_id, COALESCE(vendors.name, '') AS vendor_name, COUNT(*) AS orders, "+
+			"SUM(vendor_orders.subtotal) AS gross_sales, SUM(vendor_orders.commission_amount) AS commission_amount, "+
+			"SUM(vendor_orders.vendor_payout) AS payout_amount").
+		Joins("JOIN orders ON orders.id = vendor_orders.order_id").
+		Joins("LEFT JOIN vendors ON vendors.id = vendor_orders.vendor_id").
+		Where("vendor_orders.status = ? AND orders.payment_status = ?", "delivered", "paid").
+		Scopes(ownedByVendor("vendor_orders.vendor_id", vendorID)).
+		Group("vendor_orders.vendor_id, vendors.name").
+		Order("vendor_name ASC")
+
+	if from := c.Query("from"); from != "" {
+		fromDate, err := time.Parse("2006-01-02", from)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid from date, expected YYYY-MM-DD"})
+			return
+		}
+		query = query.Where("vendor_orders.delivered_at >= ?", fromDate)
+	}
+	if to := c.Query("to"); to != "" {
+		toDate, err := time.Parse("2006-01-02", to)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid to date, expected YYYY-MM-DD"})
+			return
+		}
+		query = query.Where("vendor_orders.delivered_at < ?", toDate.AddDate(0, 0, 1))
+	}
+
+	var payouts []VendorPayout
+	if err := query.Scan(&payouts).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to build payout report"})
+		return
+	}
+
+	var totalCommission, totalPayout float64
+	for _, payout := range payouts {
+		totalCommission += payout.CommissionAmount
+		totalPayout += payout.PayoutAmount
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"payouts":          payouts,
+		"total_commission": totalCommission,
+		"total_payout":     totalPayout,
+	})
+}
+
+func (h *VendorHandler) findVendor(c *gin.Context) (*models.Vendor, bool) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid vendor ID"})
+		return nil, false
+	}
+
+	var vendor models.Vendor
+	if err := h.db.First(&vendor, id).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Vendor not found"})
+			return nil, false
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch vendor"})
+		return nil, false
+	}
+	return &vendor, true
+}
+
+func applyVendorRequest(vendor *models.Vendor, req VendorRequest) {
+	vendor.Name = req.Name
+	vendor.Email = req.Email
+	vendor.Phone = req.Phone
+	vendor.Address = req.Address
+	vendor.KRAPIN = req.KRAPIN
+	vendor.PayoutMethod = req.PayoutMethod
+	vendor.PayoutAccount = req.PayoutAccount
+	vendor.CommissionRate = req.CommissionRate
+	if req.IsActive != nil {
+		vendor.IsActive = *req.IsActive
+	}
+}
+
+func deactivateVendorProducts(tx *gorm.DB, vendorID uint) error {
+	return tx.Model(&models.Product{}).Where("vendor_id = ? AND status = ?", vendorID, "active").
+		Update("status", "inactive").Error
+}
+
+// currentVendorID returns the vendor a vendor user acts for, or nil for admins, who act for
+// the whole store. On failure the response has already been written.
+func currentVendorID(db *gorm.DB, c *gin.Context) (*uint, bool) {
+	if c.GetString("user_role") != "vendor" {
+		return nil, true
+	}
+
+	userID, _ := c.Get("user_id")
+	var vendor models.Vendor
+	err := db.Joins("JOIN users ON users.vendor_id = vendors.id").
+		Where("users.id = ? AND vendors.is_active = ?", userID, true).
+		First(&vendor).Error
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusForbidden, gin.H{"error": errNoVendorAccount.Error()})
+			return nil, false
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch vendor"})
+		return nil, false
+	}
+	return &vendor.ID, true
+}
+
+// ownedByVendor limits a query to rows whose column matches the vendor. A nil vendor leaves
+// the query unrestricted.
+func ownedByVendor(column string, vendorID *uint) func(*gorm.DB) *gorm.DB {
+	return func(query *gorm.DB) *gorm.DB {
+		if vendorID == nil {
+			return query
+		}
+		return query.Where(column+" = ?", *vendorID)
+	}
+}
+
+// splitOrderByVendor groups an order's items into one sub-order per vendor, the store's own
+// products sharing a sub-order without a vendor. Commission is fixed at each vendor's rate when
+// the sub-order is first created. Running it again after the items change updates the totals
+// in place and drops sub-orders that no longer have items.
+func splitOrderByVendor(tx *gorm.DB, orderID uint) error {
+	// Cancelled sub-orders and their items stay as they are
+	var items []models.OrderItem
+	if err := tx.Preload("Product").Where("order_id = ? AND status != ?", orderID, "cancelled").Find(&items).Error; err != nil {
+		return err
+	}
+
+	var existing []models.VendorOrder
+	if err := tx.Where("order_id = ? AND status != ?", orderID, "cancelled").Find(&existing).Error; err != nil {
+		return err
+	}
+	byVendor := make(map[uint]*models.VendorOrder)
+	for i := range existing {
+		byVendor[vendorKey(existing[i].VendorID)] = &existing[i]
+	}
+
+	itemIDs := make(map[uint][]uint)
+	subtotals := make(map[uint]float64)
+	var keys []uint
+	for _, item := range items {
+		key := vendorKey(item.Product.VendorID)
+		if _, ok := subtotals[key]; !ok {
+			keys = append(keys, key)
+		}
+		itemIDs[key] = append(itemIDs[key], item.ID)
+		subtotals[key] += item.Total
+	}
+
+	for _, key := range keys {
+		vendorOrder, ok := byVendor[key]
+		if !ok {
+			vendorOrder = &models.VendorOrder{OrderID: orderID, Status: "pending"}
+			// The store keeps everything it sells itself
+			vendorOrder.CommissionRate = 100
+			if key != 0 {
+				var vendor models.Vendor
+				if err := tx.First(&vendor, key).Error; err != nil {
+					return err
+				}
+				vendorID := vendor.ID
+				vendorOrder.VendorID = &vendorID
+				vendorOrder.CommissionRate = vendor.CommissionRate
+			}
+		}
+
+		vendorOrder.Subtotal = subtotals[key]
+		vendorOrder.CommissionAmount = math.Round(vendorOrder.Subtotal*vendorOrder.CommissionRate) / 100
+		vendorOrder.VendorPayout = vendorOrder.Subtotal - vendorOrder.CommissionAmount
+		if err := tx.Omit("Items", "Vendor").Save(vendorOrder).Error; err != nil {
+			return err
+		}
+
+		if err := tx.Model(&models.OrderItem{}).Where("id IN ?", itemIDs[key]).
+			Update("vendor_order_id", vendorOrder.ID).Error; err != nil {
+			return err
+		}
+		delete(byVendor, key)
+	}
+
+	// Vendors whose items were all removed from the order
+	for _, vendorOrder := range byVendor {
+		if err := tx.Delete(vendorOrder).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// cancelVendorOrders cancels the sub-orders of a cancelled order that haven't left the vendor
+func cancelVendorOrders(tx *gorm.DB, orderID uint) error {
+	return tx.Model(&models.VendorOrder{}).
+		Where("order_id = ? AND status IN ?", orderID, []string{"pending", "processing"}).
+		Update("status", "cancelled").Error
+}
+
+// rollUpVendorOrders moves the customer's order to the status of its slowest vendor. An order
+// whose sub-orders were all cancelled is cancelled too.
+func rollUpVendorOrders(tx *gorm.DB, orderID uint) error {
+	var vendorOrders []models.VendorOrder
+	if err := tx.Where("order_id = ?", orderID).Find(&vendorOrders).Error; err != nil {
+		return err
+	}
+	if len(vendorOrders) == 0 {
+		return nil
+	}
+
+	open := vendorOrders[:0]
+	for _, vendorOrder := range vendorOrders {
+		if vendorOrder.Status != "cancelled" {
+			open = append(open, vendorOrder)
+		}
+	}
+	vendorOrders = open
+	if len(vendorOrders) == 0 {
+		var order models.Order
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Preload("Items").First(&order, orderID).Error; err != nil {
+			return err
+		}
+		if order.Status == "cancelled" {
+			return nil
+		}
+		return cancelOrderTx(tx, &order)
+	}
+
+	status := "delivered"
+	var lastDelivery *time.Time
+	for _, vendorOrder := range vendorOrders {
+		if vendorOrderStages[vendorOrder.Status] < vendorOrderStages[status] {
+			status = vendorOrder.Status
+		}
+		if vendorOrder.DeliveredAt != nil && (lastDelivery == nil || vendorOrder.DeliveredAt.After(*lastDelivery)) {
+			lastDelivery = vendorOrder.DeliveredAt
+		}
+	}
+	if status == "pending" {
+		return nil
+	}
+
+	updates := map[string]interface{}{"status": status}
+	if status == "delivered" {
+		updates["delivered_at"] = lastDelivery
+	}
+	return tx.Model(&models.Order{}).Where("id = ? AND status != ?", orderID, "cancelled").
+		Updates(updates).Error
+}
+
+// vendorKey maps a product's vendor to a map key, 0 standing for the store itself
+func vendorKey(vendorID *uint) uint {
+	if vendorID == nil {
+		return 0
+	}
+	return *vendorID
+}
@@ -0,0 +1,291 @@
+package handlers
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/yourname/sakifarm-ecommerce/models"
+	"gorm.io/gorm"
+)
+
+// WishlistHandler serves a signed-in user's wishlist. Wishlisted products can alert the user
+// when their price drops or when they come back in stock.
+type WishlistHandler struct {
+	db   *gorm.DB
+	cart *CartHandler
+}
+
+func NewWishlistHandler(db *gorm.DB, cart *CartHandler) *WishlistHandler {
+	return &WishlistHandler{db: db, cart: cart}
+}
+
+type AddToWishlistRequest struct {
+	ProductID         uint  `json:"product_id" binding:"required"`
+	NotifyPriceDrop   *bool `json:"notify_price_drop"`
+	NotifyBackInStock *bool `json:"notify_back_in_stock"`
+}
+
+type WishlistAlertsRequest struct {
+	NotifyPriceDrop   *bool `json:"notify_price_drop"`
+	NotifyBackInStock *bool `json:"notify_back_in_stock"`
+}
+
+// GetWishlist lists the user's wishlisted products, most recently added first
+func (h *WishlistHandler) GetWishlist(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+
+	var items []models.Wishlist
+	if err := h.db.Preload("Product.Images").
+		Where("user_id = ?", userID).
+		Order("created_at DESC").
+		Find(&items).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch wishlist"})
+		return
+	}
+
+	c.JSON(http.StatusOK, items)
+}
+
+// AddToWishlist adds a product to the wishlist. Adding a product that is already there
+// updates its alert settings instead.
+func (h *WishlistHandler) AddToWishlist(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+
+	var req AddToWishlistRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var product models.Product
+	if err := h.db.First(&product, req.ProductID).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Product not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	var item models.Wishlist
+	err := h.db.Where("user_id = ? AND product_id = ?", userID, product.ID).First(&item).Error
+	if err != nil && err != gorm.ErrRecordNotFound {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	created := err == gorm.ErrRecordNotFound
+	if created {
+		item = models.Wishlist{UserID: userID.(uint), ProductID: product.ID, NotifyPriceDrop: true, NotifyBackInStock: true}
+	}
+	if req.NotifyPriceDrop != nil {
+		item.NotifyPriceDrop = *req.NotifyPriceDrop
+	}
+	if req.NotifyBackInStock != nil {
+		item.NotifyBackInStock = *req.NotifyBackInStock
+	}
+
+	// Selecting the columns stores false alert settings rather than the column defaults
+	if created {
+		err = h.db.Select("UserID", "ProductID", "NotifyPriceDrop", "NotifyBackInStock", "CreatedAt").Create(&item).Error
+	} else {
+		err = h.db.Model(&item).Select("NotifyPriceDrop", "NotifyBackInStock").Updates(&item).Error
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to add product to wishlist"})
+		return
+	}
+
+	item.Product = product
+	h.db.Preload("Images").First(&item.Product, product.ID)
+
+	status := http.StatusOK
+	if created {
+		status = http.StatusCreated
+	}
+	c.JSON(status, item)
+}
+
+// UpdateWishlistAlerts turns price drop and back in stock alerts on or off for a product
+func (h *WishlistHandler) UpdateWishlistAlerts(c *gin.Context) {
+	item, ok := h.findWishlistItem(c)
+	if !ok {
+		return
+	}
+
+	var req WishlistAlertsRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	updates := map[string]interface{}{}
+	if req.NotifyPriceDrop != nil {
+		updates["notify_price_drop"] = *req.NotifyPriceDrop
+	}
+	if req.NotifyBackInStock != nil {
+		updates["notify_back_in_stock"] = *req.NotifyBackInStock
+	}
+	if len(updates) > 0 {
+		if err := h.db.Model(item).Updates(updates).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update wishlist alerts"})
+			return
+		}
+	}
+
+	c.JSON(http.StatusOK, item)
+}
+
+// RemoveFromWishlist removes a product from the wishlist
+func (h *WishlistHandler) RemoveFromWishlist(c *gin.Context) {
+	item, ok := h.findWishlistItem(c)
+	if !ok {
+		return
+	}
+
+	if err := h.db.Delete(item).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to remove product from wishlist"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Product removed from wishlist"})
+}
+
+// MoveToCart adds a wishlisted product to the user's cart and takes it off the wishlist
+func (h *WishlistHandler) MoveToCart(c *gin.Context) {
+	item, ok := h.findWishlistItem(c)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		Quantity  int   `json:"quantity" binding:"omitempty,min=1"`
+		VariantID *uint `json:"variant_id"` // required for products with variants
+	}
+	// The body is optional; one unit is moved by default
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	if req.Quantity == 0 {
+		req.Quantity = 1
+	}
+
+	var product models.Product
+	if err := h.db.First(&product, item.ProductID).Error; err != nil || product.Status != "active" {
+		c.JSON(http.StatusConflict, gin.H{"error": "Product is no longer available"})
+		return
+	}
+
+	variant, err := findOrderVariant(h.db, product, req.VariantID)
+	if err != nil {
+		if checkoutErr, ok := err.(*checkoutError); ok {
+			c.JSON(checkoutErr.status, gin.H{"error": checkoutErr.message})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	cart, err := h.cart.findCart(c, true)
+	if err != nil {
+		h.cart.cartError(c, err)
+		return
+	}
+
+	cartItem, err := h.cart.addCartItem(h.db, cart, product, variant, req.Quantity)
+	if err != nil {
+		if checkoutErr, ok := err.(*checkoutError); ok {
+			c.JSON(checkoutErr.status, gin.H{"error": checkoutErr.message})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to add item to cart"})
+		return
+	}
+
+	if err := h.db.Delete(item).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to remove product from wishlist"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":   "Product moved to cart",
+		"cart_item": cartItem,
+	})
+}
+
+// findWishlistItem loads the user's wishlist entry for the product in the URL, writing the
+// error response if there is none
+func (h *WishlistHandler) findWishlistItem(c *gin.Context) (*models.Wishlist, bool) {
+	userID, _ := c.Get("user_id")
+
+	productID, err := strconv.ParseUint(c.Param("product_id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid product ID"})
+		return nil, false
+	}
+
+	var item models.Wishlist
+	if err := h.db.Where("user_id = ? AND product_id = ?", userID, productID).First(&item).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Product is not in your wishlist"})
+			return nil, false
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return nil, false
+	}
+
+	return &item, true
+}
+
+// sendWishlistAlerts notifies users who wishlisted a product after an update lowered its
+// price or brought it back in stock. Inactive products send nothing.
+func sendWishlistAlerts(db *gorm.DB, before, after models.Product) {
+	if after.Status != "active" {
+		return
+	}
+
+	priceDropped := after.Price < before.Price
+	backInStock := after.Stock > 0 && (before.Stock <= 0 || before.Status != "active")
+	if !priceDropped && !backInStock {
+		return
+	}
+
+	var items []models.Wishlist
+	if err := db.Where("product_id = ?", after.ID).Find(&items).Error; err != nil {
+		log.Printf("Product %d: failed to load wishlists for alerts: %v", after.ID, err)
+		return
+	}
+
+	var notifications []models.Notification
+	for _, item := range items {
+		switch {
+		case backInStock && item.NotifyBackInStock:
+			notifications = append(notifications, models.Notification{
+				UserID:  item.UserID,
+				Title:   "Back in stock",
+				Message: fmt.Sprintf("%s from your wishlist is back in stock.", after.Name),
+				Type:    "wishlist",
+				Data:    fmt.Sprintf(`{"product_id":%d}`, after.ID),
+			})
+		case priceDropped && item.NotifyPriceDrop:
+			notifications = append(notifications, models.Notification{
+				UserID:  item.UserID,
+				Title:   "Price drop",
+				Message: fmt.Sprintf("%s from your wishlist is now KES %.2f, down from KES %.2f.", after.Name, after.Price, before.Price),
+				Type:    "wishlist",
+				Data:    fmt.Sprintf(`{"product_id":%d}`, after.ID),
+			})
+		}
+	}
+
+	if len(notifications) > 0 {
+		if err := db.Create(&notifications).Error; err != nil {
+			log.Printf("Product %d: failed to send wishlist alerts: %v", after.ID, err)
+		}
+	}
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"log"
 	"net/http"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/yourname/sakifarm-ecommerce/config"
@@ -41,25 +42,98 @@ func main() {
 		&models.Notification{},
 		&models.Category{},
 		&models.Coupon{},
+		&models.IdempotencyKey{},
+		&models.ReturnRequest{},
+		&models.ReturnItem{},
+		&models.StoreCredit{},
+		&models.Shipment{},
+		&models.ShipmentItem{},
+		&models.DeliverySlot{},
+		&models.Subscription{},
+		&models.SubscriptionItem{},
+		&models.Invoice{},
+		&models.InvoiceLine{},
+		&models.TrackingEvent{},
+		&models.OrderNumberSequence{},
+		&models.PickupPoint{},
+		&models.Vendor{},
+		&models.VendorOrder{},
+		&models.InventoryReservation{},
+		&models.CartReminder{},
+		&models.CartShare{},
+		&models.CartShareItem{},
+		&models.OptionType{},
+		&models.OptionValue{},
+		&models.ProductVariant{},
 	); err != nil {
 		log.Fatal("Failed to migrate database:", err)
 	}
 
+	// Products categorised by name alone are linked to their category
+	if err := handlers.LinkProductCategories(db); err != nil {
+		log.Fatal("Failed to link products to categories:", err)
+	}
+
+	// Ranked, typo-tolerant product search
+	if err := handlers.EnsureProductSearch(db); err != nil {
+		log.Fatal("Failed to set up product search:", err)
+	}
+
+	// Tax invoice numbers are drawn from a sequence so they are never reused
+	if err := db.Exec("CREATE SEQUENCE IF NOT EXISTS invoice_number_seq").Error; err != nil {
+		log.Fatal("Failed to create invoice number sequence:", err)
+	}
+
 	// Initialize services
 	smsService := services.NewSMSService(cfg.TwilioAccountSID, cfg.TwilioAuthToken, cfg.TwilioPhone)
 	emailService := services.NewEmailService(cfg.SMTPHost, cfg.SMTPPort, cfg.SMTPUser, cfg.SMTPPassword)
 	authService := services.NewAuthService(db, smsService, emailService)
 	paymentService := services.NewPaymentService(db, cfg.MPesaConsumerKey, cfg.MPesaConsumerSecret, cfg.MPesaPasskey, cfg.MPesaShortcode, cfg.AirtelClientID, cfg.AirtelClientSecret, cfg.Environment)
-	pdfService := services.NewPDFService()
+	orderNumberService := services.NewOrderNumberService(db, cfg.OrderNumberPrefix, cfg.OrderNumberDigits)
+	pdfService := services.NewPDFService(services.SellerDetails{
+		Name:    cfg.SellerName,
+		KRAPIN:  cfg.SellerKRAPIN,
+		Address: cfg.SellerAddress,
+		Phone:   cfg.SellerPhone,
+		Email:   cfg.SellerEmail,
+	})
+
+	// Couriers that shipments can be booked with
+	carriers := map[string]services.Carrier{}
+	if cfg.SendyAPIKey != "" && cfg.SendyWebhookSecret == "" {
+		log.Println("Warning: Sendy is disabled until SENDY_WEBHOOK_SECRET is set")
+	}
+	if cfg.SendyAPIKey != "" && cfg.SendyWebhookSecret != "" {
+		carriers["sendy"] = services.NewSendyCarrier(cfg.SendyAPIURL, cfg.SendyAPIKey, cfg.SendyWebhookSecret, services.SellerDetails{
+			Name:    cfg.SellerName,
+			Address: cfg.SellerAddress,
+			Phone:   cfg.SellerPhone,
+		})
+	}
+	// The fake carrier's webhook is unsigned, so it is only there when asked for
+	if cfg.FakeCarrierEnabled {
+		carriers["fake"] = services.NewFakeCarrier()
+	}
 
 	// Initialize handlers
-	authHandler := handlers.NewAuthHandler(authService, cfg.JWTSecret)
 	productHandler := handlers.NewProductHandler(db)
-	orderHandler := handlers.NewOrderHandler(db, paymentService, emailService, pdfService)
 	adminProductHandler := handlers.NewAdminProductHandler(db)
+	categoryHandler := handlers.NewCategoryHandler(db)
 	adminDashboardHandler := handlers.NewAdminDashboardHandler(db)
 	reviewHandler := handlers.NewReviewHandler(db)
-	cartHandler := handlers.NewCartHandler(db)
+	cartHandler := handlers.NewCartHandler(db, cfg.JWTSecret, time.Duration(cfg.CartReservationMinutes)*time.Minute, cfg.FrontendURL)
+	authHandler := handlers.NewAuthHandler(db, authService, cfg.JWTSecret, cartHandler)
+	orderHandler := handlers.NewOrderHandler(db, paymentService, emailService, smsService, pdfService, orderNumberService, cartHandler)
+	wishlistHandler := handlers.NewWishlistHandler(db, cartHandler)
+	returnHandler := handlers.NewReturnHandler(db, cfg.ReturnWindowDays)
+	shipmentHandler := handlers.NewShipmentHandler(db, emailService, carriers)
+	guestHandler := handlers.NewGuestHandler(db, authService, paymentService, smsService, orderNumberService, cfg.JWTSecret, cartHandler)
+	deliverySlotHandler := handlers.NewDeliverySlotHandler(db)
+	subscriptionHandler := handlers.NewSubscriptionHandler(db, paymentService, orderNumberService, cfg.SubscriptionMaxAttempts, time.Duration(cfg.SubscriptionRetryHours)*time.Hour)
+	backorderHandler := handlers.NewBackorderHandler(db)
+	pickupPointHandler := handlers.NewPickupPointHandler(db)
+	vendorHandler := handlers.NewVendorHandler(db)
+	abandonedCartHandler := handlers.NewAbandonedCartHandler(db, emailService, smsService, cfg.AbandonedCartReminderHours, cfg.AbandonedCartCouponPercent, time.Duration(cfg.AbandonedCartCouponDays)*24*time.Hour, cfg.FrontendURL)
 	
 	// Create payment handler config
 	paymentConfig := &handlers.Config{
@@ -71,7 +145,7 @@ func main() {
 		AirtelClientSecret:  cfg.AirtelClientSecret,
 		Environment:         cfg.Environment,
 	}
-	paymentHandler := handlers.NewPaymentHandler(db, paymentConfig)
+	paymentHandler := handlers.NewPaymentHandler(db, paymentConfig, paymentService)
 
 	// Setup Gin router
 	if cfg.Environment == "production" {
@@ -116,6 +190,12 @@ func main() {
 		api.GET("/products/:id/reviews", reviewHandler.GetProductReviews)
 		api.GET("/categories", productHandler.GetCategories)
 
+		// Delivery slot availability
+		api.GET("/delivery-slots", deliverySlotHandler.GetAvailableSlots)
+
+		// Pickup locations
+		api.GET("/pickup-points", pickupPointHandler.GetPickupPoints)
+
 		// Payment callbacks (public for webhook access)
 		payments := api.Group("/payments")
 		{
@@ -125,11 +205,42 @@ func main() {
 
 		// Order tracking (public)
 		api.GET("/track/:trackingNumber", orderHandler.TrackOrder)
+
+		// Carrier tracking webhooks (public for courier access)
+		api.POST("/carriers/:carrier/webhook", shipmentHandler.CarrierWebhook)
+
+		// Cart routes, for signed-in users and for guests holding a cart token
+		cart := api.Group("/cart")
+		cart.Use(middleware.OptionalAuthMiddleware(cfg.JWTSecret))
+		{
+			cart.GET("", cartHandler.GetCart)
+			cart.POST("/quote", cartHandler.QuoteCart)
+			cart.POST("/fix", cartHandler.FixCart)
+			cart.POST("/bulk", cartHandler.BulkAddToCart)
+			cart.POST("/share", cartHandler.ShareCart)
+			cart.GET("/shared/:code", cartHandler.GetSharedCart)
+			cart.POST("/shared/:code/import", cartHandler.ImportSharedCart)
+			cart.POST("/add", cartHandler.AddToCart)
+			cart.PUT("/items/:id", cartHandler.UpdateCartItem)
+			cart.DELETE("/items/:id", cartHandler.RemoveFromCart)
+			cart.DELETE("/clear", cartHandler.ClearCart)
+		}
+
+		// Guest checkout and order lookup
+		guest := api.Group("/guest")
+		{
+			guest.POST("/checkout", guestHandler.Checkout)
+			guest.POST("/checkout/verify", guestHandler.VerifyCheckout)
+			guest.POST("/orders/otp", guestHandler.SendLookupOTP)
+			guest.POST("/orders/lookup", guestHandler.LookupOrder)
+			guest.POST("/orders/claim", guestHandler.ClaimOrder)
+		}
 	}
 
 	// Protected routes
 	protected := api.Group("/")
 	protected.Use(middleware.AuthMiddleware(cfg.JWTSecret))
+	idempotent := middleware.IdempotencyMiddleware(db, time.Duration(cfg.IdempotencyTTLHours)*time.Hour)
 	{
 		// User profile
 		protected.GET("/profile", authHandler.GetProfile)
@@ -137,17 +248,53 @@ func main() {
 		// User orders
 		orders := protected.Group("/orders")
 		{
-			orders.POST("", orderHandler.CreateOrder)
+			orders.POST("", idempotent, orderHandler.CreateOrder)
 			orders.GET("", orderHandler.GetOrders)
 			orders.GET("/:id", orderHandler.GetOrder)
 			orders.PUT("/:id/cancel", orderHandler.CancelOrder)
+			orders.PUT("/:id/items", orderHandler.EditOrder)
+			orders.POST("/:id/reorder", orderHandler.ReorderOrder)
 			orders.GET("/:id/receipt", orderHandler.GenerateReceipt)
+			orders.GET("/:id/invoice", orderHandler.GenerateInvoice)
+			orders.POST("/:id/returns", returnHandler.CreateReturn)
+			orders.GET("/:id/returns", returnHandler.GetReturns)
+			orders.GET("/:id/shipments", shipmentHandler.GetShipments)
+		}
+
+		// Return routes
+		returns := protected.Group("/returns")
+		{
+			returns.GET("", returnHandler.GetReturns)
+			returns.POST("/upload-photo", returnHandler.UploadReturnPhoto)
+		}
+		protected.GET("/store-credit", returnHandler.GetStoreCredit)
+
+		// Wishlist routes
+		wishlist := protected.Group("/wishlist")
+		{
+			wishlist.GET("", wishlistHandler.GetWishlist)
+			wishlist.POST("", wishlistHandler.AddToWishlist)
+			wishlist.PUT("/:product_id/alerts", wishlistHandler.UpdateWishlistAlerts)
+			wishlist.DELETE("/:product_id", wishlistHandler.RemoveFromWishlist)
+			wishlist.POST("/:product_id/move-to-cart", wishlistHandler.MoveToCart)
+		}
+
+		// Subscription routes
+		subscriptions := protected.Group("/subscriptions")
+		{
+			subscriptions.POST("", subscriptionHandler.CreateSubscription)
+			subscriptions.GET("", subscriptionHandler.GetSubscriptions)
+			subscriptions.GET("/:id", subscriptionHandler.GetSubscription)
+			subscriptions.PUT("/:id/pause", subscriptionHandler.PauseSubscription)
+			subscriptions.PUT("/:id/resume", subscriptionHandler.ResumeSubscription)
+			subscriptions.PUT("/:id/skip", subscriptionHandler.SkipSubscription)
+			subscriptions.PUT("/:id/cancel", subscriptionHandler.CancelSubscription)
 		}
 
 		// Payment routes
 		payments := protected.Group("/payments")
 		{
-			payments.POST("/mobile", paymentHandler.InitiateMobilePayment)
+			payments.POST("/mobile", idempotent, paymentHandler.InitiateMobilePayment)
 			payments.GET("/status/:transaction_id", paymentHandler.GetPaymentStatus)
 		}
 
@@ -165,16 +312,33 @@ func main() {
 			reviewActions.POST("/:id/like", reviewHandler.LikeReview)
 			reviewActions.POST("/:id/reply", reviewHandler.ReplyToReview)
 		}
+	}
 
-		// Cart routes
-		cart := protected.Group("/cart")
-		{
-			cart.GET("", cartHandler.GetCart)
-			cart.POST("/add", cartHandler.AddToCart)
-			cart.PUT("/items/:id", cartHandler.UpdateCartItem)
-			cart.DELETE("/items/:id", cartHandler.RemoveFromCart)
-			cart.DELETE("/clear", cartHandler.ClearCart)
-		}
+	// Staff routes
+	staffGroup := protected.Group("/staff")
+	staffGroup.Use(middleware.StaffMiddleware())
+	{
+		staffGroup.POST("/pickups/confirm", pickupPointHandler.ConfirmCollection)
+	}
+
+	// Vendor routes, scoped to the vendor's own products and sub-orders. Admins see everything.
+	vendorGroup := protected.Group("/vendor")
+	vendorGroup.Use(middleware.AdminMiddleware("vendor"))
+	{
+		vendorGroup.GET("/products", adminProductHandler.GetProducts)
+		vendorGroup.POST("/products", adminProductHandler.CreateProduct)
+		vendorGroup.PUT("/products/:id", adminProductHandler.UpdateProduct)
+		vendorGroup.DELETE("/products/:id", adminProductHandler.DeleteProduct)
+		vendorGroup.POST("/products/upload-image", adminProductHandler.UploadProductImage)
+		vendorGroup.GET("/products/stats", adminProductHandler.GetProductStats)
+		vendorGroup.GET("/products/:id/variants", adminProductHandler.GetVariants)
+		vendorGroup.POST("/products/:id/variants", adminProductHandler.CreateVariant)
+		vendorGroup.PUT("/products/:id/variants/:variantId", adminProductHandler.UpdateVariant)
+		vendorGroup.DELETE("/products/:id/variants/:variantId", adminProductHandler.DeleteVariant)
+		vendorGroup.GET("/option-types", adminProductHandler.GetOptionTypes)
+		vendorGroup.GET("/orders", vendorHandler.GetVendorOrders)
+		vendorGroup.PUT("/orders/:id/status", vendorHandler.UpdateVendorOrderStatus)
+		vendorGroup.GET("/payouts", vendorHandler.GetPayoutReport)
 	}
 
 	// Admin routes
@@ -183,9 +347,49 @@ func main() {
 	{
 		// Dashboard routes
 		adminGroup.GET("/stats", adminDashboardHandler.GetStats)
+		adminGroup.GET("/abandoned-carts/stats", adminDashboardHandler.GetAbandonedCartStats)
 		adminGroup.GET("/orders", adminDashboardHandler.GetOrders)
+		adminGroup.GET("/orders/export", adminDashboardHandler.ExportOrders)
 		adminGroup.GET("/users", adminDashboardHandler.GetUsers)
+		adminGroup.PUT("/users/:id/role", adminDashboardHandler.UpdateUserRole)
 		adminGroup.PUT("/orders/:id/status", adminDashboardHandler.UpdateOrderStatus)
+
+		// Shipment routes
+		adminGroup.POST("/orders/:id/shipments", shipmentHandler.CreateShipment)
+		adminGroup.PUT("/shipments/:id/status", shipmentHandler.UpdateShipmentStatus)
+		adminGroup.POST("/shipments/:id/consignment", shipmentHandler.BookConsignment)
+		adminGroup.POST("/shipments/:id/tracking/refresh", shipmentHandler.RefreshTracking)
+
+		// Delivery slot management routes
+		adminGroup.GET("/delivery-slots", deliverySlotHandler.GetSlots)
+		adminGroup.POST("/delivery-slots", deliverySlotHandler.CreateSlot)
+		adminGroup.PUT("/delivery-slots/:id", deliverySlotHandler.UpdateSlot)
+		adminGroup.DELETE("/delivery-slots/:id", deliverySlotHandler.DeleteSlot)
+		adminGroup.GET("/delivery-slots/:id/orders", deliverySlotHandler.GetSlotOrders)
+
+		// Pickup point management routes
+		adminGroup.GET("/pickup-points", pickupPointHandler.GetAllPickupPoints)
+		adminGroup.POST("/pickup-points", pickupPointHandler.CreatePickupPoint)
+		adminGroup.PUT("/pickup-points/:id", pickupPointHandler.UpdatePickupPoint)
+		adminGroup.DELETE("/pickup-points/:id", pickupPointHandler.DeletePickupPoint)
+
+		// Vendor management routes
+		adminGroup.GET("/vendors", vendorHandler.GetVendors)
+		adminGroup.POST("/vendors", vendorHandler.CreateVendor)
+		adminGroup.PUT("/vendors/:id", vendorHandler.UpdateVendor)
+		adminGroup.DELETE("/vendors/:id", vendorHandler.DeleteVendor)
+		adminGroup.POST("/vendors/:id/users", vendorHandler.AssignVendorUser)
+		adminGroup.GET("/vendors/payouts", vendorHandler.GetPayoutReport)
+		adminGroup.GET("/vendor-orders", vendorHandler.GetVendorOrders)
+
+		// Subscription management routes
+		adminGroup.GET("/subscriptions", subscriptionHandler.GetSubscriptions)
+
+		// Return management routes
+		adminGroup.GET("/returns", returnHandler.GetReturns)
+		adminGroup.PUT("/returns/:id/approve", returnHandler.ApproveReturn)
+		adminGroup.PUT("/returns/:id/reject", returnHandler.RejectReturn)
+		adminGroup.PUT("/returns/:id/complete", returnHandler.CompleteReturn)
 		
 		// Product management routes
 		adminGroup.GET("/products", adminProductHandler.GetProducts)
@@ -195,11 +399,49 @@ func main() {
 		adminGroup.POST("/products/upload-image", adminProductHandler.UploadProductImage)
 		adminGroup.GET("/products/stats", adminProductHandler.GetProductStats)
 		adminGroup.PUT("/products/:id/featured", adminProductHandler.ToggleFeatured)
+		adminGroup.GET("/products/:id/variants", adminProductHandler.GetVariants)
+		adminGroup.POST("/products/:id/variants", adminProductHandler.CreateVariant)
+		adminGroup.PUT("/products/:id/variants/:variantId", adminProductHandler.UpdateVariant)
+		adminGroup.DELETE("/products/:id/variants/:variantId", adminProductHandler.DeleteVariant)
+		
+		// Category tree management
+		adminGroup.GET("/categories", categoryHandler.GetAllCategories)
+		adminGroup.POST("/categories", categoryHandler.CreateCategory)
+		adminGroup.PUT("/categories/:id", categoryHandler.UpdateCategory)
+		adminGroup.PUT("/categories/:id/move", categoryHandler.MoveCategory)
+		adminGroup.DELETE("/categories/:id", categoryHandler.DeleteCategory)
+		
+		// Option types, such as size or colour, that variants are made of
+		adminGroup.GET("/option-types", adminProductHandler.GetOptionTypes)
+		adminGroup.POST("/option-types", adminProductHandler.CreateOptionType)
+		adminGroup.PUT("/option-types/:id", adminProductHandler.UpdateOptionType)
+		adminGroup.DELETE("/option-types/:id", adminProductHandler.DeleteOptionType)
+		adminGroup.POST("/option-types/:id/values", adminProductHandler.AddOptionValue)
+		adminGroup.DELETE("/option-types/:id/values/:valueId", adminProductHandler.DeleteOptionValue)
+
+		// Backorder and pre-order routes
+		adminGroup.GET("/backorders", backorderHandler.GetBackorders)
+		adminGroup.POST("/products/:id/allocate-backorders", backorderHandler.AllocateBackorders)
 	}
 
 	// Create default admin user if not exists
 	createDefaultAdmin(db)
 
+	// Place subscription orders in the background
+	subscriptionHandler.StartScheduler(time.Duration(cfg.SubscriptionIntervalMinutes) * time.Minute)
+
+	// Poll couriers for shipments in transit
+	shipmentHandler.StartTrackingPoller(time.Duration(cfg.CarrierPollMinutes) * time.Minute)
+
+	// Release stock held by abandoned carts
+	cartHandler.StartReservationSweeper(time.Minute)
+
+	// Delete guest carts nobody has used for a month
+	cartHandler.StartGuestCartPurge(24 * time.Hour)
+
+	// Remind customers about carts they have left idle
+	abandonedCartHandler.StartScheduler(time.Duration(cfg.AbandonedCartCheckMinutes) * time.Minute)
+
 	// Start server
 	log.Printf("Server starting on port %s", cfg.Port)
 	if err := r.Run(":" + cfg.Port); err != nil {
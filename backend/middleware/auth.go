@@ -1,6 +1,7 @@
 package middleware
 
 import (
+	"errors"
 	"net/http"
 	"strings"
 	"time"
@@ -16,6 +17,27 @@ type Claims struct {
 	jwt.RegisteredClaims
 }
 
+// CartClaims identify an anonymous shopper's cart
+type CartClaims struct {
+	CartKey string `json:"cart_key"`
+	jwt.RegisteredClaims
+}
+
+// CartTokenHeader carries the signed token of a guest cart in both directions
+const CartTokenHeader = "X-Cart-Token"
+
+// GuestCartTTL is how long guest carts are kept without activity
+const GuestCartTTL = 30 * 24 * time.Hour
+
+// Token audiences keep a guest cart token from being accepted as a login and the other way round.
+// Login tokens issued before audiences were added carry none and are still accepted.
+const (
+	sessionAudience = "session"
+	cartAudience    = "cart"
+)
+
+var ErrInvalidCartToken = errors.New("invalid cart token")
+
 func AuthMiddleware(jwtSecret string) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		authHeader := c.GetHeader("Authorization")
@@ -43,7 +65,7 @@ func AuthMiddleware(jwtSecret string) gin.HandlerFunc {
 		}
 
 		claims, ok := token.Claims.(*Claims)
-		if !ok {
+		if !ok || claims.UserID == 0 || hasAudience(claims.Audience, cartAudience) {
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token claims"})
 			c.Abort()
 			return
@@ -56,10 +78,29 @@ func AuthMiddleware(jwtSecret string) gin.HandlerFunc {
 	}
 }
 
-func AdminMiddleware() gin.HandlerFunc {
+// OptionalAuthMiddleware identifies signed-in users like AuthMiddleware but lets anonymous
+// requests through, e.g. for guest carts. A token that is present but invalid is still rejected.
+func OptionalAuthMiddleware(jwtSecret string) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		role, exists := c.Get("user_role")
-		if !exists || role != "admin" {
+		if c.GetHeader("Authorization") == "" {
+			c.Next()
+			return
+		}
+		AuthMiddleware(jwtSecret)(c)
+	}
+}
+
+// AdminMiddleware admits admins plus any of the extra roles given, e.g. vendors managing
+// their own part of the catalogue
+func AdminMiddleware(roles ...string) gin.HandlerFunc {
+	allowed := map[string]bool{"admin": true}
+	for _, role := range roles {
+		allowed[role] = true
+	}
+
+	return func(c *gin.Context) {
+		role := c.GetString("user_role")
+		if !allowed[role] {
 			c.JSON(http.StatusForbidden, gin.H{"error": "Admin access required"})
 			c.Abort()
 			return
@@ -68,12 +109,26 @@ func AdminMiddleware() gin.HandlerFunc {
 	}
 }
 
+// StaffMiddleware admits staff working at pickup points as well as admins
+func StaffMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		role, exists := c.Get("user_role")
+		if !exists || (role != "staff" && role != "admin") {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Staff access required"})
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
 func GenerateToken(userID uint, email, role, jwtSecret string) (string, error) {
 	claims := &Claims{
 		UserID: userID,
 		Email:  email,
 		Role:   role,
 		RegisteredClaims: jwt.RegisteredClaims{
+			Audience:  jwt.ClaimStrings{sessionAudience},
 			ExpiresAt: jwt.NewNumericDate(time.Now().Add(24 * time.Hour)),
 			IssuedAt:  jwt.NewNumericDate(time.Now()),
 		},
@@ -83,11 +138,54 @@ func GenerateToken(userID uint, email, role, jwtSecret string) (string, error) {
 	return token.SignedString([]byte(jwtSecret))
 }
 
+// GenerateCartToken signs a guest cart key. Every use of the cart hands out a fresh token so
+// active carts never expire.
+func GenerateCartToken(cartKey, jwtSecret string) (string, error) {
+	claims := &CartClaims{
+		CartKey: cartKey,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Audience:  jwt.ClaimStrings{cartAudience},
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(GuestCartTTL)),
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+		},
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	return token.SignedString([]byte(jwtSecret))
+}
+
+// ParseCartToken verifies a guest cart token and returns its cart key
+func ParseCartToken(tokenString, jwtSecret string) (string, error) {
+	token, err := jwt.ParseWithClaims(tokenString, &CartClaims{}, func(token *jwt.Token) (interface{}, error) {
+		return []byte(jwtSecret), nil
+	}, jwt.WithAudience(cartAudience))
+	if err != nil || !token.Valid {
+		return "", ErrInvalidCartToken
+	}
+
+	claims, ok := token.Claims.(*CartClaims)
+	if !ok || claims.CartKey == "" {
+		return "", ErrInvalidCartToken
+	}
+	return claims.CartKey, nil
+}
+
+// hasAudience reports whether a token's audience includes the given one
+func hasAudience(audience jwt.ClaimStrings, want string) bool {
+	for _, aud := range audience {
+		if aud == want {
+			return true
+		}
+	}
+	return false
+}
+
 func CORSMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		c.Writer.Header().Set("Access-Control-Allow-Origin", "*")
 		c.Writer.Header().Set("Access-Control-Allow-Credentials", "true")
-		c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, accept, origin, Cache-Control, X-Requested-With")
+		c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, accept, origin, Cache-Control, X-Requested-With, Idempotency-Key, X-Cart-Token")
+		c.Writer.Header().Set("Access-Control-Expose-Headers", "X-Cart-Token")
 		c.Writer.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS, GET, PUT, DELETE")
 
 		if c.Request.Method == "OPTIONS" {
@@ -0,0 +1,131 @@
+package middleware
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/yourname/sakifarm-ecommerce/models"
+	"gorm.io/gorm"
+)
+
+const IdempotencyHeader = "Idempotency-Key"
+
+// responseRecorder keeps a copy of the response body so it can be stored for replays
+type responseRecorder struct {
+	gin.ResponseWriter
+	body *bytes.Buffer
+}
+
+func (w *responseRecorder) Write(b []byte) (int, error) {
+	w.body.Write(b)
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *responseRecorder) WriteString(s string) (int, error) {
+	w.body.WriteString(s)
+	return w.ResponseWriter.WriteString(s)
+}
+
+// IdempotencyMiddleware replays the stored response when a request is retried with the
+// same Idempotency-Key, and rejects a reused key whose payload differs from the original.
+// Requests without the header are passed through unchanged. Must run after AuthMiddleware.
+func IdempotencyMiddleware(db *gorm.DB, ttl time.Duration) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := c.GetHeader(IdempotencyHeader)
+		if key == "" {
+			c.Next()
+			return
+		}
+
+		if len(key) > 255 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Idempotency-Key must be at most 255 characters"})
+			c.Abort()
+			return
+		}
+
+		userID := c.GetUint("user_id")
+
+		body, err := io.ReadAll(c.Request.Body)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to read request body"})
+			c.Abort()
+			return
+		}
+		c.Request.Body = io.NopCloser(bytes.NewBuffer(body))
+
+		hash := sha256.Sum256(append([]byte(c.Request.Method+" "+c.FullPath()+"\n"), body...))
+		requestHash := hex.EncodeToString(hash[:])
+
+		// Drop an expired record so the key can be used again
+		db.Where("user_id = ? AND key = ? AND expires_at <= ?", userID, key, time.Now()).
+			Delete(&models.IdempotencyKey{})
+
+		record := models.IdempotencyKey{
+			UserID:      userID,
+			Key:         key,
+			Method:      c.Request.Method,
+			Path:        c.FullPath(),
+			RequestHash: requestHash,
+			ExpiresAt:   time.Now().Add(ttl),
+		}
+
+		// The unique index on (user_id, key) makes the insert the lock: only one request wins
+		if err := db.Create(&record).Error; err != nil {
+			var existing models.IdempotencyKey
+			if err := db.Where("user_id = ? AND key = ?", userID, key).First(&existing).Error; err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check idempotency key"})
+				c.Abort()
+				return
+			}
+
+			if existing.RequestHash != requestHash {
+				c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "Idempotency-Key was already used with a different request"})
+				c.Abort()
+				return
+			}
+
+			if existing.ResponseStatus == 0 {
+				c.JSON(http.StatusConflict, gin.H{"error": "A request with this Idempotency-Key is still being processed"})
+				c.Abort()
+				return
+			}
+
+			c.Header("Idempotent-Replayed", "true")
+			c.Data(existing.ResponseStatus, "application/json; charset=utf-8", []byte(existing.ResponseBody))
+			c.Abort()
+			return
+		}
+
+		recorder := &responseRecorder{ResponseWriter: c.Writer, body: &bytes.Buffer{}}
+		c.Writer = recorder
+
+		// A handler that panicked never finished; free the key so the request can be retried
+		defer func() {
+			if recovered := recover(); recovered != nil {
+				db.Delete(&record)
+				panic(recovered)
+			}
+		}()
+
+		c.Next()
+
+		// Server errors are not stored so the request can be retried once the problem is
+		// fixed. Client errors are replayed like successes.
+		if recorder.Status() >= http.StatusInternalServerError {
+			db.Delete(&record)
+			return
+		}
+		if err := db.Model(&record).Updates(map[string]interface{}{
+			"response_status": recorder.Status(),
+			"response_body":   recorder.body.String(),
+		}).Error; err != nil {
+			// Without a stored response the key would answer 409 until it expires
+			db.Delete(&record)
+		}
+	}
+}
@@ -14,7 +14,8 @@ type User struct {
 	Phone       string    `gorm:"unique" json:"phone" validate:"required,min=10"`
 	FirstName   string    `json:"first_name" validate:"required"`
 	LastName    string    `json:"last_name" validate:"required"`
-	Role        string    `gorm:"default:customer" json:"role"` // admin, customer
+	Role        string    `gorm:"default:customer" json:"role"` // admin, staff, vendor, customer
+	VendorID    *uint     `gorm:"index" json:"vendor_id"` // set for vendor users
 	IsVerified  bool      `gorm:"default:false" json:"is_verified"`
 	Avatar      string    `json:"avatar"`
 	Address     string    `json:"address"`
@@ -31,7 +32,8 @@ type Product struct {
 	Name        string    `gorm:"not null" json:"name" validate:"required,min=2"`
 	Description string    `json:"description"`
 	Price       float64   `gorm:"not null" json:"price" validate:"required,min=0"`
-	Category    string    `json:"category" validate:"required"`
+	Category    string    `json:"category" validate:"required"` // name of the linked category, kept for display
+	CategoryID  *uint     `gorm:"index" json:"category_id"`
 	Brand       string    `json:"brand"`
 	SKU         string    `gorm:"unique" json:"sku"`
 	Stock       int       `gorm:"default:0" json:"stock" validate:"min=0"`
@@ -42,9 +44,17 @@ type Product struct {
 	Tags        string    `json:"tags"`
 	IsImported  bool      `gorm:"default:false" json:"is_imported"`
 	ShippingFee float64   `gorm:"default:0" json:"shipping_fee"`
+	VendorID    *uint     `gorm:"index" json:"vendor_id"` // nil for the store's own products
+	AvailableStock *int   `gorm:"-" json:"available_stock,omitempty"` // stock less cart reservations, where computed
+	Vendor      *Vendor   `gorm:"foreignKey:VendorID" json:"vendor,omitempty"`
 	Featured    bool      `gorm:"default:false" json:"featured"`
 	Rating      float64   `gorm:"default:0" json:"rating"`
 	ReviewCount int       `gorm:"default:0" json:"review_count"`
+	BackorderMode       string     `gorm:"default:none" json:"backorder_mode"` // none, backorder, preorder
+	ExpectedAvailableAt *time.Time `json:"expected_available_at"`
+	BackorderLimit      int        `gorm:"default:0" json:"backorder_limit"` // 0 means no cap
+	BackorderedQty      int        `gorm:"default:0" json:"backordered_qty"` // units ordered but waiting for stock
+	Variants    []ProductVariant `gorm:"foreignKey:ProductID" json:"variants,omitempty"`
 	CreatedAt   time.Time `json:"created_at"`
 	UpdatedAt   time.Time `json:"updated_at"`
 	DeletedAt   gorm.DeletedAt `gorm:"index" json:"-"`
@@ -54,31 +64,84 @@ type Product struct {
 type ProductImage struct {
 	ID        uint   `gorm:"primaryKey" json:"id"`
 	ProductID uint   `json:"product_id"`
+	VariantID *uint  `gorm:"index" json:"variant_id"` // set for images of one variant
 	URL       string `json:"url"`
 	AltText   string `json:"alt_text"`
 	IsPrimary bool   `gorm:"default:false" json:"is_primary"`
 	CreatedAt time.Time `json:"created_at"`
 }
 
+// OptionType is a way products vary, such as size or colour
+type OptionType struct {
+	ID        uint          `gorm:"primaryKey" json:"id"`
+	Name      string        `gorm:"unique;not null" json:"name"`
+	Values    []OptionValue `gorm:"foreignKey:OptionTypeID" json:"values"`
+	CreatedAt time.Time     `json:"created_at"`
+	UpdatedAt time.Time     `json:"updated_at"`
+}
+
+// OptionValue is one choice of an option type, such as 5kg
+type OptionValue struct {
+	ID           uint        `gorm:"primaryKey" json:"id"`
+	OptionTypeID uint        `gorm:"index" json:"option_type_id"`
+	OptionType   *OptionType `gorm:"foreignKey:OptionTypeID" json:"option_type,omitempty"`
+	Value        string      `gorm:"not null" json:"value"`
+	Position     int         `gorm:"default:0" json:"position"`
+}
+
+// ProductVariant is a sellable version of a product with its own SKU, price and stock. The
+// product's stock is kept at the total of its variants' stock.
+type ProductVariant struct {
+	ID           uint           `gorm:"primaryKey" json:"id"`
+	ProductID    uint           `gorm:"index" json:"product_id"`
+	SKU          string         `gorm:"unique" json:"sku"`
+	Name         string         `json:"name"` // the option values, e.g. "5kg / Crate"
+	Price        float64        `gorm:"not null" json:"price"`
+	Stock        int            `gorm:"default:0" json:"stock"`
+	OptionValues []OptionValue  `gorm:"many2many:variant_option_values" json:"option_values"`
+	Images       []ProductImage `gorm:"foreignKey:VariantID" json:"images"`
+	IsActive     bool           `gorm:"default:true" json:"is_active"`
+	Position     int            `gorm:"default:0" json:"position"`
+	AvailableStock *int         `gorm:"-" json:"available_stock,omitempty"` // stock less cart reservations, where computed
+	CreatedAt    time.Time      `json:"created_at"`
+	UpdatedAt    time.Time      `json:"updated_at"`
+	DeletedAt    gorm.DeletedAt `gorm:"index" json:"-"`
+}
+
 // Order represents an order in the system
 type Order struct {
 	ID              uint        `gorm:"primaryKey" json:"id"`
-	UserID          uint        `json:"user_id"`
+	UserID          *uint       `json:"user_id"` // nil for guest orders
 	User            User        `gorm:"foreignKey:UserID" json:"user"`
+	GuestEmail      string      `json:"guest_email,omitempty"`
+	GuestPhone      string      `gorm:"index" json:"guest_phone,omitempty"`
+	GuestVerified   bool        `gorm:"default:false" json:"guest_verified"`
 	OrderNumber     string      `gorm:"unique;not null" json:"order_number"`
-	Status          string      `gorm:"default:pending" json:"status"` // pending, confirmed, processing, shipped, delivered, cancelled
-	PaymentStatus   string      `gorm:"default:pending" json:"payment_status"` // pending, paid, failed, refunded
+	Status          string      `gorm:"default:pending" json:"status"` // pending, confirmed, processing, partially_shipped, shipped, delivered, cancelled
+	PaymentStatus   string      `gorm:"default:pending" json:"payment_status"` // pending, paid, partially_paid, failed, refunded
 	PaymentMethod   string      `json:"payment_method"` // mpesa, airtel, card
 	PaymentRef      string      `json:"payment_ref"`
 	TotalAmount     float64     `json:"total_amount"`
 	ShippingAmount  float64     `json:"shipping_amount"`
 	TaxAmount       float64     `json:"tax_amount"`
 	DiscountAmount  float64     `json:"discount_amount"`
+	CouponCode      string      `json:"coupon_code"`
 	Items           []OrderItem `gorm:"foreignKey:OrderID" json:"items"`
 	ShippingAddress Address     `gorm:"embedded;embeddedPrefix:shipping_" json:"shipping_address"`
 	BillingAddress  Address     `gorm:"embedded;embeddedPrefix:billing_" json:"billing_address"`
 	TrackingNumber  string      `json:"tracking_number"`
 	DeliveredAt     *time.Time  `json:"delivered_at"`
+	ReturnStatus    string      `json:"return_status"` // "", return_requested, partially_returned, returned
+	Returns         []ReturnRequest `gorm:"foreignKey:OrderID" json:"returns,omitempty"`
+	Shipments       []Shipment  `gorm:"foreignKey:OrderID" json:"shipments,omitempty"`
+	DeliverySlotID  *uint       `gorm:"index" json:"delivery_slot_id"`
+	DeliverySlot    *DeliverySlot `gorm:"foreignKey:DeliverySlotID" json:"delivery_slot,omitempty"`
+	FulfilmentType  string      `gorm:"default:delivery" json:"fulfilment_type"` // delivery, pickup
+	PickupPointID   *uint       `gorm:"index" json:"pickup_point_id"`
+	PickupPoint     *PickupPoint `gorm:"foreignKey:PickupPointID" json:"pickup_point,omitempty"`
+	PickupCode      string      `gorm:"index" json:"-"` // sent to the customer by SMS, and shown only to the order's owner
+	CollectedAt     *time.Time  `json:"collected_at"`
+	VendorOrders    []VendorOrder `gorm:"foreignKey:OrderID" json:"vendor_orders,omitempty"`
 	Notes           string      `json:"notes"`
 	CreatedAt       time.Time   `json:"created_at"`
 	UpdatedAt       time.Time   `json:"updated_at"`
@@ -91,9 +154,15 @@ type OrderItem struct {
 	OrderID   uint    `json:"order_id"`
 	ProductID uint    `json:"product_id"`
 	Product   Product `gorm:"foreignKey:ProductID" json:"product"`
+	VariantID *uint   `gorm:"index" json:"variant_id"`
+	Variant   *ProductVariant `gorm:"foreignKey:VariantID" json:"variant,omitempty"`
+	VariantName string `json:"variant_name"` // kept so receipts show what was bought if the variant changes
 	Quantity  int     `json:"quantity" validate:"required,min=1"`
 	Price     float64 `json:"price"`
 	Total     float64 `json:"total"`
+	Status    string  `gorm:"default:allocated" json:"status"` // allocated, backordered, preordered, cancelled
+	AllocatedAt *time.Time `json:"allocated_at"`
+	VendorOrderID *uint `gorm:"index" json:"vendor_order_id"`
 }
 
 // Address represents shipping/billing addresses
@@ -113,7 +182,8 @@ type Address struct {
 // Cart represents shopping cart
 type Cart struct {
 	ID        uint       `gorm:"primaryKey" json:"id"`
-	UserID    uint       `json:"user_id"`
+	UserID    *uint      `gorm:"index" json:"user_id"` // nil for guest carts
+	GuestKey  string     `gorm:"index" json:"-"`       // identifies a guest cart, handed out as a signed token
 	Items     []CartItem `gorm:"foreignKey:CartID" json:"items"`
 	CreatedAt time.Time  `json:"created_at"`
 	UpdatedAt time.Time  `json:"updated_at"`
@@ -125,17 +195,74 @@ type CartItem struct {
 	CartID    uint    `json:"cart_id"`
 	ProductID uint    `json:"product_id"`
 	Product   Product `gorm:"foreignKey:ProductID" json:"product"`
+	VariantID *uint   `gorm:"index" json:"variant_id"`
+	Variant   *ProductVariant `gorm:"foreignKey:VariantID" json:"variant,omitempty"`
 	Quantity  int     `json:"quantity" validate:"required,min=1"`
+	PriceAtAdd float64 `json:"price_at_add"` // product price when the line was added, 0 for older lines
 	CreatedAt time.Time `json:"created_at"`
 	UpdatedAt time.Time `json:"updated_at"`
 }
 
+// InventoryReservation holds stock for a cart line until it expires, so shoppers who reach
+// checkout find the items still there. Expired rows no longer count and are swept away.
+type InventoryReservation struct {
+	ID         uint      `gorm:"primaryKey" json:"id"`
+	CartID     uint      `gorm:"index" json:"cart_id"`
+	CartItemID uint      `gorm:"uniqueIndex" json:"cart_item_id"`
+	ProductID  uint      `gorm:"index:idx_reservation_product_expiry" json:"product_id"`
+	VariantID  *uint     `gorm:"index" json:"variant_id"`
+	Quantity   int       `json:"quantity"`
+	ExpiresAt  time.Time `gorm:"index:idx_reservation_product_expiry;index" json:"expires_at"`
+	CreatedAt  time.Time `json:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+// CartShare is a snapshot of a cart that others can open by its short code and copy into
+// their own cart. Later changes to the original cart do not affect it.
+type CartShare struct {
+	ID          uint            `gorm:"primaryKey" json:"id"`
+	Code        string          `gorm:"uniqueIndex;not null" json:"code"`
+	UserID      *uint           `gorm:"index" json:"user_id"` // nil when shared by a guest
+	Items       []CartShareItem `gorm:"foreignKey:CartShareID" json:"items"`
+	ImportCount int             `gorm:"default:0" json:"import_count"`
+	ExpiresAt   time.Time       `json:"expires_at"`
+	CreatedAt   time.Time       `json:"created_at"`
+}
+
+// CartShareItem is a line of a shared cart
+type CartShareItem struct {
+	ID          uint    `gorm:"primaryKey" json:"id"`
+	CartShareID uint    `gorm:"index" json:"cart_share_id"`
+	ProductID   uint    `json:"product_id"`
+	Product     Product `gorm:"foreignKey:ProductID" json:"product"`
+	VariantID   *uint   `json:"variant_id"`
+	Quantity    int     `json:"quantity"`
+}
+
+// CartReminder records a reminder sent about an abandoned cart. Reminders about the same
+// stretch of inactivity share the cart's last activity time, so each stage goes out once.
+type CartReminder struct {
+	ID               uint       `gorm:"primaryKey" json:"id"`
+	CartID           uint       `gorm:"index" json:"cart_id"`
+	UserID           uint       `gorm:"index" json:"user_id"`
+	Stage            int        `json:"stage"` // index into the configured reminder intervals
+	CartActivityAt   time.Time  `json:"cart_activity_at"`
+	Channels         string     `json:"channels"` // comma separated: email, sms
+	CouponCode       string     `json:"coupon_code"`
+	SentAt           time.Time  `gorm:"index" json:"sent_at"`
+	RecoveredOrderID *uint      `json:"recovered_order_id"`
+	RecoveredAt      *time.Time `json:"recovered_at"`
+	CreatedAt        time.Time  `json:"created_at"`
+}
+
 // Wishlist represents user wishlist
 type Wishlist struct {
 	ID        uint    `gorm:"primaryKey" json:"id"`
-	UserID    uint    `json:"user_id"`
-	ProductID uint    `json:"product_id"`
+	UserID    uint    `gorm:"uniqueIndex:idx_wishlist_user_product" json:"user_id"`
+	ProductID uint    `gorm:"uniqueIndex:idx_wishlist_user_product;index" json:"product_id"`
 	Product   Product `gorm:"foreignKey:ProductID" json:"product"`
+	NotifyPriceDrop   bool `gorm:"default:true" json:"notify_price_drop"`
+	NotifyBackInStock bool `gorm:"default:true" json:"notify_back_in_stock"`
 	CreatedAt time.Time `json:"created_at"`
 }
 
@@ -164,6 +291,7 @@ type OTP struct {
 	Purpose   string    `json:"purpose"` // login, registration, password_reset
 	ExpiresAt time.Time `json:"expires_at"`
 	Used      bool      `gorm:"default:false" json:"used"`
+	Attempts  int       `gorm:"default:0" json:"-"` // wrong codes entered against this one
 	CreatedAt time.Time `json:"created_at"`
 }
 
@@ -174,7 +302,8 @@ type Payment struct {
 	PaymentMethod   string    `json:"payment_method"` // mpesa, airtel
 	Amount          float64   `json:"amount"`
 	Currency        string    `gorm:"default:KES" json:"currency"`
-	Status          string    `json:"status"` // pending, success, failed
+	Status          string    `json:"status"` // pending, success, failed, superseded
+	Purpose         string    `gorm:"default:order" json:"purpose"` // order, balance, subscription
 	TransactionID   string    `json:"transaction_id"`
 	ExternalRef     string    `json:"external_ref"`
 	PhoneNumber     string    `json:"phone_number"`
@@ -189,7 +318,7 @@ type Notification struct {
 	UserID    uint      `json:"user_id"`
 	Title     string    `json:"title"`
 	Message   string    `json:"message"`
-	Type      string    `json:"type"` // order, payment, delivery, promotion
+	Type      string    `json:"type"` // order, payment, delivery, promotion, wishlist
 	IsRead    bool      `gorm:"default:false" json:"is_read"`
 	Data      string    `json:"data"` // JSON data for additional info
 	CreatedAt time.Time `json:"created_at"`
@@ -201,10 +330,12 @@ type Category struct {
 	Name        string    `gorm:"unique;not null" json:"name"`
 	Description string    `json:"description"`
 	Image       string    `json:"image"`
-	ParentID    *uint     `json:"parent_id"`
-	Parent      *Category `gorm:"foreignKey:ParentID" json:"parent"`
+	ParentID    *uint     `gorm:"index" json:"parent_id"`
+	Parent      *Category `gorm:"foreignKey:ParentID" json:"parent,omitempty"`
 	Children    []Category `gorm:"foreignKey:ParentID" json:"children"`
+	Position    int       `gorm:"default:0" json:"position"` // order among its siblings
 	IsActive    bool      `gorm:"default:true" json:"is_active"`
+	ProductCount int64    `gorm:"-" json:"product_count"` // products in it and its subcategories, where computed
 	CreatedAt   time.Time `json:"created_at"`
 	UpdatedAt   time.Time `json:"updated_at"`
 }
@@ -234,3 +365,233 @@ type Coupon struct {
 	CreatedAt   time.Time `json:"created_at"`
 	UpdatedAt   time.Time `json:"updated_at"`
 }
+
+// IdempotencyKey stores the outcome of a request made with an Idempotency-Key header
+type IdempotencyKey struct {
+	ID             uint      `gorm:"primaryKey" json:"id"`
+	UserID         uint      `gorm:"uniqueIndex:idx_idempotency_user_key" json:"user_id"`
+	Key            string    `gorm:"uniqueIndex:idx_idempotency_user_key;not null" json:"key"`
+	Method         string    `json:"method"`
+	Path           string    `json:"path"`
+	RequestHash    string    `json:"request_hash"`
+	ResponseStatus int       `gorm:"default:0" json:"response_status"` // 0 while the original request is in flight
+	ResponseBody   string    `json:"response_body"`
+	ExpiresAt      time.Time `gorm:"index" json:"expires_at"`
+	CreatedAt      time.Time `json:"created_at"`
+	UpdatedAt      time.Time `json:"updated_at"`
+}
+
+// ReturnRequest represents a customer request to return delivered items (RMA)
+type ReturnRequest struct {
+	ID           uint         `gorm:"primaryKey" json:"id"`
+	RMANumber    string       `gorm:"unique;not null" json:"rma_number"`
+	OrderID      uint         `gorm:"index" json:"order_id"`
+	UserID       uint         `gorm:"index" json:"user_id"`
+	User         User         `gorm:"foreignKey:UserID" json:"user,omitempty"`
+	Status       string       `gorm:"default:requested" json:"status"` // requested, approved, rejected, completed
+	Reason       string       `json:"reason"`
+	Items        []ReturnItem `gorm:"foreignKey:ReturnRequestID" json:"items"`
+	AdminNotes   string       `json:"admin_notes"`
+	Restocked    bool         `gorm:"default:false" json:"restocked"`
+	Resolution   string       `json:"resolution"` // refund, store_credit
+	RefundAmount float64      `json:"refund_amount"`
+	PaymentID    *uint        `json:"payment_id,omitempty"` // refund payment record when resolution is refund
+	ResolvedAt   *time.Time   `json:"resolved_at"`
+	CreatedAt    time.Time    `json:"created_at"`
+	UpdatedAt    time.Time    `json:"updated_at"`
+}
+
+// ReturnItem represents a single order item being returned
+type ReturnItem struct {
+	ID              uint      `gorm:"primaryKey" json:"id"`
+	ReturnRequestID uint      `gorm:"index" json:"return_request_id"`
+	OrderItemID     uint      `json:"order_item_id"`
+	OrderItem       OrderItem `gorm:"foreignKey:OrderItemID" json:"order_item"`
+	Quantity        int       `json:"quantity"`
+	Reason          string    `json:"reason"`
+	Photos          string    `json:"photos"` // comma-separated image URLs
+}
+
+// StoreCredit represents a store credit ledger entry; a user's balance is the sum of amounts
+type StoreCredit struct {
+	ID          uint      `gorm:"primaryKey" json:"id"`
+	UserID      uint      `gorm:"index" json:"user_id"`
+	Amount      float64   `json:"amount"` // positive when issued, negative when spent
+	Source      string    `json:"source"` // return, order_edit, order_payment, vendor_cancellation, adjustment
+	ReferenceID uint      `json:"reference_id"`
+	Description string    `json:"description"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// Shipment represents a parcel carrying some or all of an order's items
+type Shipment struct {
+	ID             uint           `gorm:"primaryKey" json:"id"`
+	OrderID        uint           `gorm:"index" json:"order_id"`
+	Carrier        string         `json:"carrier"`
+	TrackingNumber string         `gorm:"index" json:"tracking_number"`
+	Status         string         `gorm:"default:pending" json:"status"` // pending, shipped, delivered
+	Items          []ShipmentItem `gorm:"foreignKey:ShipmentID" json:"items"`
+	LabelURL       string         `json:"label_url,omitempty"`
+	TrackingEvents []TrackingEvent `gorm:"foreignKey:ShipmentID" json:"tracking_events,omitempty"`
+	Notes          string         `json:"notes"`
+	ShippedAt      *time.Time     `json:"shipped_at"`
+	DeliveredAt    *time.Time     `json:"delivered_at"`
+	CreatedAt      time.Time      `json:"created_at"`
+	UpdatedAt      time.Time      `json:"updated_at"`
+}
+
+// ShipmentItem represents the quantity of an order item packed in a shipment
+type ShipmentItem struct {
+	ID          uint      `gorm:"primaryKey" json:"id"`
+	ShipmentID  uint      `gorm:"index" json:"shipment_id"`
+	OrderItemID uint      `json:"order_item_id"`
+	OrderItem   OrderItem `gorm:"foreignKey:OrderItemID" json:"order_item"`
+	Quantity    int       `json:"quantity"`
+}
+
+// DeliverySlot represents a delivery window for a zone on a given day
+type DeliverySlot struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	Zone      string    `gorm:"not null;uniqueIndex:idx_delivery_slot_window" json:"zone"`
+	Date      time.Time `gorm:"type:date;not null;uniqueIndex:idx_delivery_slot_window" json:"date"`
+	StartTime string    `gorm:"not null;uniqueIndex:idx_delivery_slot_window" json:"start_time"` // HH:MM
+	EndTime   string    `gorm:"not null" json:"end_time"`                                        // HH:MM
+	Capacity  int       `json:"capacity"`
+	Booked    int       `gorm:"default:0" json:"booked"`
+	IsActive  bool      `gorm:"default:true" json:"is_active"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Vendor is a neighbouring farmer or business selling through the store
+type Vendor struct {
+	ID             uint      `gorm:"primaryKey" json:"id"`
+	Name           string    `gorm:"not null" json:"name"`
+	Email          string    `json:"email"`
+	Phone          string    `json:"phone"`
+	Address        string    `json:"address"`
+	KRAPIN         string    `json:"kra_pin"`
+	PayoutMethod   string    `json:"payout_method"`  // mpesa, bank
+	PayoutAccount  string    `json:"payout_account"` // phone or bank account number
+	CommissionRate float64   `json:"commission_rate"` // percentage of item sales kept by the store
+	IsActive       bool      `gorm:"default:true" json:"is_active"`
+	CreatedAt      time.Time `json:"created_at"`
+	UpdatedAt      time.Time `json:"updated_at"`
+}
+
+// VendorOrder is the part of a customer order fulfilled by one vendor. Orders are split
+// automatically at checkout; items of the store's own products share a sub-order with no vendor.
+type VendorOrder struct {
+	ID               uint        `gorm:"primaryKey" json:"id"`
+	OrderID          uint        `gorm:"index" json:"order_id"`
+	VendorID         *uint       `gorm:"index" json:"vendor_id"`
+	Vendor           *Vendor     `gorm:"foreignKey:VendorID" json:"vendor,omitempty"`
+	Status           string      `gorm:"default:pending" json:"status"` // pending, processing, shipped, delivered, cancelled
+	Items            []OrderItem `gorm:"foreignKey:VendorOrderID" json:"items,omitempty"`
+	Subtotal         float64     `json:"subtotal"`
+	CommissionRate   float64     `json:"commission_rate"`
+	CommissionAmount float64     `json:"commission_amount"`
+	VendorPayout     float64     `json:"vendor_payout"`
+	TrackingNumber   string      `json:"tracking_number"`
+	ShippedAt        *time.Time  `json:"shipped_at"`
+	DeliveredAt      *time.Time  `json:"delivered_at"`
+	CreatedAt        time.Time   `json:"created_at"`
+	UpdatedAt        time.Time   `json:"updated_at"`
+}
+
+// PickupPoint is a place where customers collect their orders, such as the farm gate or a partner shop
+type PickupPoint struct {
+	ID           uint      `gorm:"primaryKey" json:"id"`
+	Name         string    `gorm:"not null" json:"name"`
+	Address      string    `json:"address"`
+	City         string    `json:"city"`
+	Latitude     float64   `json:"latitude"`
+	Longitude    float64   `json:"longitude"`
+	Phone        string    `json:"phone"`
+	OpeningHours string    `json:"opening_hours"` // e.g. "Mon-Sat 08:00-18:00"
+	Capacity     int       `json:"capacity"`      // orders awaiting collection at once, 0 means no limit
+	IsActive     bool      `gorm:"default:true" json:"is_active"`
+	FailedCodeAttempts int        `gorm:"default:0" json:"-"` // wrong pickup codes entered since the last good one
+	CodeLockedUntil    *time.Time `json:"-"`                  // set when too many wrong codes were entered
+	CreatedAt    time.Time `json:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at"`
+}
+
+// Subscription represents a recurring order placed automatically on a schedule
+type Subscription struct {
+	ID              uint               `gorm:"primaryKey" json:"id"`
+	UserID          uint               `gorm:"index" json:"user_id"`
+	User            User               `gorm:"foreignKey:UserID" json:"user,omitempty"`
+	Status          string             `gorm:"default:active" json:"status"` // active, paused, past_due, cancelled
+	Frequency       string             `json:"frequency"`                    // weekly, biweekly, monthly
+	NextRunDate     time.Time          `gorm:"index" json:"next_run_date"`
+	Items           []SubscriptionItem `gorm:"foreignKey:SubscriptionID" json:"items"`
+	ShippingAddress Address            `gorm:"embedded;embeddedPrefix:shipping_" json:"shipping_address"`
+	BillingAddress  Address            `gorm:"embedded;embeddedPrefix:billing_" json:"billing_address"`
+	PaymentMethod   string             `json:"payment_method"` // mpesa, airtel
+	PhoneNumber     string             `json:"phone_number"`
+	PendingOrderID  *uint              `json:"pending_order_id"` // order of the current cycle awaiting payment
+	FailedAttempts  int                `gorm:"default:0" json:"failed_attempts"`
+	NextRetryAt     *time.Time         `json:"next_retry_at"`
+	LastRunAt       *time.Time         `json:"last_run_at"`
+	CreatedAt       time.Time          `json:"created_at"`
+	UpdatedAt       time.Time          `json:"updated_at"`
+}
+
+// SubscriptionItem represents a product included in every subscription cycle
+type SubscriptionItem struct {
+	ID             uint    `gorm:"primaryKey" json:"id"`
+	SubscriptionID uint    `gorm:"index" json:"subscription_id"`
+	ProductID      uint    `json:"product_id"`
+	Product        Product `gorm:"foreignKey:ProductID" json:"product"`
+	VariantID      *uint   `json:"variant_id"`
+	Quantity       int     `json:"quantity"`
+}
+
+// OrderNumberSequence counts the orders numbered on one day
+type OrderNumberSequence struct {
+	Day   string `gorm:"primaryKey;size:8" json:"day"` // YYYYMMDD
+	Value int    `gorm:"not null" json:"value"`
+}
+
+// TrackingEvent is a status update reported by a shipment's carrier
+type TrackingEvent struct {
+	ID             uint      `gorm:"primaryKey" json:"id"`
+	OrderID        uint      `gorm:"index" json:"order_id"`
+	ShipmentID     uint      `gorm:"uniqueIndex:idx_tracking_event" json:"shipment_id"`
+	Carrier        string    `json:"carrier"`
+	TrackingNumber string    `gorm:"index" json:"tracking_number"`
+	Status         string    `gorm:"uniqueIndex:idx_tracking_event" json:"status"` // picked_up, in_transit, out_for_delivery, delivered, failed_attempt, returned
+	Description    string    `json:"description"`
+	Location       string    `json:"location"`
+	OccurredAt     time.Time `gorm:"uniqueIndex:idx_tracking_event" json:"occurred_at"`
+	CreatedAt      time.Time `json:"created_at"`
+}
+
+// Invoice is a tax invoice issued for a paid order. Numbers come from a database
+// sequence so they are never reused, even when a transaction rolls back.
+type Invoice struct {
+	ID            uint      `gorm:"primaryKey" json:"id"`
+	InvoiceNumber string    `gorm:"unique;not null" json:"invoice_number"`
+	OrderID       uint      `gorm:"uniqueIndex;not null" json:"order_id"`
+	Subtotal      float64   `json:"subtotal"`
+	ShippingAmount float64  `json:"shipping_amount"`
+	DiscountAmount float64  `json:"discount_amount"`
+	TaxAmount     float64   `json:"tax_amount"`
+	TotalAmount   float64   `json:"total_amount"`
+	Lines         []InvoiceLine `gorm:"foreignKey:InvoiceID" json:"lines"`
+	IssuedAt      time.Time `json:"issued_at"`
+	CreatedAt     time.Time `json:"created_at"`
+}
+
+// InvoiceLine is an order line as it stood when the invoice was issued, so reprints match
+// the original even after the order changes
+type InvoiceLine struct {
+	ID          uint    `gorm:"primaryKey" json:"id"`
+	InvoiceID   uint    `gorm:"index" json:"invoice_id"`
+	Description string  `json:"description"`
+	Quantity    int     `json:"quantity"`
+	UnitPrice   float64 `json:"unit_price"`
+	Total       float64 `json:"total"` // before VAT
+	TaxAmount   float64 `json:"tax_amount"`
+}
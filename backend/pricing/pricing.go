@@ -0,0 +1,168 @@
+// Package pricing turns a list of items into the breakdown a customer is charged: line totals,
+// subtotal, shipping, discount, tax and grand total. Carts, quotes and orders all price through
+// it so the numbers shown before checkout are the numbers charged.
+package pricing
+
+import (
+	"errors"
+	"math"
+	"time"
+
+	"github.com/yourname/sakifarm-ecommerce/models"
+)
+
+// VATRate is the value added tax charged on order items
+const VATRate = 0.16
+
+// DeliveryFee is the flat shipping charge for delivered orders. Pickup orders ship free.
+const DeliveryFee = 200.0
+
+// Coupon errors are safe to show to the customer
+var (
+	ErrCouponInactive  = errors.New("Coupon is not valid")
+	ErrCouponExpired   = errors.New("Coupon has expired")
+	ErrCouponUsedUp    = errors.New("Coupon has reached its usage limit")
+	ErrCouponMinAmount = errors.New("Order does not reach the coupon's minimum amount")
+)
+
+// Item is something to be priced at a given unit price
+type Item struct {
+	ProductID uint
+	Name      string
+	Quantity  int
+	UnitPrice float64
+}
+
+// Line is a priced item
+type Line struct {
+	ProductID uint    `json:"product_id"`
+	Name      string  `json:"name"`
+	Quantity  int     `json:"quantity"`
+	UnitPrice float64 `json:"unit_price"`
+	Total     float64 `json:"total"`
+}
+
+// Options change how a set of items is priced
+type Options struct {
+	FulfilmentType string // delivery when empty
+	Coupon         *models.Coupon
+	// CouponRedeemed re-prices an order whose coupon was already redeemed, applying it
+	// without checking expiry or usage again. The minimum amount still has to be met.
+	CouponRedeemed bool
+}
+
+// Breakdown is the full price of a set of items
+type Breakdown struct {
+	Lines      []Line  `json:"lines"`
+	Subtotal   float64 `json:"subtotal"`
+	Shipping   float64 `json:"shipping"`
+	Discount   float64 `json:"discount"`
+	Tax        float64 `json:"tax"`
+	Total      float64 `json:"total"`
+	CouponCode string  `json:"coupon_code,omitempty"`
+}
+
+// Calculate prices the items. Tax is charged on the subtotal after discounts; shipping is
+// not taxed. An error is only returned for a coupon that cannot be applied.
+func Calculate(items []Item, opts Options) (*Breakdown, error) {
+	breakdown := &Breakdown{Lines: make([]Line, 0, len(items))}
+
+	for _, item := range items {
+		total := round(item.UnitPrice * float64(item.Quantity))
+		breakdown.Lines = append(breakdown.Lines, Line{
+			ProductID: item.ProductID,
+			Name:      item.Name,
+			Quantity:  item.Quantity,
+			UnitPrice: item.UnitPrice,
+			Total:     total,
+		})
+		breakdown.Subtotal += total
+	}
+	breakdown.Subtotal = round(breakdown.Subtotal)
+
+	if opts.FulfilmentType != "pickup" && len(items) > 0 {
+		breakdown.Shipping = DeliveryFee
+	}
+
+	if opts.Coupon != nil {
+		if !opts.CouponRedeemed {
+			if err := CheckCoupon(opts.Coupon, breakdown.Subtotal, time.Now()); err != nil {
+				return nil, err
+			}
+		} else if breakdown.Subtotal < opts.Coupon.MinAmount {
+			return nil, ErrCouponMinAmount
+		}
+		breakdown.Discount = CouponDiscount(opts.Coupon, breakdown.Subtotal)
+		breakdown.CouponCode = opts.Coupon.Code
+	}
+
+	breakdown.Tax = round((breakdown.Subtotal - breakdown.Discount) * VATRate)
+	breakdown.Total = round(breakdown.Subtotal - breakdown.Discount + breakdown.Tax + breakdown.Shipping)
+
+	return breakdown, nil
+}
+
+// CheckCoupon reports whether a coupon can be used on an order with the given subtotal
+func CheckCoupon(coupon *models.Coupon, subtotal float64, now time.Time) error {
+	switch {
+	case !coupon.IsActive:
+		return ErrCouponInactive
+	case !coupon.ExpiresAt.IsZero() && now.After(coupon.ExpiresAt):
+		return ErrCouponExpired
+	case coupon.UsageLimit > 0 && coupon.UsedCount >= coupon.UsageLimit:
+		return ErrCouponUsedUp
+	case subtotal < coupon.MinAmount:
+		return ErrCouponMinAmount
+	}
+	return nil
+}
+
+// CouponDiscount is the amount a coupon takes off a subtotal, never more than the subtotal
+func CouponDiscount(coupon *models.Coupon, subtotal float64) float64 {
+	var discount float64
+	switch coupon.Type {
+	case "percentage":
+		discount = subtotal * coupon.Value / 100
+		if coupon.MaxDiscount > 0 && discount > coupon.MaxDiscount {
+			discount = coupon.MaxDiscount
+		}
+	case "fixed":
+		discount = coupon.Value
+	}
+
+	if discount > subtotal {
+		discount = subtotal
+	}
+	return round(discount)
+}
+
+// LineTaxes splits an order's tax across its lines. Each line's share of the discount is
+// taken off before the tax is worked out, and any rounding difference goes on the last line,
+// so the lines always add up to the order's tax.
+func LineTaxes(lineTotals []float64, discount, tax float64) []float64 {
+	var subtotal float64
+	for _, total := range lineTotals {
+		subtotal += total
+	}
+
+	taxes := make([]float64, len(lineTotals))
+	var allocated float64
+	for i, total := range lineTotals {
+		if i == len(lineTotals)-1 {
+			taxes[i] = round(tax - allocated)
+			break
+		}
+		lineDiscount := 0.0
+		if subtotal > 0 {
+			lineDiscount = discount * total / subtotal
+		}
+		taxes[i] = round((total - lineDiscount) * VATRate)
+		allocated += taxes[i]
+	}
+	return taxes
+}
+
+// round rounds an amount to whole cents
+func round(amount float64) float64 {
+	return math.Round(amount*100) / 100
+}
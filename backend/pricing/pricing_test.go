@@ -0,0 +1,165 @@
+package pricing
+
+import (
+	"testing"
+	"time"
+
+	"github.com/yourname/sakifarm-ecommerce/models"
+)
+
+func TestCalculate(t *testing.T) {
+	items := []Item{
+		{ProductID: 1, Name: "Tomatoes", Quantity: 3, UnitPrice: 150},
+		{ProductID: 2, Name: "Kale", Quantity: 2, UnitPrice: 50},
+	}
+
+	tests := []struct {
+		name     string
+		items    []Item
+		opts     Options
+		subtotal float64
+		shipping float64
+		discount float64
+		tax      float64
+		total    float64
+	}{
+		{
+			name:     "delivery",
+			items:    items,
+			subtotal: 550,
+			shipping: DeliveryFee,
+			tax:      88,
+			total:    838,
+		},
+		{
+			name:     "pickup ships free",
+			items:    items,
+			opts:     Options{FulfilmentType: "pickup"},
+			subtotal: 550,
+			tax:      88,
+			total:    638,
+		},
+		{
+			name:     "percentage coupon is taxed after the discount",
+			items:    items,
+			opts:     Options{FulfilmentType: "pickup", Coupon: &models.Coupon{Code: "TEN", Type: "percentage", Value: 10, IsActive: true}},
+			subtotal: 550,
+			discount: 55,
+			tax:      79.2,
+			total:    574.2,
+		},
+		{
+			name:     "percentage coupon capped at its maximum",
+			items:    items,
+			opts:     Options{FulfilmentType: "pickup", Coupon: &models.Coupon{Code: "HALF", Type: "percentage", Value: 50, MaxDiscount: 100, IsActive: true}},
+			subtotal: 550,
+			discount: 100,
+			tax:      72,
+			total:    522,
+		},
+		{
+			name:     "fixed coupon never exceeds the subtotal",
+			items:    items,
+			opts:     Options{FulfilmentType: "pickup", Coupon: &models.Coupon{Code: "BIG", Type: "fixed", Value: 1000, IsActive: true}},
+			subtotal: 550,
+			discount: 550,
+			total:    0,
+		},
+		{
+			name:  "no items",
+			items: nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			breakdown, err := Calculate(tt.items, tt.opts)
+			if err != nil {
+				t.Fatalf("Calculate returned %v", err)
+			}
+			if breakdown.Subtotal != tt.subtotal || breakdown.Shipping != tt.shipping || breakdown.Discount != tt.discount ||
+				breakdown.Tax != tt.tax || breakdown.Total != tt.total {
+				t.Errorf("got subtotal %.2f shipping %.2f discount %.2f tax %.2f total %.2f, want %.2f %.2f %.2f %.2f %.2f",
+					breakdown.Subtotal, breakdown.Shipping, breakdown.Discount, breakdown.Tax, breakdown.Total,
+					tt.subtotal, tt.shipping, tt.discount, tt.tax, tt.total)
+			}
+			if len(breakdown.Lines) != len(tt.items) {
+				t.Errorf("got %d lines, want %d", len(breakdown.Lines), len(tt.items))
+			}
+		})
+	}
+}
+
+func TestCalculateCouponErrors(t *testing.T) {
+	items := []Item{{ProductID: 1, Quantity: 2, UnitPrice: 500}}
+
+	tests := []struct {
+		name   string
+		coupon models.Coupon
+		opts   Options
+		want   error
+	}{
+		{"inactive", models.Coupon{Type: "fixed", Value: 100}, Options{}, ErrCouponInactive},
+		{"expired", models.Coupon{Type: "fixed", Value: 100, IsActive: true, ExpiresAt: time.Now().Add(-time.Hour)}, Options{}, ErrCouponExpired},
+		{"used up", models.Coupon{Type: "fixed", Value: 100, IsActive: true, UsageLimit: 5, UsedCount: 5}, Options{}, ErrCouponUsedUp},
+		{"below minimum", models.Coupon{Type: "fixed", Value: 100, IsActive: true, MinAmount: 5000}, Options{}, ErrCouponMinAmount},
+		{"redeemed still needs the minimum", models.Coupon{Type: "fixed", Value: 100, MinAmount: 5000}, Options{CouponRedeemed: true}, ErrCouponMinAmount},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := tt.opts
+			opts.Coupon = &tt.coupon
+			if _, err := Calculate(items, opts); err != tt.want {
+				t.Errorf("got %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestCalculateRedeemedCouponSkipsUsageChecks(t *testing.T) {
+	// An expired, used up coupon keeps applying to the order that redeemed it
+	coupon := &models.Coupon{Type: "fixed", Value: 100, UsageLimit: 1, UsedCount: 1, ExpiresAt: time.Now().Add(-time.Hour)}
+	breakdown, err := Calculate([]Item{{ProductID: 1, Quantity: 1, UnitPrice: 500}}, Options{Coupon: coupon, CouponRedeemed: true})
+	if err != nil {
+		t.Fatalf("Calculate returned %v", err)
+	}
+	if breakdown.Discount != 100 {
+		t.Errorf("got discount %.2f, want 100", breakdown.Discount)
+	}
+}
+
+func TestLineTaxes(t *testing.T) {
+	tests := []struct {
+		name       string
+		lineTotals []float64
+		discount   float64
+		tax        float64
+		want       []float64
+	}{
+		{"no discount", []float64{100, 200, 300}, 0, 96, []float64{16, 32, 48}},
+		{"discount spread by line total", []float64{100, 200, 300}, 60, 86.4, []float64{14.4, 28.8, 43.2}},
+		{"rounding remainder on the last line", []float64{0.33, 0.33, 0.34}, 0, 0.16, []float64{0.05, 0.05, 0.06}},
+		{"single line takes all the tax", []float64{550}, 55, 79.2, []float64{79.2}},
+		{"no lines", nil, 0, 0, []float64{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := LineTaxes(tt.lineTotals, tt.discount, tt.tax)
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %v, want %v", got, tt.want)
+			}
+			var sum float64
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("line %d: got %.2f, want %.2f", i, got[i], tt.want[i])
+				}
+				sum += got[i]
+			}
+			if round(sum) != tt.tax {
+				t.Errorf("line taxes sum to %.2f, want %.2f", sum, tt.tax)
+			}
+		})
+	}
+}
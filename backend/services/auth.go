@@ -12,6 +12,17 @@ import (
 	"gorm.io/gorm"
 )
 
+var (
+	ErrInvalidOTP = errors.New("invalid or expired OTP")
+	ErrOTPLocked  = errors.New("too many wrong codes, request a new one")
+	ErrOTPTooSoon = errors.New("a code was sent recently, wait a minute before asking for another")
+)
+
+const (
+	maxOTPAttempts    = 5           // wrong codes before a code stops working
+	otpResendInterval = time.Minute // least time between two codes for the same phone and purpose
+)
+
 type AuthService struct {
 	db          *gorm.DB
 	smsService  *SMSService
@@ -70,6 +81,16 @@ func (s *AuthService) Login(identifier, password string) (*models.User, error) {
 }
 
 func (s *AuthService) SendOTP(phone, purpose string) error {
+	// Each code allows a few guesses, so codes can't be asked for again and again
+	var recent int64
+	if err := s.db.Model(&models.OTP{}).Where("phone = ? AND purpose = ? AND created_at > ?", phone, purpose, time.Now().Add(-otpResendInterval)).
+		Count(&recent).Error; err != nil {
+		return err
+	}
+	if recent > 0 {
+		return ErrOTPTooSoon
+	}
+
 	// Generate 6-digit OTP
 	otp, err := s.generateOTP()
 	if err != nil {
@@ -96,19 +117,38 @@ func (s *AuthService) SendOTP(phone, purpose string) error {
 	return s.smsService.SendSMS(phone, message)
 }
 
+// VerifyOTP uses up a phone's code for the purpose. Using the code and counting a wrong one
+// are single conditional updates, so a code is only ever used once and stops working after
+// too many wrong guesses.
 func (s *AuthService) VerifyOTP(phone, code, purpose string) error {
-	var otp models.OTP
-	
-	if err := s.db.Where("phone = ? AND code = ? AND purpose = ? AND used = false AND expires_at > ?", 
-		phone, code, purpose, time.Now()).First(&otp).Error; err != nil {
-		return errors.New("invalid or expired OTP")
+	now := time.Now()
+	result := s.db.Model(&models.OTP{}).
+		Where("phone = ? AND code = ? AND purpose = ? AND used = false AND attempts < ? AND expires_at > ?",
+			phone, code, purpose, maxOTPAttempts, now).
+		Update("used", true)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected > 0 {
+		return nil
 	}
 
-	// Mark OTP as used
-	otp.Used = true
-	s.db.Save(&otp)
+	if err := s.db.Model(&models.OTP{}).
+		Where("phone = ? AND purpose = ? AND used = false AND expires_at > ?", phone, purpose, now).
+		Update("attempts", gorm.Expr("attempts + 1")).Error; err != nil {
+		return err
+	}
 
-	return nil
+	var locked int64
+	if err := s.db.Model(&models.OTP{}).
+		Where("phone = ? AND purpose = ? AND used = false AND attempts >= ? AND expires_at > ?", phone, purpose, maxOTPAttempts, now).
+		Count(&locked).Error; err != nil {
+		return err
+	}
+	if locked > 0 {
+		return ErrOTPLocked
+	}
+	return ErrInvalidOTP
 }
 
 func (s *AuthService) LoginWithOTP(phone string) (*models.User, error) {
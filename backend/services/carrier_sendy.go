@@ -0,0 +1,210 @@
+package services
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/yourname/sakifarm-ecommerce/models"
+)
+
+// SendyCarrier books deliveries with Sendy's courier API
+type SendyCarrier struct {
+	baseURL       string
+	apiKey        string
+	webhookSecret string
+	pickup        SellerDetails
+	client        *http.Client
+}
+
+type SendyConsignmentRequest struct {
+	Reference       string `json:"reference"`
+	PickupName      string `json:"pickup_name"`
+	PickupAddress   string `json:"pickup_address"`
+	PickupPhone     string `json:"pickup_phone"`
+	RecipientName   string `json:"recipient_name"`
+	RecipientPhone  string `json:"recipient_phone"`
+	DeliveryAddress string `json:"delivery_address"`
+	DeliveryCity    string `json:"delivery_city"`
+	Description     string `json:"description"`
+	Quantity        int    `json:"quantity"`
+}
+
+type SendyConsignmentResponse struct {
+	TrackingNumber string `json:"tracking_number"`
+	LabelURL       string `json:"label_url"`
+}
+
+type SendyEvent struct {
+	TrackingNumber string `json:"tracking_number"`
+	Status         string `json:"status"`
+	Description    string `json:"description"`
+	Location       string `json:"location"`
+	Timestamp      string `json:"timestamp"`
+}
+
+// Header carrying the HMAC-SHA256 signature of webhook bodies
+const sendySignatureHeader = "X-Sendy-Signature"
+
+func NewSendyCarrier(baseURL, apiKey, webhookSecret string, pickup SellerDetails) *SendyCarrier {
+	return &SendyCarrier{
+		baseURL:       strings.TrimRight(baseURL, "/"),
+		apiKey:        apiKey,
+		webhookSecret: webhookSecret,
+		pickup:        pickup,
+		client:        &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+func (s *SendyCarrier) CreateConsignment(order *models.Order, shipment *models.Shipment) (*Consignment, error) {
+	var names []string
+	quantity := 0
+	for _, item := range shipment.Items {
+		names = append(names, fmt.Sprintf("%s x%d", item.OrderItem.Product.Name, item.Quantity))
+		quantity += item.Quantity
+	}
+
+	address := order.ShippingAddress
+	request := SendyConsignmentRequest{
+		Reference:       fmt.Sprintf("%s-%d", order.OrderNumber, shipment.ID),
+		PickupName:      s.pickup.Name,
+		PickupAddress:   s.pickup.Address,
+		PickupPhone:     s.pickup.Phone,
+		RecipientName:   address.FirstName + " " + address.LastName,
+		RecipientPhone:  address.Phone,
+		DeliveryAddress: strings.TrimSpace(address.Address1 + " " + address.Address2),
+		DeliveryCity:    address.City,
+		Description:     strings.Join(names, ", "),
+		Quantity:        quantity,
+	}
+
+	var response SendyConsignmentResponse
+	if err := s.do("POST", "/consignments", request, &response); err != nil {
+		return nil, err
+	}
+	if response.TrackingNumber == "" {
+		return nil, errors.New("sendy did not return a tracking number")
+	}
+
+	return &Consignment{TrackingNumber: response.TrackingNumber, LabelURL: response.LabelURL}, nil
+}
+
+func (s *SendyCarrier) FetchTrackingEvents(trackingNumber string) ([]CarrierEvent, error) {
+	var response struct {
+		Events []SendyEvent `json:"events"`
+	}
+	if err := s.do("GET", "/consignments/"+trackingNumber+"/events", nil, &response); err != nil {
+		return nil, err
+	}
+
+	events := make([]CarrierEvent, 0, len(response.Events))
+	for _, event := range response.Events {
+		if event.TrackingNumber == "" {
+			event.TrackingNumber = trackingNumber
+		}
+		if normalized, ok := s.normalize(event); ok {
+			events = append(events, normalized)
+		}
+	}
+	return events, nil
+}
+
+func (s *SendyCarrier) ParseWebhook(body []byte, headers http.Header) ([]CarrierEvent, error) {
+	// Without a secret anyone could sign a webhook
+	if s.webhookSecret == "" {
+		return nil, errors.New("webhook secret not configured")
+	}
+
+	mac := hmac.New(sha256.New, []byte(s.webhookSecret))
+	mac.Write(body)
+	expected := hex.EncodeToString(mac.Sum(nil))
+	if !hmac.Equal([]byte(expected), []byte(headers.Get(sendySignatureHeader))) {
+		return nil, errors.New("invalid webhook signature")
+	}
+
+	var event SendyEvent
+	if err := json.Unmarshal(body, &event); err != nil {
+		return nil, err
+	}
+
+	normalized, ok := s.normalize(event)
+	if !ok {
+		// Statuses we don't track are acknowledged and ignored
+		return nil, nil
+	}
+	return []CarrierEvent{normalized}, nil
+}
+
+// normalize maps Sendy's statuses onto ours, reporting false for ones we ignore. Events
+// without a valid timestamp are ignored too, since events are told apart by when they happened.
+func (s *SendyCarrier) normalize(event SendyEvent) (CarrierEvent, bool) {
+	statuses := map[string]string{
+		"picked":           TrackingPickedUp,
+		"in_transit":       TrackingInTransit,
+		"at_hub":           TrackingInTransit,
+		"out_for_delivery": TrackingOutForDelivery,
+		"delivered":        TrackingDelivered,
+		"failed_delivery":  TrackingFailedAttempt,
+		"returned":         TrackingReturned,
+	}
+
+	status, ok := statuses[strings.ToLower(event.Status)]
+	if !ok {
+		return CarrierEvent{}, false
+	}
+
+	occurredAt, err := time.Parse(time.RFC3339, event.Timestamp)
+	if err != nil {
+		return CarrierEvent{}, false
+	}
+
+	return CarrierEvent{
+		TrackingNumber: event.TrackingNumber,
+		Status:         status,
+		Description:    event.Description,
+		Location:       event.Location,
+		OccurredAt:     occurredAt,
+	}, true
+}
+
+func (s *SendyCarrier) do(method, path string, payload, result interface{}) error {
+	var body io.Reader
+	if payload != nil {
+		jsonData, err := json.Marshal(payload)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewBuffer(jsonData)
+	}
+
+	req, err := http.NewRequest(method, s.baseURL+path, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+s.apiKey)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return ErrUnknownConsignment
+	}
+	if resp.StatusCode >= 300 {
+		respBody, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("sendy request failed with status %d: %s", resp.StatusCode, string(respBody))
+	}
+
+	return json.NewDecoder(resp.Body).Decode(result)
+}
@@ -2,6 +2,7 @@ package services
 
 import (
 	"fmt"
+	"html"
 
 	"gopkg.in/gomail.v2"
 )
@@ -85,3 +86,25 @@ func (s *EmailService) SendDeliveryNotification(to, orderNumber, trackingNumber
 
 	return s.SendEmail(to, subject, body)
 }
+
+func (s *EmailService) SendCartReminder(to, firstName, cartLink, couponCode string, couponPercent int) error {
+	subject := "You left something in your cart"
+	offer := ""
+	if couponCode != "" {
+		offer = fmt.Sprintf(`<p>Complete your order now and get <strong>%d%% off</strong> with code <strong>%s</strong>.</p>`, couponPercent, couponCode)
+	}
+	body := fmt.Sprintf(`
+		<html>
+		<body>
+			<h2>Still thinking it over, %s?</h2>
+			<p>The items in your cart are waiting for you.</p>
+			%s
+			<p><a href="%s">Return to your cart</a></p>
+			<br>
+			<p>Happy shopping!<br>SakiFarm Team</p>
+		</body>
+		</html>
+	`, html.EscapeString(firstName), offer, html.EscapeString(cartLink))
+
+	return s.SendEmail(to, subject, body)
+}
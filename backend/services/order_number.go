@@ -0,0 +1,50 @@
+package services
+
+import (
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// OrderNumberService hands out human-friendly order numbers such as SF-20261016-0042,
+// counting from 1 each day
+type OrderNumberService struct {
+	db     *gorm.DB
+	prefix string
+	digits int
+}
+
+func NewOrderNumberService(db *gorm.DB, prefix string, digits int) *OrderNumberService {
+	if digits < 1 {
+		digits = 4
+	}
+	return &OrderNumberService{db: db, prefix: prefix, digits: digits}
+}
+
+// Next returns the next order number for today. The counter row is incremented with an
+// upsert, so concurrent checkouts always receive distinct numbers. Pass a transaction to
+// take the number as part of it; numbers taken by rolled back transactions are reused.
+func (s *OrderNumberService) Next(db *gorm.DB) (string, error) {
+	if db == nil {
+		db = s.db
+	}
+
+	today := time.Now().Format("20060102")
+
+	var value int
+	err := db.Raw(`INSERT INTO order_number_sequences (day, value) VALUES (?, 1)
+		ON CONFLICT (day) DO UPDATE SET value = order_number_sequences.value + 1
+		RETURNING value`, today).Scan(&value).Error
+	if err != nil {
+		return "", err
+	}
+
+	return formatOrderNumber(s.prefix, today, s.digits, value), nil
+}
+
+// formatOrderNumber joins the prefix, day and counter, padding the counter with zeros to at
+// least digits long
+func formatOrderNumber(prefix, day string, digits, value int) string {
+	return fmt.Sprintf("%s-%s-%0*d", prefix, day, digits, value)
+}
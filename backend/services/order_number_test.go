@@ -0,0 +1,33 @@
+package services
+
+import "testing"
+
+func TestFormatOrderNumber(t *testing.T) {
+	tests := []struct {
+		prefix string
+		day    string
+		digits int
+		value  int
+		want   string
+	}{
+		{"SF", "20261016", 4, 42, "SF-20261016-0042"},
+		{"SF", "20261016", 4, 1, "SF-20261016-0001"},
+		{"SF", "20261016", 4, 12345, "SF-20261016-12345"},
+		{"ORD", "20270101", 6, 7, "ORD-20270101-000007"},
+		{"SF", "20261016", 1, 9, "SF-20261016-9"},
+	}
+
+	for _, tt := range tests {
+		if got := formatOrderNumber(tt.prefix, tt.day, tt.digits, tt.value); got != tt.want {
+			t.Errorf("formatOrderNumber(%q, %q, %d, %d) = %q, want %q", tt.prefix, tt.day, tt.digits, tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestNewOrderNumberServiceDefaultsDigits(t *testing.T) {
+	for _, digits := range []int{0, -3} {
+		if s := NewOrderNumberService(nil, "SF", digits); s.digits != 4 {
+			t.Errorf("NewOrderNumberService with %d digits uses %d, want 4", digits, s.digits)
+		}
+	}
+}
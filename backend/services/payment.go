@@ -12,6 +12,7 @@ import (
 
 	"github.com/yourname/sakifarm-ecommerce/models"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 type PaymentService struct {
@@ -84,11 +85,12 @@ func NewPaymentService(db *gorm.DB, mpesaKey, mpesaSecret, mpesaPasskey, mpesaSh
 	}
 }
 
-func (s *PaymentService) InitiateMPesaPayment(orderID uint, phoneNumber string, amount float64) (*models.Payment, error) {
+func (s *PaymentService) InitiateMPesaPayment(orderID uint, phoneNumber string, amount float64, purpose string) (*models.Payment, error) {
 	// Create payment record
 	payment := &models.Payment{
 		OrderID:       orderID,
 		PaymentMethod: "mpesa",
+		Purpose:       purpose,
 		Amount:        amount,
 		Currency:      "KES",
 		Status:        "pending",
@@ -125,11 +127,12 @@ func (s *PaymentService) InitiateMPesaPayment(orderID uint, phoneNumber string,
 	return payment, nil
 }
 
-func (s *PaymentService) InitiateAirtelPayment(orderID uint, phoneNumber string, amount float64) (*models.Payment, error) {
+func (s *PaymentService) InitiateAirtelPayment(orderID uint, phoneNumber string, amount float64, purpose string) (*models.Payment, error) {
 	// Create payment record
 	payment := &models.Payment{
 		OrderID:       orderID,
 		PaymentMethod: "airtel",
+		Purpose:       purpose,
 		Amount:        amount,
 		Currency:      "KES",
 		Status:        "pending",
@@ -167,33 +170,47 @@ func (s *PaymentService) InitiateAirtelPayment(orderID uint, phoneNumber string,
 	return payment, nil
 }
 
-func (s *PaymentService) ProcessPaymentCallback(paymentMethod, transactionID string, success bool, externalRef string) error {
-	var payment models.Payment
-	
-	if err := s.db.Where("transaction_id = ? AND payment_method = ?", transactionID, paymentMethod).First(&payment).Error; err != nil {
-		return err
-	}
+// ProcessPaymentCallback records a provider's result for the payment request with the given
+// transaction ID. A successful order, balance or subscription payment marks the order paid;
+// a failed order payment is handed to orderFailed in the same transaction.
+func (s *PaymentService) ProcessPaymentCallback(paymentMethod, transactionID string, success bool, externalRef string, orderFailed func(tx *gorm.DB, orderID uint) error) error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		var payment models.Payment
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("transaction_id = ? AND payment_method = ?", transactionID, paymentMethod).
+			First(&payment).Error; err != nil {
+			return err
+		}
 
-	if success {
-		payment.Status = "success"
-		payment.ExternalRef = externalRef
-		
-		// Update order payment status
-		s.db.Model(&models.Order{}).Where("id = ?", payment.OrderID).Update("payment_status", "paid")
-	} else {
-		payment.Status = "failed"
-		
-		// Restore inventory if payment failed
-		var order models.Order
-		if err := s.db.Preload("Items").First(&order, payment.OrderID).Error; err == nil {
-			for _, item := range order.Items {
-				s.db.Model(&models.Product{}).Where("id = ?", item.ProductID).
-					Update("stock", gorm.Expr("stock + ?", item.Quantity))
-			}
+		// A repeated callback must not restock or mark the order paid twice
+		if payment.Status != "pending" && payment.Status != "superseded" {
+			return nil
 		}
-	}
 
-	return s.db.Save(&payment).Error
+		// A request replaced when the order was edited no longer settles it; only its outcome
+		// is recorded, and counts towards what has been paid on later edits
+		superseded := payment.Status == "superseded"
+		if success {
+			payment.Status = "success"
+			payment.ExternalRef = externalRef
+		} else {
+			payment.Status = "failed"
+		}
+		if err := tx.Save(&payment).Error; err != nil || superseded {
+			return err
+		}
+
+		if success {
+			return tx.Model(&models.Order{}).Where("id = ?", payment.OrderID).Update("payment_status", "paid").Error
+		}
+
+		// Subscription orders keep their stock while payment is retried and give it back
+		// when the order is finally cancelled; balance requests leave the order as it is
+		if payment.Purpose == "order" {
+			return orderFailed(tx, payment.OrderID)
+		}
+		return nil
+	})
 }
 
 func (s *PaymentService) getMPesaAccessToken() (string, error) {
@@ -7,257 +7,345 @@ import (
 
 	"github.com/jung-kurt/gofpdf"
 	"github.com/yourname/sakifarm-ecommerce/models"
+	"github.com/yourname/sakifarm-ecommerce/pricing"
 )
 
-type PDFService struct{}
+// SellerDetails identifies the business on invoices and receipts
+type SellerDetails struct {
+	Name    string
+	KRAPIN  string
+	Address string
+	Phone   string
+	Email   string
+}
+
+type PDFService struct {
+	seller SellerDetails
+}
 
-func NewPDFService() *PDFService {
-	return &PDFService{}
+func NewPDFService(seller SellerDetails) *PDFService {
+	return &PDFService{seller: seller}
 }
 
+// GenerateReceipt produces the delivery receipt handed over with a delivered order
 func (s *PDFService) GenerateReceipt(order *models.Order) ([]byte, error) {
+	pdf := s.newDocument("DELIVERY RECEIPT")
+
+	// Order information
+	s.writeField(pdf, "Order Number:", order.OrderNumber)
+	s.writeField(pdf, "Order Date:", order.CreatedAt.Format("January 2, 2006"))
+	if order.DeliveredAt != nil {
+		s.writeField(pdf, "Delivery Date:", order.DeliveredAt.Format("January 2, 2006"))
+	} else {
+		s.writeField(pdf, "Delivery Date:", "Not delivered yet")
+	}
+	s.writeField(pdf, "Tracking Number:", order.TrackingNumber)
+	pdf.Ln(7)
+
+	s.writeCustomer(pdf, order)
+	s.writeShippingAddress(pdf, order)
+	s.writeItems(pdf, orderLines(order), false)
+	s.writeTotals(pdf, orderTotals(order))
+	s.writePayment(pdf, order)
+
+	return s.finish(pdf, "Thank you for shopping with "+s.seller.Name+"!")
+}
+
+// GenerateProformaInvoice produces a quotation-style invoice that can be issued at any
+// stage of an order. It carries no invoice number and is not valid for tax purposes.
+func (s *PDFService) GenerateProformaInvoice(order *models.Order) ([]byte, error) {
+	pdf := s.newDocument("PRO-FORMA INVOICE")
+
+	s.writeField(pdf, "Reference:", order.OrderNumber)
+	s.writeField(pdf, "Date:", time.Now().Format("January 2, 2006"))
+	s.writeField(pdf, "Order Status:", order.Status)
+	pdf.Ln(7)
+
+	s.writeCustomer(pdf, order)
+	s.writeShippingAddress(pdf, order)
+	s.writeItems(pdf, orderLines(order), true)
+	s.writeTotals(pdf, orderTotals(order))
+
+	return s.finish(pdf, "This is a pro-forma invoice and not a tax invoice. Prices are valid until the order is paid.")
+}
+
+// GenerateTaxInvoice produces the tax invoice for a paid order. Lines and amounts come from the
+// issued invoice, so a reprint shows what was invoiced even if the order changed since.
+func (s *PDFService) GenerateTaxInvoice(order *models.Order, invoice *models.Invoice) ([]byte, error) {
+	pdf := s.newDocument("TAX INVOICE")
+
+	s.writeField(pdf, "Invoice Number:", invoice.InvoiceNumber)
+	s.writeField(pdf, "Invoice Date:", invoice.IssuedAt.Format("January 2, 2006"))
+	s.writeField(pdf, "Order Number:", order.OrderNumber)
+	s.writeField(pdf, "Order Date:", order.CreatedAt.Format("January 2, 2006"))
+	pdf.Ln(7)
+
+	s.writeCustomer(pdf, order)
+	s.writeShippingAddress(pdf, order)
+	s.writeItems(pdf, invoiceLines(invoice), true)
+	s.writeTotals(pdf, documentTotals{
+		Subtotal: invoice.Subtotal,
+		Shipping: invoice.ShippingAmount,
+		Tax:      invoice.TaxAmount,
+		Discount: invoice.DiscountAmount,
+		Total:    invoice.TotalAmount,
+	})
+	s.writePayment(pdf, order)
+
+	return s.finish(pdf, fmt.Sprintf("Prices include VAT at %.0f%% where shown.", pricing.VATRate*100))
+}
+
+// newDocument starts a page with the seller header and the document title
+func (s *PDFService) newDocument(title string) *gofpdf.Fpdf {
 	pdf := gofpdf.New("P", "mm", "A4", "")
 	pdf.AddPage()
 
 	// Set colors for branding
 	pdf.SetFillColor(255, 20, 147) // Hot pink
-	pdf.SetTextColor(0, 0, 139)   // Navy blue
+	pdf.SetTextColor(0, 0, 139)    // Navy blue
 
 	// Header with company name
 	pdf.SetFont("Arial", "B", 20)
-	pdf.CellFormat(0, 15, "SakiFarm Ecommerce", "0", 1, "C", true, 0, "")
-	pdf.Ln(5)
+	pdf.CellFormat(0, 15, s.seller.Name, "0", 1, "C", true, 0, "")
+	pdf.Ln(2)
 
-	// Receipt title
+	// Seller details
 	pdf.SetTextColor(0, 0, 0)
+	pdf.SetFont("Arial", "", 9)
+	if s.seller.Address != "" {
+		pdf.CellFormat(0, 5, s.seller.Address, "0", 1, "C", false, 0, "")
+	}
+	contact := s.seller.Phone
+	if s.seller.Email != "" {
+		if contact != "" {
+			contact += " | "
+		}
+		contact += s.seller.Email
+	}
+	if contact != "" {
+		pdf.CellFormat(0, 5, contact, "0", 1, "C", false, 0, "")
+	}
+	if s.seller.KRAPIN != "" {
+		pdf.CellFormat(0, 5, "KRA PIN: "+s.seller.KRAPIN, "0", 1, "C", false, 0, "")
+	}
+	pdf.Ln(5)
+
+	// Document title
 	pdf.SetFont("Arial", "B", 16)
-	pdf.Cell(0, 10, "DELIVERY RECEIPT")
+	pdf.Cell(0, 10, title)
 	pdf.Ln(15)
 
-	// Order information
-	pdf.SetFont("Arial", "", 12)
-	pdf.Cell(50, 8, "Order Number:")
-	pdf.SetFont("Arial", "B", 12)
-	pdf.Cell(0, 8, order.OrderNumber)
-	pdf.Ln(8)
-
-	pdf.SetFont("Arial", "", 12)
-	pdf.Cell(50, 8, "Order Date:")
-	pdf.SetFont("Arial", "B", 12)
-	pdf.Cell(0, 8, order.CreatedAt.Format("January 2, 2006"))
-	pdf.Ln(8)
+	return pdf
+}
 
+func (s *PDFService) writeField(pdf *gofpdf.Fpdf, label, value string) {
 	pdf.SetFont("Arial", "", 12)
-	pdf.Cell(50, 8, "Delivery Date:")
+	pdf.Cell(50, 8, label)
 	pdf.SetFont("Arial", "B", 12)
-	if order.DeliveredAt != nil {
-		pdf.Cell(0, 8, order.DeliveredAt.Format("January 2, 2006"))
-	} else {
-		pdf.Cell(0, 8, "Not delivered yet")
-	}
+	pdf.Cell(0, 8, value)
 	pdf.Ln(8)
+}
 
-	pdf.SetFont("Arial", "", 12)
-	pdf.Cell(50, 8, "Tracking Number:")
-	pdf.SetFont("Arial", "B", 12)
-	pdf.Cell(0, 8, order.TrackingNumber)
-	pdf.Ln(15)
-
-	// Customer information
+func (s *PDFService) writeHeading(pdf *gofpdf.Fpdf, heading string) {
 	pdf.SetFont("Arial", "B", 14)
 	pdf.SetTextColor(0, 0, 139) // Navy blue
-	pdf.Cell(0, 10, "Customer Information")
+	pdf.Cell(0, 10, heading)
 	pdf.Ln(10)
-
 	pdf.SetTextColor(0, 0, 0)
-	pdf.SetFont("Arial", "", 12)
-	pdf.Cell(50, 8, "Name:")
-	pdf.SetFont("Arial", "B", 12)
-	pdf.Cell(0, 8, fmt.Sprintf("%s %s", order.User.FirstName, order.User.LastName))
-	pdf.Ln(8)
+}
 
-	pdf.SetFont("Arial", "", 12)
-	pdf.Cell(50, 8, "Email:")
-	pdf.SetFont("Arial", "B", 12)
-	pdf.Cell(0, 8, order.User.Email)
-	pdf.Ln(8)
+// writeCustomer prints the account holder, or the guest contact details for guest orders
+func (s *PDFService) writeCustomer(pdf *gofpdf.Fpdf, order *models.Order) {
+	s.writeHeading(pdf, "Customer Information")
+
+	name := fmt.Sprintf("%s %s", order.User.FirstName, order.User.LastName)
+	email := order.User.Email
+	phone := order.User.Phone
+	if order.User.ID == 0 {
+		name = fmt.Sprintf("%s %s", order.BillingAddress.FirstName, order.BillingAddress.LastName)
+		email = order.GuestEmail
+		phone = order.GuestPhone
+	}
 
-	pdf.SetFont("Arial", "", 12)
-	pdf.Cell(50, 8, "Phone:")
-	pdf.SetFont("Arial", "B", 12)
-	pdf.Cell(0, 8, order.User.Phone)
-	pdf.Ln(15)
+	s.writeField(pdf, "Name:", name)
+	s.writeField(pdf, "Email:", email)
+	s.writeField(pdf, "Phone:", phone)
+	pdf.Ln(7)
+}
 
-	// Shipping address
-	pdf.SetFont("Arial", "B", 14)
-	pdf.SetTextColor(0, 0, 139) // Navy blue
-	pdf.Cell(0, 10, "Shipping Address")
-	pdf.Ln(10)
+func (s *PDFService) writeShippingAddress(pdf *gofpdf.Fpdf, order *models.Order) {
+	s.writeHeading(pdf, "Shipping Address")
 
-	pdf.SetTextColor(0, 0, 0)
 	pdf.SetFont("Arial", "", 12)
 	address := fmt.Sprintf("%s %s\n%s\n%s, %s %s\n%s",
 		order.ShippingAddress.FirstName, order.ShippingAddress.LastName,
 		order.ShippingAddress.Address1,
 		order.ShippingAddress.City, order.ShippingAddress.State, order.ShippingAddress.PostalCode,
 		order.ShippingAddress.Country)
-	
+
 	pdf.MultiCell(0, 6, address, "0", "L", false)
 	pdf.Ln(10)
+}
 
-	// Order items table
-	pdf.SetFont("Arial", "B", 14)
-	pdf.SetTextColor(0, 0, 139) // Navy blue
-	pdf.Cell(0, 10, "Order Items")
-	pdf.Ln(10)
+// documentLine is one line of a receipt or invoice
+type documentLine struct {
+	Name      string
+	Quantity  int
+	UnitPrice float64
+	Total     float64
+	Tax       float64
+}
+
+// documentTotals are the amounts printed under the lines
+type documentTotals struct {
+	Subtotal float64
+	Shipping float64
+	Tax      float64
+	Discount float64
+	Total    float64
+}
+
+// orderLines lists an order's items as they are now, with the order's tax split across them
+func orderLines(order *models.Order) []documentLine {
+	lineTotals := make([]float64, len(order.Items))
+	for i, item := range order.Items {
+		lineTotals[i] = item.Total
+	}
+	taxes := pricing.LineTaxes(lineTotals, order.DiscountAmount, order.TaxAmount)
+
+	lines := make([]documentLine, 0, len(order.Items))
+	for i, item := range order.Items {
+		name := item.Product.Name
+		if item.VariantName != "" {
+			name = fmt.Sprintf("%s (%s)", name, item.VariantName)
+		}
+		lines = append(lines, documentLine{Name: name, Quantity: item.Quantity, UnitPrice: item.Price, Total: item.Total, Tax: taxes[i]})
+	}
+	return lines
+}
+
+func orderTotals(order *models.Order) documentTotals {
+	return documentTotals{
+		Subtotal: order.TotalAmount - order.ShippingAmount - order.TaxAmount + order.DiscountAmount,
+		Shipping: order.ShippingAmount,
+		Tax:      order.TaxAmount,
+		Discount: order.DiscountAmount,
+		Total:    order.TotalAmount,
+	}
+}
+
+// invoiceLines lists the lines frozen on an issued invoice
+func invoiceLines(invoice *models.Invoice) []documentLine {
+	lines := make([]documentLine, 0, len(invoice.Lines))
+	for _, line := range invoice.Lines {
+		lines = append(lines, documentLine{Name: line.Description, Quantity: line.Quantity, UnitPrice: line.UnitPrice, Total: line.Total, Tax: line.TaxAmount})
+	}
+	return lines
+}
+
+// writeItems prints the lines; invoices add the VAT charged on each line after its share of
+// any discount
+func (s *PDFService) writeItems(pdf *gofpdf.Fpdf, lines []documentLine, withTax bool) {
+	s.writeHeading(pdf, "Order Items")
 
 	// Table header
 	pdf.SetFillColor(240, 240, 240)
 	pdf.SetFont("Arial", "B", 10)
-	pdf.CellFormat(80, 8, "Product", "1", 0, "L", true, 0, "")
-	pdf.CellFormat(20, 8, "Qty", "1", 0, "C", true, 0, "")
-	pdf.CellFormat(30, 8, "Price", "1", 0, "R", true, 0, "")
-	pdf.CellFormat(30, 8, "Total", "1", 1, "R", true, 0, "")
+	if withTax {
+		pdf.CellFormat(70, 8, "Product", "1", 0, "L", true, 0, "")
+		pdf.CellFormat(15, 8, "Qty", "1", 0, "C", true, 0, "")
+		pdf.CellFormat(30, 8, "Unit Price", "1", 0, "R", true, 0, "")
+		pdf.CellFormat(35, 8, fmt.Sprintf("VAT (%.0f%%)", pricing.VATRate*100), "1", 0, "R", true, 0, "")
+		pdf.CellFormat(40, 8, "Total incl. VAT", "1", 1, "R", true, 0, "")
+	} else {
+		pdf.CellFormat(80, 8, "Product", "1", 0, "L", true, 0, "")
+		pdf.CellFormat(20, 8, "Qty", "1", 0, "C", true, 0, "")
+		pdf.CellFormat(30, 8, "Price", "1", 0, "R", true, 0, "")
+		pdf.CellFormat(30, 8, "Total", "1", 1, "R", true, 0, "")
+	}
 
 	// Table rows
 	pdf.SetFont("Arial", "", 10)
-	pdf.SetTextColor(0, 0, 0)
-	for _, item := range order.Items {
-		pdf.CellFormat(80, 8, item.Product.Name, "1", 0, "L", false, 0, "")
-		pdf.CellFormat(20, 8, fmt.Sprintf("%d", item.Quantity), "1", 0, "C", false, 0, "")
-		pdf.CellFormat(30, 8, fmt.Sprintf("KES %.2f", item.Price), "1", 0, "R", false, 0, "")
-		pdf.CellFormat(30, 8, fmt.Sprintf("KES %.2f", item.Total), "1", 1, "R", false, 0, "")
+	for _, line := range lines {
+		if withTax {
+			pdf.CellFormat(70, 8, line.Name, "1", 0, "L", false, 0, "")
+			pdf.CellFormat(15, 8, fmt.Sprintf("%d", line.Quantity), "1", 0, "C", false, 0, "")
+			pdf.CellFormat(30, 8, fmt.Sprintf("KES %.2f", line.UnitPrice), "1", 0, "R", false, 0, "")
+			pdf.CellFormat(35, 8, fmt.Sprintf("KES %.2f", line.Tax), "1", 0, "R", false, 0, "")
+			pdf.CellFormat(40, 8, fmt.Sprintf("KES %.2f", line.Total+line.Tax), "1", 1, "R", false, 0, "")
+		} else {
+			pdf.CellFormat(80, 8, line.Name, "1", 0, "L", false, 0, "")
+			pdf.CellFormat(20, 8, fmt.Sprintf("%d", line.Quantity), "1", 0, "C", false, 0, "")
+			pdf.CellFormat(30, 8, fmt.Sprintf("KES %.2f", line.UnitPrice), "1", 0, "R", false, 0, "")
+			pdf.CellFormat(30, 8, fmt.Sprintf("KES %.2f", line.Total), "1", 1, "R", false, 0, "")
+		}
 	}
+}
 
-	// Order summary
+func (s *PDFService) writeTotals(pdf *gofpdf.Fpdf, totals documentTotals) {
 	pdf.Ln(5)
 	pdf.SetFont("Arial", "B", 12)
-	
+
 	pdf.Cell(130, 8, "")
 	pdf.Cell(30, 8, "Subtotal:")
-	pdf.Cell(30, 8, fmt.Sprintf("KES %.2f", order.TotalAmount-order.ShippingAmount-order.TaxAmount))
+	pdf.Cell(30, 8, fmt.Sprintf("KES %.2f", totals.Subtotal))
 	pdf.Ln(6)
 
-	if order.ShippingAmount > 0 {
+	if totals.Shipping > 0 {
 		pdf.Cell(130, 8, "")
 		pdf.Cell(30, 8, "Shipping:")
-		pdf.Cell(30, 8, fmt.Sprintf("KES %.2f", order.ShippingAmount))
+		pdf.Cell(30, 8, fmt.Sprintf("KES %.2f", totals.Shipping))
 		pdf.Ln(6)
 	}
 
-	if order.TaxAmount > 0 {
+	if totals.Tax > 0 {
 		pdf.Cell(130, 8, "")
-		pdf.Cell(30, 8, "Tax:")
-		pdf.Cell(30, 8, fmt.Sprintf("KES %.2f", order.TaxAmount))
+		pdf.Cell(30, 8, "VAT:")
+		pdf.Cell(30, 8, fmt.Sprintf("KES %.2f", totals.Tax))
 		pdf.Ln(6)
 	}
 
-	if order.DiscountAmount > 0 {
+	if totals.Discount > 0 {
 		pdf.Cell(130, 8, "")
 		pdf.Cell(30, 8, "Discount:")
-		pdf.Cell(30, 8, fmt.Sprintf("-KES %.2f", order.DiscountAmount))
+		pdf.Cell(30, 8, fmt.Sprintf("-KES %.2f", totals.Discount))
 		pdf.Ln(6)
 	}
 
 	// Total with background
-	pdf.SetFillColor(255, 20, 147) // Hot pink
+	pdf.SetFillColor(255, 20, 147)  // Hot pink
 	pdf.SetTextColor(255, 255, 255) // White text
 	pdf.Cell(130, 10, "")
 	pdf.CellFormat(30, 10, "TOTAL:", "1", 0, "L", true, 0, "")
-	pdf.CellFormat(30, 10, fmt.Sprintf("KES %.2f", order.TotalAmount), "1", 1, "R", true, 0, "")
-
-	// Payment information
-	pdf.Ln(10)
-	pdf.SetTextColor(0, 0, 139) // Navy blue
-	pdf.SetFont("Arial", "B", 14)
-	pdf.Cell(0, 10, "Payment Information")
-	pdf.Ln(10)
-
+	pdf.CellFormat(30, 10, fmt.Sprintf("KES %.2f", totals.Total), "1", 1, "R", true, 0, "")
 	pdf.SetTextColor(0, 0, 0)
-	pdf.SetFont("Arial", "", 12)
-	pdf.Cell(50, 8, "Payment Method:")
-	pdf.SetFont("Arial", "B", 12)
-	pdf.Cell(0, 8, order.PaymentMethod)
-	pdf.Ln(8)
+}
 
-	pdf.SetFont("Arial", "", 12)
-	pdf.Cell(50, 8, "Payment Status:")
-	pdf.SetFont("Arial", "B", 12)
-	pdf.Cell(0, 8, order.PaymentStatus)
-	pdf.Ln(8)
+func (s *PDFService) writePayment(pdf *gofpdf.Fpdf, order *models.Order) {
+	pdf.Ln(10)
+	s.writeHeading(pdf, "Payment Information")
 
+	s.writeField(pdf, "Payment Method:", order.PaymentMethod)
+	s.writeField(pdf, "Payment Status:", order.PaymentStatus)
 	if order.PaymentRef != "" {
-		pdf.SetFont("Arial", "", 12)
-		pdf.Cell(50, 8, "Payment Reference:")
-		pdf.SetFont("Arial", "B", 12)
-		pdf.Cell(0, 8, order.PaymentRef)
-		pdf.Ln(8)
+		s.writeField(pdf, "Payment Reference:", order.PaymentRef)
 	}
+}
 
-	// Footer
+// finish adds the footer and renders the document
+func (s *PDFService) finish(pdf *gofpdf.Fpdf, note string) ([]byte, error) {
 	pdf.Ln(20)
 	pdf.SetFont("Arial", "I", 10)
 	pdf.SetTextColor(128, 128, 128)
-	pdf.Cell(0, 8, "Thank you for shopping with SakiFarm Ecommerce!")
+	pdf.Cell(0, 8, note)
 	pdf.Ln(5)
 	pdf.Cell(0, 8, fmt.Sprintf("Generated on %s", time.Now().Format("January 2, 2006 at 3:04 PM")))
 
 	// Output PDF as bytes
 	var buf bytes.Buffer
-	err := pdf.Output(&buf)
-	if err != nil {
+	if err := pdf.Output(&buf); err != nil {
 		return nil, err
 	}
-	
-	return buf.Bytes(), nil
-}
-
-func (s *PDFService) GenerateInvoice(order *models.Order) ([]byte, error) {
-	pdf := gofpdf.New("P", "mm", "A4", "")
-	pdf.AddPage()
-
-	// Similar structure to receipt but formatted as invoice
-	// Set colors for branding
-	pdf.SetFillColor(255, 20, 147) // Hot pink
-	pdf.SetTextColor(0, 0, 139)   // Navy blue
-
-	// Header
-	pdf.SetFont("Arial", "B", 20)
-	pdf.CellFormat(0, 15, "SakiFarm Ecommerce", "0", 1, "C", true, 0, "")
-	pdf.Ln(5)
-
-	pdf.SetTextColor(0, 0, 0)
-	pdf.SetFont("Arial", "B", 16)
-	pdf.Cell(0, 10, "INVOICE")
-	pdf.Ln(15)
 
-	// Invoice details
-	pdf.SetFont("Arial", "", 12)
-	pdf.Cell(50, 8, "Invoice Number:")
-	pdf.SetFont("Arial", "B", 12)
-	pdf.Cell(0, 8, fmt.Sprintf("INV-%s", order.OrderNumber))
-	pdf.Ln(8)
-
-	pdf.SetFont("Arial", "", 12)
-	pdf.Cell(50, 8, "Invoice Date:")
-	pdf.SetFont("Arial", "B", 12)
-	pdf.Cell(0, 8, time.Now().Format("January 2, 2006"))
-	pdf.Ln(8)
-
-	pdf.SetFont("Arial", "", 12)
-	pdf.Cell(50, 8, "Due Date:")
-	pdf.SetFont("Arial", "B", 12)
-	pdf.Cell(0, 8, time.Now().AddDate(0, 0, 30).Format("January 2, 2006"))
-	pdf.Ln(15)
-
-	// Rest similar to receipt...
-	// (Implementation would continue similar to receipt)
-
-	var buf bytes.Buffer
-	err := pdf.Output(&buf)
-	if err != nil {
-		return nil, err
-	}
-	
 	return buf.Bytes(), nil
 }